@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/auditlog"
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/http"
+	mongorepository "github.com/klebervirgilio/go-echo-basics/storage"
+)
+
+// usersCommand manages the admin users signing in the back office:
+//
+//	mailist users create [--reset] name
+//
+// The password is read from the first line of the standard input. Once a user exists,
+// the default admin credentials are no longer accepted.
+func usersCommand(args []string) error {
+	return subcommand("users", map[string]command{
+		"create": {"create [--reset] name", createUserCommand},
+	}, args)
+}
+
+func createUserCommand(args []string) error {
+	flags := newFlagSet("users create [--reset] name")
+	reset := flags.Bool("reset", false, "replace the password of the user when it exists")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("users create: expected one user name, got %d", flags.NArg())
+	}
+	name := flags.Arg(0)
+
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	ctx := context.Background()
+	_, err = repo.FindUser(ctx, name)
+	exists := err == nil
+	if err != nil && core.KindOf(err) != core.NotFound {
+		return err
+	}
+	if exists && !*reset {
+		return fmt.Errorf("users create: %s already exists, use --reset to change the password", name)
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("users create: failed to read the password: %s", err)
+	}
+	user, err := core.NewUser(name, strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		return err
+	}
+	if err := repo.UpsertUser(ctx, user); err != nil {
+		return err
+	}
+
+	audit, err := auditlog.New(cfg, repo)
+	if err != nil {
+		return err
+	}
+	action := "user.create"
+	if exists {
+		action = "user.reset-password"
+	}
+	if err := audit.RecordAudit(ctx, core.AuditEntry{Time: time.Now(), Actor: core.ActorSystem, Action: action, Target: name}); err != nil {
+		return err
+	}
+	fmt.Printf("user %s saved\n", name)
+	return nil
+}
+
+// migrateCommand brings the Mongo collections and indexes up to date and creates the default list:
+//
+//	mailist migrate
+func migrateCommand(args []string) error {
+	flags := newFlagSet("migrate")
+	flags.Parse(args)
+
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	if err := http.SetupLists(context.Background(), repo, cfg); err != nil {
+		return err
+	}
+	fmt.Println("migrated")
+	return nil
+}
+
+// configCommand checks the configuration, printing all its problems and warnings, or prints the
+// effective settings with the secrets redacted, along the environment variables overriding them:
+//
+//	mailist config check [--ping]
+//	mailist config print
+func configCommand(args []string) error {
+	return subcommand("config", map[string]command{
+		"check": {"check [--ping]", checkConfigCommand},
+		"print": {"print", printConfigCommand},
+	}, args)
+}
+
+func checkConfigCommand(args []string) error {
+	flags := newFlagSet("config check [--ping]")
+	ping := flags.Bool("ping", false, "also connect to Mongo")
+	flags.Parse(args)
+
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+	for _, w := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if *ping {
+		repo, err := mongorepository.NewMongoRepo(cfg)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		if err := repo.Ping(context.Background()); err != nil {
+			return fmt.Errorf("failed to ping mongo: %s", err)
+		}
+	}
+	fmt.Printf("%s: ok\n", strings.Join(cfg.Files, " + "))
+	return nil
+}
+
+func printConfigCommand(args []string) error {
+	flags := newFlagSet("config print")
+	flags.Parse(args)
+
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+	settings := cfg.Redacted()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tENV\tVALUE")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%q\n", key, config.EnvName(key), settings[key])
+	}
+	return w.Flush()
+}
@@ -1,3 +1,13 @@
+var Problem = {
+  message: function(jqXHR, errorMsg) {
+    var problem = jqXHR.responseJSON;
+    if (!problem || !problem.title) {
+      return 'Oops... Something wrong is not right. ' + errorMsg;
+    }
+    return problem.title + (problem.detail ? ': ' + problem.detail : '') + ' (error ID ' + problem.errorId + ')';
+  }
+};
+
 var DeleteEmail = {
   init: function() {
     DeleteEmail.bindEvents();
@@ -22,8 +32,8 @@ var DeleteEmail = {
   handleSuccess: function() {
     this.closest('tr').remove();
   },
-  handleFailure(_jqXHR, _, errorMsg) {
-    alert('Oops... Something wrong is not right. ' + errorMsg);
+  handleFailure(jqXHR, _, errorMsg) {
+    alert(Problem.message(jqXHR, errorMsg));
   }
 };
 
@@ -55,21 +65,84 @@ var ValidateEmail = {
   },
   handleSuccess: function(data) {
     if ('format_valid' in data) {
-      this.closest('tr').find('td:eq(2)').text(data.format_valid)
+      this.closest('tr').find('td.valid').text(data.format_valid)
     }
     if ('score' in data) {
-      this.closest('tr').find('td:eq(3)').text(data.score)
+      this.closest('tr').find('td.score').text(data.score)
     }
     if (data.did_you_mean) {
-      this.closest('tr').find('td:eq(4)').text(data.did_you_mean)
+      this.closest('tr').find('td.suggestion').text(data.did_you_mean)
     }
   },
-  handleFailure(_jqXHR, _, errorMsg) {
-    alert('Oops... Something wrong is not right. ' + errorMsg);
+  handleFailure(jqXHR, _, errorMsg) {
+    alert(Problem.message(jqXHR, errorMsg));
+  }
+};
+
+var SelectAll = {
+  init: function() {
+    $('.select-all').change(function() {
+      $(this).closest('table').find('tbody input[type=checkbox]').prop('checked', this.checked);
+    });
+  }
+};
+
+var SegmentPreview = {
+  timer: null,
+  init: function() {
+    $('.segment-expression').on('input', function() {
+      clearTimeout(SegmentPreview.timer);
+      SegmentPreview.timer = setTimeout(SegmentPreview.preview.bind(this), 300);
+    });
+  },
+  preview: function() {
+    var $this = $(this);
+    var $count = $($this.data('count'));
+    if (!$this.val()) {
+      $count.text('');
+      return;
+    }
+    $.ajax({ url: $this.data('preview'), type: 'GET', data: { expression: $this.val() } })
+      .done(function(data) {
+        $count.removeClass('text-danger').text(data.count + ' matching subscriptions');
+      })
+      .fail(function(jqXHR, _, errorMsg) {
+        $count.addClass('text-danger').text(Problem.message(jqXHR, errorMsg));
+      });
+  }
+};
+
+var JobProgress = {
+  init: function() {
+    var $job = $('.job-progress');
+    if ($job.length && !$job.data('finished')) {
+      setTimeout(JobProgress.poll.bind($job), 1000);
+    }
+  },
+  poll: function() {
+    var $job = this;
+    $.ajax({ url: $job.data('status'), type: 'GET', dataType: 'json' })
+      .done(function(job) {
+        var percent = job.total ? Math.floor(job.done * 100 / job.total) : 100;
+        $job.find('.progress-bar').css('width', percent + '%').text(percent + '%');
+        $job.find('.job-done').text(job.done);
+        $job.find('.job-failed').text(job.failed);
+        if (job.finished) {
+          window.location.reload();
+          return;
+        }
+        setTimeout(JobProgress.poll.bind($job), 1000);
+      })
+      .fail(function(jqXHR, _, errorMsg) {
+        $job.find('.job-error').text(Problem.message(jqXHR, errorMsg));
+      });
   }
 };
 
 $(document).ready(function() {
   DeleteEmail.init();
   ValidateEmail.init();
+  SelectAll.init();
+  SegmentPreview.init();
+  JobProgress.init();
 });
@@ -0,0 +1,206 @@
+// Package auditlog stores the audit trail in a JSON Lines file, one entry per line, as an
+// alternative to the Mongo collection for the deployments shipping their logs elsewhere.
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
+)
+
+// maxLine is the longest entry the file may hold.
+const maxLine = 1 << 20
+
+// New opens the audit trail sink of the `audit.sink` setting: "mongo" stores it with the
+// repository, "file" appends it to the JSON Lines file at `audit.file`.
+func New(cfg *config.Config, repository gdpr.AuditStore) (gdpr.AuditStore, error) {
+	switch sink := cfg.Audit.Sink; sink {
+	case "mongo":
+		return repository, nil
+	case "file":
+		return Open(cfg.Audit.File)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", sink)
+	}
+}
+
+// File is an audit trail appended to a JSON Lines file.
+type File struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open opens the audit file at path, creating it when missing.
+func Open(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+// RecordAudit appends the entry to the file and flushes it to the disk.
+func (a *File) RecordAudit(ctx context.Context, entry core.AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+// FindAudit scans the file for the entries matching filter, the most recent first.
+func (a *File) FindAudit(ctx context.Context, filter core.AuditFilter) ([]core.AuditEntry, error) {
+	var entries []core.AuditEntry
+	err := a.each(ctx, func(entry core.AuditEntry) error {
+		if matches(entry, filter) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// AnonymizeAudit replaces the email by pseudonym in the entries targeting it, and drops their states.
+// The file is rewritten, which is the only change made to the past entries.
+func (a *File) AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.path), filepath.Base(a.path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n := 0
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	err = a.scan(ctx, func(entry core.AuditEntry) error {
+		if strings.EqualFold(entry.Target, email) {
+			entry.Target, entry.Before, entry.After = pseudonym, nil, nil
+			n++
+		}
+		return enc.Encode(entry)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return n, err
+	}
+	a.f.Close()
+	a.f = f
+	return n, nil
+}
+
+// Close closes the file.
+func (a *File) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// each is scan holding the lock, so that the file is not replaced while it is read.
+func (a *File) each(ctx context.Context, fn func(core.AuditEntry) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.scan(ctx, fn)
+}
+
+// scan calls fn for every entry of the file, in the order they were recorded.
+func (a *File) scan(ctx context.Context, fn func(core.AuditEntry) error) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), maxLine)
+	for s.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var entry core.AuditEntry
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			return fmt.Errorf("corrupted audit file %s: %v", a.path, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func matches(entry core.AuditEntry, filter core.AuditFilter) bool {
+	switch {
+	case filter.Actor != "" && entry.Actor != filter.Actor:
+		return false
+	case filter.Action != "" && entry.Action != filter.Action:
+		return false
+	case filter.Target != "" && !strings.EqualFold(entry.Target, filter.Target):
+		return false
+	case len(filter.Targets) > 0 && !targets(entry, filter.Targets):
+		return false
+	case !filter.Since.IsZero() && entry.Time.Before(filter.Since):
+		return false
+	case !filter.Until.IsZero() && !entry.Time.Before(filter.Until):
+		return false
+	}
+	return true
+}
+
+// targets reports whether the entry targets one of the emails.
+func targets(entry core.AuditEntry, emails []string) bool {
+	for _, email := range emails {
+		if strings.EqualFold(entry.Target, email) {
+			return true
+		}
+	}
+	return false
+}
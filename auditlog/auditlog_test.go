@@ -0,0 +1,195 @@
+package auditlog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// openTemp opens an audit file in a new temporary directory, removed by the returned function.
+func openTemp(t *testing.T) (*File, string, func()) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.jsonl")
+	a, err := Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return a, path, func() {
+		a.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// actions returns the actions of the entries, in order.
+func actions(entries []core.AuditEntry) string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Action)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestFindAudit(t *testing.T) {
+	a, path, done := openTemp(t)
+	defer done()
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	// Recorded out of order: the entries are returned the most recent first.
+	for _, e := range []core.AuditEntry{
+		{Time: now.Add(-2 * time.Hour), Actor: "golang", Action: "list.save", Target: "news"},
+		{Time: now, Actor: core.ActorSubscriber, Action: "unsubscribe", Target: "Ada@Example.com", List: "news"},
+		{Time: now.Add(-3 * time.Hour), Actor: "golang", Action: "subscription.tag", Target: "ada@example.com", After: map[string]interface{}{"tags": []interface{}{"vip"}}},
+		{Time: now.Add(-time.Hour), Actor: "golang", Action: "subscription.trash", Target: "bob@example.com"},
+	} {
+		if err := a.RecordAudit(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		name   string
+		filter core.AuditFilter
+		want   string
+	}{
+		{"all", core.AuditFilter{}, "unsubscribe,subscription.trash,list.save,subscription.tag"},
+		{"actor", core.AuditFilter{Actor: "golang"}, "subscription.trash,list.save,subscription.tag"},
+		{"action", core.AuditFilter{Action: "list.save"}, "list.save"},
+		{"target", core.AuditFilter{Target: "ADA@example.com"}, "unsubscribe,subscription.tag"},
+		{"targets", core.AuditFilter{Targets: []string{"bob@example.com", "ada@EXAMPLE.com"}}, "unsubscribe,subscription.trash,subscription.tag"},
+		{"since", core.AuditFilter{Since: now.Add(-time.Hour)}, "unsubscribe,subscription.trash"},
+		{"until", core.AuditFilter{Until: now.Add(-time.Hour)}, "list.save,subscription.tag"},
+		{"limit", core.AuditFilter{Actor: "golang", Limit: 2}, "subscription.trash,list.save"},
+		{"none", core.AuditFilter{Actor: "nobody"}, ""},
+	} {
+		entries, err := a.FindAudit(ctx, c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := actions(entries); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	// The entries are kept as recorded, and survive the file being opened again.
+	a.Close()
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	entries, err := reopened.FindAudit(ctx, core.AuditFilter{Action: "subscription.tag"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %+v, %v", entries, err)
+	}
+	if e := entries[0]; !e.Time.Equal(now.Add(-3*time.Hour)) || e.Actor != "golang" || e.Target != "ada@example.com" || e.After["tags"].([]interface{})[0] != "vip" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestFindAuditCorruptedFile(t *testing.T) {
+	a, path, done := openTemp(t)
+	defer done()
+	if err := ioutil.WriteFile(path, []byte("{\"action\":\"list.save\"}\n\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.FindAudit(context.Background(), core.AuditFilter{}); err == nil || !strings.Contains(err.Error(), "corrupted audit file") {
+		t.Errorf("got %v, want the file reported as corrupted", err)
+	}
+}
+
+func TestAnonymizeAudit(t *testing.T) {
+	a, path, done := openTemp(t)
+	defer done()
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, e := range []core.AuditEntry{
+		{Time: now.Add(-time.Hour), Actor: "golang", Action: "subscription.tag", Target: "Ada@Example.com", IP: "192.0.2.1", Before: map[string]interface{}{"tags": nil}, After: map[string]interface{}{"tags": []interface{}{"vip"}}},
+		{Time: now, Actor: "golang", Action: "subscription.tag", Target: "bob@example.com", After: map[string]interface{}{"tags": []interface{}{"vip"}}},
+	} {
+		if err := a.RecordAudit(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := a.AnonymizeAudit(ctx, "ada@example.com", "erased:1234")
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v, want 1 entry anonymised", n, err)
+	}
+	entries, err := a.FindAudit(ctx, core.AuditFilter{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("got %+v, %v", entries, err)
+	}
+	if bob := entries[0]; bob.Target != "bob@example.com" || bob.After == nil {
+		t.Errorf("got %+v, want the other entries unchanged", bob)
+	}
+	if ada := entries[1]; ada.Target != "erased:1234" || ada.Before != nil || ada.After != nil || ada.Action != "subscription.tag" {
+		t.Errorf("got %+v, want the entry anonymised", ada)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.ToLower(string(b)), "ada@example.com") {
+		t.Errorf("the file still names the email: %s", b)
+	}
+
+	// The entries recorded next are appended to the rewritten file.
+	if err := a.RecordAudit(ctx, core.AuditEntry{Time: now.Add(time.Hour), Action: "gdpr.erase", Target: "erased:1234"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := a.FindAudit(ctx, core.AuditFilter{Target: "erased:1234"}); err != nil || actions(entries) != "gdpr.erase,subscription.tag" {
+		t.Errorf("got %+v, %v", entries, err)
+	}
+
+	// Nothing to anonymise leaves the file as is, and no temporary file behind.
+	if n, err := a.AnonymizeAudit(ctx, "nobody@example.com", "erased:5678"); err != nil || n != 0 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil || len(files) != 1 {
+		t.Errorf("got the files %v, %v, want the audit file only", files, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, path, done := openTemp(t)
+	defer done()
+	cfg := &config.Config{}
+
+	cfg.Audit.Sink = "mongo"
+	if store, err := New(cfg, a); err != nil || store != a {
+		t.Errorf("got %v, %v, want the repository", store, err)
+	}
+
+	cfg.Audit.Sink, cfg.Audit.File = "file", path
+	store, err := New(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := store.(*File)
+	if !ok {
+		t.Fatalf("got %#v, want a file", store)
+	}
+	defer f.Close()
+	if f.path != path {
+		t.Errorf("got the file %s, want %s", f.path, path)
+	}
+
+	cfg.Audit.Sink = "syslog"
+	if _, err := New(cfg, nil); err == nil || !strings.Contains(err.Error(), `unknown audit sink "syslog"`) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -1,32 +1,265 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/klebervirgilio/go-echo-basics/segment"
+
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// envPrefix prefixes the environment variables overriding the settings, see EnvName.
+const envPrefix = "MAILIST_"
+
+// Config is the configuration read from the CONF_FILE file, both as typed Settings and as viper keys.
 type Config struct {
 	*viper.Viper
+	Settings
+	// Files are the configuration files read, the base file then its environment overlay.
+	Files []string
+	// Warnings are the problems which do not prevent the app from running, such as unknown keys.
+	Warnings []string
+}
+
+// Error lists all the problems found in the configuration, along its warnings which may
+// explain them, such as a misspelled key.
+type Error struct {
+	Files    []string
+	Problems []string
+	Warnings []string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("invalid configuration %s:\n  - %s", strings.Join(e.Files, " + "), strings.Join(e.Problems, "\n  - "))
+	if len(e.Warnings) > 0 {
+		msg += "\nwarnings:\n  - " + strings.Join(e.Warnings, "\n  - ")
+	}
+	return msg
 }
 
-func (c Config) MustGetString(key string) string {
-	v := c.GetString(key)
-	if v == "" {
-		panic(fmt.Sprintf("%s is empty", key))
+// New reads the configuration, and validates it. All the problems found are reported at once
+// by an *Error. The settings are layered, each layer overriding the previous ones:
+//
+//   - the defaults,
+//   - the CONF_FILE file,
+//   - when MAILIST_ENV is set, the overlay file named after it next to CONF_FILE, such as
+//     config.production.yaml for config.yaml,
+//   - the environment variables named after the keys, see EnvName,
+//   - the files named by these variables suffixed with _FILE, holding secrets such as
+//     MAILIST_MONGO_URI_FILE.
+func New() (*Config, error) {
+	file := os.Getenv("CONF_FILE")
+	if file == "" {
+		return nil, errors.New("CONF_FILE is empty")
+	}
+	files := []string{file}
+	if env := os.Getenv(envPrefix + "ENV"); env != "" {
+		ext := filepath.Ext(file)
+		files = append(files, strings.TrimSuffix(file, ext)+"."+env+ext)
 	}
-	return v
+	return load(files)
+}
+
+// EnvName returns the environment variable overriding the setting key, such as
+// MAILIST_MAIL_CHECKER_ACCESS_KEY for mailChecker.accessKey.
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(camelRE.ReplaceAllString(key, "${1}_${2}"), ".", "_", -1))
 }
 
-func New() *Config {
+var camelRE = regexp.MustCompile("([a-z0-9])([A-Z])")
+
+// Defaults returns the default configuration, without reading any file nor the environment.
+// The settings without default, such as mongo.uri, are left empty.
+func Defaults() *Config {
 	c := &Config{Viper: viper.New()}
-	c.BindEnv("CONF_FILE")
-	c.BindEnv("mailChecker.accessKey", "MAIL_CHECKER_ACCESS_KEY")
-	c.SetConfigFile(c.MustGetString("CONF_FILE"))
+	setDefaults(c.Viper)
+	c.Settings, c.Warnings, _ = decode(c.Viper)
+	return c
+}
 
-	if err := c.ReadInConfig(); err != nil {
-		log.Fatalf("Failed to load conf file: %s", err)
+// load reads the configuration files over the defaults, then the environment, and validates it.
+func load(files []string) (*Config, error) {
+	c := &Config{Viper: viper.New(), Files: files}
+	setDefaults(c.Viper)
+
+	for i, file := range files {
+		c.SetConfigFile(file)
+		read := c.MergeInConfig
+		if i == 0 {
+			read = c.ReadInConfig
+		}
+		if err := read(); err != nil {
+			return nil, fmt.Errorf("Failed to load conf file: %s", err)
+		}
 	}
-	return c
+
+	problems := bindEnv(c.Viper)
+	settings, warnings, decodeProblems := decode(c.Viper)
+	problems = append(problems, decodeProblems...)
+	c.Settings, c.Warnings = settings, warnings
+	// The settings which could not be decoded are zero, so their validation problems are noise.
+	undecoded := map[string]bool{}
+	for _, p := range problems {
+		undecoded[strings.ToLower(strings.SplitN(p, ":", 2)[0])] = true
+	}
+	for _, p := range settings.Validate() {
+		if !undecoded[strings.ToLower(strings.SplitN(p, ":", 2)[0])] {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, &Error{Files: files, Problems: problems, Warnings: warnings}
+	}
+	return c, nil
+}
+
+// setDefaults sets the default value of the settings.
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("bindAddr", ":4000")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "json")
+	v.SetDefault("shutdownTimeout", 15*time.Second)
+	v.SetDefault("shutdownDelay", 0)
+	v.SetDefault("metrics.public", false)
+	v.SetDefault("health.timeout", 2*time.Second)
+	v.SetDefault("health.mailChecker", false)
+	v.SetDefault("tracing.exporter", "none")
+	v.SetDefault("tracing.endpoint", "http://localhost:4318")
+	v.SetDefault("tracing.serviceName", "mailist")
+	v.SetDefault("tracing.timeout", 10*time.Second)
+	v.SetDefault("lists.default.slug", "default")
+	v.SetDefault("lists.default.name", "Mailist")
+	v.SetDefault("mongo.collection.name", "subscriptions")
+	v.SetDefault("mongo.lists.collection.name", "lists")
+	v.SetDefault("mongo.segments.collection.name", "segments")
+	v.SetDefault("mongo.audit.collection.name", "audit")
+	v.SetDefault("mongo.verifications.collection.name", "verifications")
+	v.SetDefault("mongo.tombstones.collection.name", "tombstones")
+	v.SetDefault("mongo.consents.collection.name", "consents")
+	v.SetDefault("mongo.users.collection.name", "users")
+	v.SetDefault("consent.version", "1")
+	v.SetDefault("consent.text", "I agree to receive the e-mails of this list and to the processing of my name and e-mail address to send them. I can unsubscribe at any time.")
+	v.SetDefault("audit.sink", "mongo")
+	v.SetDefault("audit.file", "audit.jsonl")
+	v.SetDefault("retention.interval", time.Hour)
+	v.SetDefault("retention.dryRun", false)
+	v.SetDefault("retention.trash", "30d")
+	v.SetDefault("mongo.timeout", 5*time.Second)
+	v.SetDefault("mail.transport", "smtp")
+	v.SetDefault("mail.file", "mail.log")
+	v.SetDefault("mail.from", "Mailist <mailist@localhost>")
+	v.SetDefault("mail.baseURL", "http://localhost:4000")
+	v.SetDefault("mail.smtp.addr", "localhost:25")
+	v.SetDefault("mail.timeout", 10*time.Second)
+	v.SetDefault("mailChecker.url", "http://apilayer.net/api/check?access_key=%s&smtp=1&format=&email=")
+	v.SetDefault("mailChecker.timeout", 10*time.Second)
+	v.SetDefault("validation.concurrency", 10)
+}
+
+// bindEnv binds the settings to their environment variables, and sets the ones having a _FILE
+// variable to the content of the file. It returns the secret files which cannot be read.
+func bindEnv(v *viper.Viper) []string {
+	var problems []string
+	for _, key := range settingKeys() {
+		name := EnvName(key)
+		v.BindEnv(key, name)
+		file := os.Getenv(name + "_FILE")
+		if file == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			problems = append(problems, fmt.Sprintf("%s: both %s and %s_FILE are set", key, name, name))
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, err))
+			continue
+		}
+		v.Set(key, strings.TrimRight(string(b), "\r\n"))
+	}
+	// MAIL_CHECKER_ACCESS_KEY predates the MAILIST_ variables.
+	name := EnvName("mailChecker.accessKey")
+	if key := os.Getenv("MAIL_CHECKER_ACCESS_KEY"); key != "" && os.Getenv(name) == "" && os.Getenv(name+"_FILE") == "" {
+		v.Set("mailChecker.accessKey", key)
+	}
+	return problems
+}
+
+// decode unmarshals the viper keys into Settings. The keys matching no setting are returned
+// as warnings, and the values which cannot be decoded as problems.
+func decode(v *viper.Viper) (Settings, []string, []string) {
+	var (
+		settings Settings
+		problems []string
+	)
+	err := v.Unmarshal(&settings, func(c *mapstructure.DecoderConfig) {
+		c.DecodeHook = mapstructure.ComposeDecodeHookFunc(durationHook, mapstructure.StringToSliceHookFunc(","))
+	})
+	if merr, ok := err.(*mapstructure.Error); ok {
+		for _, msg := range merr.Errors {
+			// mapstructure reports "error decoding 'key': reason".
+			problems = append(problems, decodeErrorRE.ReplaceAllString(msg, "$1: "))
+		}
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	keys := map[string]bool{}
+	known := map[string]string{}
+	for _, key := range settingKeys() {
+		keys[strings.ToLower(key)] = true
+		known[normalizeKey(key)] = key
+	}
+	var warnings []string
+	for _, key := range v.AllKeys() {
+		if keys[key] {
+			continue
+		}
+		if suggestion, ok := known[normalizeKey(key)]; ok {
+			warnings = append(warnings, fmt.Sprintf("unknown key %s, did you mean %s?", key, suggestion))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("unknown key %s", key))
+		}
+	}
+	sort.Strings(warnings)
+	return settings, warnings, problems
+}
+
+var decodeErrorRE = regexp.MustCompile(`^error decoding '([^']*)': `)
+
+// durationHook decodes the durations such as "15s", and days such as "30d".
+func durationHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Duration(0)) {
+		return data, nil
+	}
+	if data.(string) == "" {
+		return time.Duration(0), nil
+	}
+	return segment.ParseDuration(data.(string))
+}
+
+// settingKeys returns the dotted keys of the settings.
+func settingKeys() []string {
+	var keys []string
+	walk(reflect.ValueOf(Settings{}), "", func(key string, _ reflect.StructField, _ reflect.Value) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// normalizeKey folds the spellings of a key, such as "accessKey" and "access_key".
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
 }
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"bindAddr":                         "MAILIST_BIND_ADDR",
+		"log.level":                        "MAILIST_LOG_LEVEL",
+		"mongo.uri":                        "MAILIST_MONGO_URI",
+		"mongo.collection.name":            "MAILIST_MONGO_COLLECTION_NAME",
+		"mailChecker.accessKey":            "MAILIST_MAIL_CHECKER_ACCESS_KEY",
+		"mail.smtp.password":               "MAILIST_MAIL_SMTP_PASSWORD",
+		"mail.baseURL":                     "MAILIST_MAIL_BASE_URL",
+		"gdpr.hashKey":                     "MAILIST_GDPR_HASH_KEY",
+		"tracing.serviceName":              "MAILIST_TRACING_SERVICE_NAME",
+		"mongo.users.collection.name":      "MAILIST_MONGO_USERS_COLLECTION_NAME",
+		"retention.verifications":          "MAILIST_RETENTION_VERIFICATIONS",
+		"validation.concurrency":           "MAILIST_VALIDATION_CONCURRENCY",
+		"mongo.tombstones.collection.name": "MAILIST_MONGO_TOMBSTONES_COLLECTION_NAME",
+	} {
+		if got := EnvName(key); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// setenv sets the environment variables, and returns a function restoring them.
+func setenv(t *testing.T, vars map[string]string) func() {
+	saved := map[string]*string{}
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			saved[name] = &old
+		} else {
+			saved[name] = nil
+		}
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name, old := range saved {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestBindEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "uri")
+	if err := ioutil.WriteFile(secret, []byte("mongodb://user:secret@db:27017\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setenv(t, map[string]string{
+		"MAILIST_LOG_LEVEL": "debug",
+		// The file is read, its trailing newline trimmed.
+		"MAILIST_MONGO_URI_FILE": secret,
+		// Both the variable and the file are set.
+		"MAILIST_MAIL_SMTP_PASSWORD":      "password",
+		"MAILIST_MAIL_SMTP_PASSWORD_FILE": secret,
+		// The file can't be read.
+		"MAILIST_GDPR_HASH_KEY_FILE": filepath.Join(dir, "missing"),
+		// The legacy variable is overridden by the MAILIST_ one.
+		"MAIL_CHECKER_ACCESS_KEY":         "legacy",
+		"MAILIST_MAIL_CHECKER_ACCESS_KEY": "key",
+	})()
+
+	v := viper.New()
+	setDefaults(v)
+	problems := bindEnv(v)
+	want := []string{
+		"gdpr.hashKey: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+		"mail.smtp.password: both MAILIST_MAIL_SMTP_PASSWORD and MAILIST_MAIL_SMTP_PASSWORD_FILE are set",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("got problems %q, want %q", problems, want)
+	}
+	for key, want := range map[string]string{
+		"log.level":             "debug",
+		"mongo.uri":             "mongodb://user:secret@db:27017",
+		"mailChecker.accessKey": "key",
+		"bindAddr":              ":4000",
+	} {
+		if got := v.GetString(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestBindEnvLegacyAccessKey(t *testing.T) {
+	defer setenv(t, map[string]string{"MAIL_CHECKER_ACCESS_KEY": "legacy"})()
+	v := viper.New()
+	if problems := bindEnv(v); len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	if got := v.GetString("mailChecker.accessKey"); got != "legacy" {
+		t.Errorf("got %q, want the legacy variable", got)
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce groups the events of a single save, editors often writing a file in several steps.
+const watchDebounce = 100 * time.Millisecond
+
+// Watch reloads the configuration files each time one of them changes, until ctx is done, and
+// passes the new configuration to apply. The versions failing to load or validate are passed as
+// an error instead, the configuration being otherwise left unchanged.
+//
+// The directories of the files are watched, so that the files replaced by a rename, such as the
+// Kubernetes config maps swapping a symlink, are reloaded too.
+func (c *Config) Watch(ctx context.Context, apply func(*Config, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	realFiles := map[string]string{}
+	for _, file := range c.Files {
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return err
+		}
+		realFiles[filepath.Clean(file)], _ = filepath.EvalSymlinks(file)
+	}
+
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			apply(nil, err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			for file, realFile := range realFiles {
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if written || (current != "" && current != realFile) {
+					realFiles[file] = current
+					debounce.Reset(watchDebounce)
+				}
+			}
+		case <-debounce.C:
+			apply(load(c.Files))
+		}
+	}
+}
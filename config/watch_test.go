@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// validConfig sets the settings without default.
+const validConfig = `
+mongo:
+  uri: mongodb://localhost:27017
+  database:
+    name: mailist
+mailChecker:
+  accessKey: key
+gdpr:
+  hashKey: 0123456789abcdef0123456789abcdef
+`
+
+type reload struct {
+	cfg *Config
+	err error
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(validConfig)
+	cfg, err := load([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan reload, 10)
+	done := make(chan error)
+	go func() {
+		done <- cfg.Watch(ctx, func(cfg *Config, err error) { reloads <- reload{cfg, err} })
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+	next := func() reload {
+		select {
+		case r := <-reloads:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("the change has not been reloaded")
+			return reload{}
+		}
+	}
+	// Let the watcher start.
+	time.Sleep(50 * time.Millisecond)
+
+	write(validConfig + "validation:\n  concurrency: 3\n")
+	if r := next(); r.err != nil || r.cfg.Validation.Concurrency != 3 {
+		t.Errorf("got %+v, want the concurrency reloaded", r)
+	}
+
+	write(validConfig + "validation:\n  concurrency: -1\n")
+	r := next()
+	if e, ok := r.err.(*Error); !ok || r.cfg != nil || len(e.Problems) != 1 || e.Problems[0] != "validation.concurrency: must be positive" {
+		t.Errorf("got %+v, want the invalid edit rejected", r)
+	}
+}
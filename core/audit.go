@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Audit actors which are not admin users.
+const (
+	ActorSubscriber = "subscriber"
+	ActorSystem     = "system"
+)
+
+// AuditEntry records a change made to the stored data: who made it, when, from where,
+// and the state of the target before and after the change.
+type AuditEntry struct {
+	Time time.Time `bson:"time" json:"time"`
+	// Actor is the admin user name, or ActorSubscriber or ActorSystem.
+	Actor string `bson:"actor" json:"actor"`
+	// Action is a dotted name such as "preferences.update".
+	Action string `bson:"action" json:"action"`
+	// Target identifies the changed entity, such as the email of a subscription.
+	Target string                 `bson:"target" json:"target"`
+	List   string                 `bson:"list,omitempty" json:"list,omitempty"`
+	IP     string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	Before map[string]interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  map[string]interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditLog stores the audit trail. Entries are never removed, and only updated by the
+// erasure of an email, which anonymises the entries targeting it.
+type AuditLog interface {
+	RecordAudit(ctx context.Context, entry AuditEntry) error
+}
+
+// AuditFilter selects audit entries. Zero values match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	// Targets matches the entries targeting any of the emails, case insensitively.
+	Targets []string
+	Since   time.Time
+	Until   time.Time
+	// Limit caps the number of entries returned, the most recent first.
+	Limit int
+}
+
+// AuditReader queries the audit trail.
+type AuditReader interface {
+	FindAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// ConsentText is the consent statement shown by the subscribe form. The version changes
+// whenever the text does, so that each consent refers to the exact text agreed to.
+type ConsentText struct {
+	Version string
+	Text    string
+}
+
+// Consent is the evidence of the consent given by a subscriber to a list.
+// Consents are only updated with the confirmation time of double opt-in lists, and anonymised
+// by the erasure of their email.
+type Consent struct {
+	Email string `bson:"email" json:"email"`
+	List  string `bson:"list" json:"list"`
+	// Form identifies where the consent was given: "subscribe", "preferences" or "import",
+	// whose Text is the source of the consent given by the administrator.
+	Form        string     `bson:"form" json:"form"`
+	GivenAt     time.Time  `bson:"givenAt" json:"given_at"`
+	IP          string     `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent   string     `bson:"userAgent,omitempty" json:"user_agent,omitempty"`
+	TextVersion string     `bson:"textVersion" json:"text_version"`
+	Text        string     `bson:"text" json:"text"`
+	ConfirmedAt *time.Time `bson:"confirmedAt,omitempty" json:"confirmed_at,omitempty"`
+}
+
+// ConsentRepository stores the consents history.
+type ConsentRepository interface {
+	RecordConsent(ctx context.Context, consent Consent) error
+	// ConfirmConsent sets the confirmation time of the unconfirmed consents to the list.
+	ConfirmConsent(ctx context.Context, list, email string, at time.Time) error
+	// FindConsents returns the consents given by the email, case insensitively, the most recent first.
+	FindConsents(ctx context.Context, email string) ([]Consent, error)
+	// AnonymizeConsents replaces the email by pseudonym in its consents, and drops the IP and user agent.
+	AnonymizeConsents(ctx context.Context, email, pseudonym string) (int, error)
+}
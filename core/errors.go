@@ -0,0 +1,76 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind classifies the domain errors so the callers can react to them
+// without knowing which storage or provider produced them.
+type Kind uint8
+
+const (
+	// Internal is the kind of every error not produced by this package.
+	Internal Kind = iota
+	// NotFound means the requested subscription does not exist.
+	NotFound
+	// AlreadyExists means the subscription conflicts with an existing one.
+	AlreadyExists
+	// InvalidInput means the given data was rejected.
+	InvalidInput
+	// ProviderUnavailable means an external service could not be reached or failed.
+	ProviderUnavailable
+	// QuotaExceeded means an external service refused the request due to usage limits.
+	QuotaExceeded
+)
+
+var kindNames = map[Kind]string{
+	Internal:            "internal",
+	NotFound:            "not_found",
+	AlreadyExists:       "already_exists",
+	InvalidInput:        "invalid_input",
+	ProviderUnavailable: "provider_unavailable",
+	QuotaExceeded:       "quota_exceeded",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Error is a domain error.
+type Error struct {
+	Kind Kind
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf builds a domain error of the given kind.
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+// WrapError builds a domain error of the given kind that keeps the original error as its cause.
+func WrapError(kind Kind, err error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+// KindOf returns the kind of the first domain error found in err's chain, or Internal.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
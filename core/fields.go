@@ -0,0 +1,164 @@
+package core
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldType is the type of the values of a custom field.
+type FieldType string
+
+// Custom field types.
+const (
+	FieldText        FieldType = "text"
+	FieldNumber      FieldType = "number"
+	FieldBoolean     FieldType = "boolean"
+	FieldSelect      FieldType = "select"
+	FieldMultiSelect FieldType = "multiselect"
+)
+
+// FieldTypes are the supported field types, in the order they are offered to the admins.
+var FieldTypes = []FieldType{FieldText, FieldNumber, FieldBoolean, FieldSelect, FieldMultiSelect}
+
+// Field defines a custom subscriber field asked by the subscribe form of a list.
+// The values are stored in Subscription.Fields under the field name: strings for
+// text and select fields, float64 for numbers, bool for booleans and []string for multiselects.
+type Field struct {
+	Name     string    `bson:"name"`
+	Label    string    `bson:"label"`
+	Type     FieldType `bson:"type"`
+	Required bool      `bson:"required"`
+	// Options are the allowed values of select and multiselect fields.
+	Options []string `bson:"options,omitempty"`
+}
+
+var fieldNameRE = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+// reservedFieldNames are the subscription attributes, which custom fields can't shadow in exports and filters.
+var reservedFieldNames = map[string]bool{
+	"email": true, "name": true, "list": true, "status": true, "valid": true,
+	"score": true, "suggestion": true, "tags": true, "fields": true,
+}
+
+// Validate checks the field definition can be stored.
+func (f Field) Validate() error {
+	if !fieldNameRE.MatchString(f.Name) {
+		return Errorf(InvalidInput, "Invalid field name %q: use lowercase letters, digits and underscores", f.Name)
+	}
+	if reservedFieldNames[f.Name] {
+		return Errorf(InvalidInput, "The field name %q is reserved", f.Name)
+	}
+	switch f.Type {
+	case FieldText, FieldNumber, FieldBoolean:
+		if len(f.Options) > 0 {
+			return Errorf(InvalidInput, "The %s field %q can't have options", f.Type, f.Name)
+		}
+	case FieldSelect, FieldMultiSelect:
+		if len(f.Options) == 0 {
+			return Errorf(InvalidInput, "The %s field %q needs options", f.Type, f.Name)
+		}
+	default:
+		return Errorf(InvalidInput, "Invalid type %q for the field %q", f.Type, f.Name)
+	}
+	return nil
+}
+
+// DisplayLabel returns the label of the field, or its name when it has none.
+func (f Field) DisplayLabel() string {
+	if f.Label != "" {
+		return f.Label
+	}
+	return f.Name
+}
+
+// Parse converts the submitted form values of the field to the stored value.
+// It returns nil when an optional field is left blank.
+func (f Field) Parse(values []string) (interface{}, error) {
+	var nonEmpty []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		if f.Required {
+			return nil, Errorf(InvalidInput, "%s is required", f.DisplayLabel())
+		}
+		if f.Type == FieldBoolean {
+			return false, nil
+		}
+		return nil, nil
+	}
+
+	switch f.Type {
+	case FieldNumber:
+		n, err := strconv.ParseFloat(nonEmpty[0], 64)
+		if err != nil {
+			return nil, Errorf(InvalidInput, "%s must be a number", f.DisplayLabel())
+		}
+		return n, nil
+	case FieldBoolean:
+		b, err := strconv.ParseBool(nonEmpty[0])
+		if err != nil {
+			return nil, Errorf(InvalidInput, "%s must be true or false", f.DisplayLabel())
+		}
+		if f.Required && !b {
+			return nil, Errorf(InvalidInput, "%s is required", f.DisplayLabel())
+		}
+		return b, nil
+	case FieldSelect:
+		if !f.allows(nonEmpty[0]) {
+			return nil, Errorf(InvalidInput, "%q is not a valid choice for %s", nonEmpty[0], f.DisplayLabel())
+		}
+		return nonEmpty[0], nil
+	case FieldMultiSelect:
+		for _, v := range nonEmpty {
+			if !f.allows(v) {
+				return nil, Errorf(InvalidInput, "%q is not a valid choice for %s", v, f.DisplayLabel())
+			}
+		}
+		return nonEmpty, nil
+	}
+	return nonEmpty[0], nil
+}
+
+func (f Field) allows(value string) bool {
+	for _, o := range f.Options {
+		if o == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFields converts the submitted values of the list fields, given by field name, to the
+// values stored in Subscription.Fields. It returns the first validation error.
+func (l List) ParseFields(values map[string][]string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	for _, f := range l.Fields {
+		v, err := f.Parse(values[f.Name])
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			fields[f.Name] = v
+		}
+	}
+	return fields, nil
+}
+
+// FieldNames returns the names of the custom fields of the lists, without duplicates, in order.
+func FieldNames(lists ...List) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, l := range lists {
+		for _, f := range l.Fields {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
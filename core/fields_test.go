@@ -0,0 +1,84 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldValidate(t *testing.T) {
+	for _, c := range []struct {
+		field Field
+		err   string
+	}{
+		{Field{Name: "company", Type: FieldText}, ""},
+		{Field{Name: "employees_2", Type: FieldNumber}, ""},
+		{Field{Name: "plan", Type: FieldSelect, Options: []string{"free", "pro"}}, ""},
+		{Field{Name: "topics", Type: FieldMultiSelect, Options: []string{"go"}}, ""},
+		{Field{Name: "Company", Type: FieldText}, `Invalid field name "Company": use lowercase letters, digits and underscores`},
+		{Field{Name: "2fa", Type: FieldBoolean}, `Invalid field name "2fa": use lowercase letters, digits and underscores`},
+		{Field{Name: "a.b", Type: FieldText}, `Invalid field name "a.b": use lowercase letters, digits and underscores`},
+		{Field{Name: "email", Type: FieldText}, `The field name "email" is reserved`},
+		{Field{Name: "customer", Type: FieldBoolean, Options: []string{"yes"}}, `The boolean field "customer" can't have options`},
+		{Field{Name: "plan", Type: FieldSelect}, `The select field "plan" needs options`},
+		{Field{Name: "topics", Type: FieldMultiSelect}, `The multiselect field "topics" needs options`},
+		{Field{Name: "birthday", Type: "date"}, `Invalid type "date" for the field "birthday"`},
+	} {
+		err := c.field.Validate()
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%+v: got %v", c.field, err)
+		case c.err != "" && (err == nil || err.Error() != c.err || KindOf(err) != InvalidInput):
+			t.Errorf("%+v: got %v, want %q", c.field, err, c.err)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	list := List{Fields: []Field{
+		{Name: "company", Label: "Company", Type: FieldText},
+		{Name: "employees", Type: FieldNumber},
+		{Name: "customer", Type: FieldBoolean},
+		{Name: "terms", Label: "Terms", Type: FieldBoolean, Required: true},
+		{Name: "plan", Type: FieldSelect, Options: []string{"free", "pro"}},
+		{Name: "topics", Type: FieldMultiSelect, Options: []string{"go", "web"}},
+	}}
+	for _, c := range []struct {
+		name   string
+		values map[string][]string
+		want   map[string]interface{}
+		err    string
+	}{
+		{
+			name:   "all",
+			values: map[string][]string{"company": {" Acme "}, "employees": {"12.5"}, "customer": {"false"}, "terms": {"true"}, "plan": {"pro"}, "topics": {"go", " ", "web"}},
+			want:   map[string]interface{}{"company": "Acme", "employees": 12.5, "customer": false, "terms": true, "plan": "pro", "topics": []string{"go", "web"}},
+		},
+		{
+			name:   "optional",
+			values: map[string][]string{"terms": {"1"}, "company": {" "}, "employees": {""}, "plan": {}},
+			want:   map[string]interface{}{"customer": false, "terms": true},
+		},
+		{
+			name:   "integer",
+			values: map[string][]string{"terms": {"1"}, "employees": {"-3"}},
+			want:   map[string]interface{}{"employees": -3.0, "customer": false, "terms": true},
+		},
+		{name: "number", values: map[string][]string{"terms": {"1"}, "employees": {"12 people"}}, err: "employees must be a number"},
+		{name: "boolean", values: map[string][]string{"terms": {"1"}, "customer": {"maybe"}}, err: "customer must be true or false"},
+		{name: "missing required boolean", values: map[string][]string{}, err: "Terms is required"},
+		{name: "unchecked required boolean", values: map[string][]string{"terms": {"false"}}, err: "Terms is required"},
+		{name: "select", values: map[string][]string{"terms": {"1"}, "plan": {"Pro"}}, err: `"Pro" is not a valid choice for plan`},
+		{name: "multiselect", values: map[string][]string{"terms": {"1"}, "topics": {"go", "rust"}}, err: `"rust" is not a valid choice for topics`},
+	} {
+		got, err := list.ParseFields(c.values)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err || KindOf(err) != InvalidInput {
+				t.Errorf("%s: got %v, %v, want %q", c.name, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, %v, want %#v", c.name, got, err, c.want)
+		}
+	}
+}
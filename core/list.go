@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"regexp"
+)
+
+// List is a mailing list visitors subscribe to.
+type List struct {
+	Slug        string `bson:"slug"`
+	Name        string `bson:"name"`
+	Description string `bson:"description"`
+	// DoubleOptIn lists keep new subscriptions pending until their owner confirms them.
+	DoubleOptIn bool `bson:"doubleOptIn"`
+	// Fields are the custom fields asked by the subscribe form, besides the name and email.
+	Fields []Field `bson:"fields,omitempty"`
+}
+
+var slugRE = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// Validate checks the list can be stored.
+func (l List) Validate() error {
+	if !slugRE.MatchString(l.Slug) {
+		return Errorf(InvalidInput, "Invalid slug %q: use lowercase letters, digits and dashes", l.Slug)
+	}
+	if l.Name == "" {
+		return Errorf(InvalidInput, "The list name is required")
+	}
+	names := map[string]bool{}
+	for _, f := range l.Fields {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+		if names[f.Name] {
+			return Errorf(InvalidInput, "The field %q is defined twice", f.Name)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+// ListRepository abstracts the lists persistance layer.
+type ListRepository interface {
+	FindLists(ctx context.Context) ([]List, error)
+	// FindList returns a NotFound error when there is no list with the given slug.
+	FindList(ctx context.Context, slug string) (List, error)
+	UpsertList(ctx context.Context, list List) error
+}
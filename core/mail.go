@@ -0,0 +1,18 @@
+package core
+
+import "context"
+
+// Message is a plain text e-mail sent to a subscriber.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Headers are the extra headers, such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Mailer abstracts the e-mail delivery.
+// Every call is bound to the given context: implementations must give up once it is done.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,31 @@
+package core
+
+import "context"
+
+// Segment is a saved subset of the subscriptions, defined by a filter expression.
+// See the segment package for the expression language.
+type Segment struct {
+	Slug       string `bson:"slug"`
+	Name       string `bson:"name"`
+	Expression string `bson:"expression"`
+}
+
+// Validate checks the segment can be stored. The expression is checked by the segment package.
+func (s Segment) Validate() error {
+	if !slugRE.MatchString(s.Slug) {
+		return Errorf(InvalidInput, "Invalid slug %q: use lowercase letters, digits and dashes", s.Slug)
+	}
+	if s.Name == "" {
+		return Errorf(InvalidInput, "The segment name is required")
+	}
+	return nil
+}
+
+// SegmentRepository abstracts the segments persistance layer.
+type SegmentRepository interface {
+	FindSegments(ctx context.Context) ([]Segment, error)
+	// FindSegment returns a NotFound error when there is no segment with the given slug.
+	FindSegment(ctx context.Context, slug string) (Segment, error)
+	UpsertSegment(ctx context.Context, segment Segment) error
+	RemoveSegment(ctx context.Context, slug string) error
+}
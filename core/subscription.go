@@ -1,5 +1,14 @@
 package core
 
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
 // EmailVerificationResponse represents the mail checker response.
 type EmailVerificationResponse struct {
 	Email      string  `json:"email"`
@@ -8,20 +17,112 @@ type EmailVerificationResponse struct {
 	Score      float64 `json:"score"`
 }
 
-// Subscription represents a mailist subscription.
+// Subscription statuses.
+const (
+	// StatusPending subscriptions wait for their owner to confirm them.
+	StatusPending = "pending"
+	// StatusConfirmed subscriptions receive the list mailings.
+	StatusConfirmed = "confirmed"
+)
+
+// Subscription represents a mailist subscription. The same email may subscribe to several lists.
+// The Token authenticates the links sent to the subscriber, such as the confirmation link.
 type Subscription struct {
 	EmailVerificationResponse `bson:"emailVerificationResponse"`
-	Email                     string `bson:"email"`
-	Name                      string `bson:"fullName"`
+	List                      string                 `bson:"list"`
+	Email                     string                 `bson:"email"`
+	Name                      string                 `bson:"fullName"`
+	Status                    string                 `bson:"status"`
+	Token                     string                 `bson:"token"`
+	SubscribedAt              time.Time              `bson:"subscribedAt"`
+	Tags                      []string               `bson:"tags,omitempty"`
+	Fields                    map[string]interface{} `bson:"fields,omitempty"`
+	// PausedUntil suspends the deliveries to the subscriber until the given time.
+	PausedUntil time.Time `bson:"pausedUntil,omitempty"`
+	// Consent is the latest consent given to the list, also kept in the consents history.
+	Consent *Consent `bson:"consent,omitempty"`
+	// DeletedAt is the time the subscription was moved to the trash, zero when it is not in the trash.
+	DeletedAt time.Time `bson:"deletedAt,omitempty"`
+}
+
+// Paused reports whether the deliveries to the subscriber are suspended at now.
+func (s Subscription) Paused(now time.Time) bool {
+	return now.Before(s.PausedUntil)
+}
+
+// HasTag reports whether the subscription is tagged with tag.
+func (s Subscription) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// InTrash restricts selector to the subscriptions in the trash, which the repository excludes
+// otherwise. A "deletedAt" condition of selector takes precedence.
+func InTrash(selector map[string]interface{}) map[string]interface{} {
+	trashed := map[string]interface{}{"deletedAt": map[string]interface{}{"$exists": true}}
+	for k, v := range selector {
+		trashed[k] = v
+	}
+	return trashed
+}
+
+// NewToken returns a random token suitable for Subscription.Token.
+func NewToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var emailRE = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// NormalizeEmail returns the form emails are stored and looked up with: trimmed and lowercased.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidEmail performs a syntactic check on email.
+func ValidEmail(email string) bool {
+	return emailRE.MatchString(email)
 }
 
 // Repository abstracts the application persistance layer.
+// Every call is bound to the given context: implementations must give up once it is done.
+// The selectors exclude the subscriptions in the trash unless they have a "deletedAt" condition,
+// see InTrash. Remove and Upsert apply whether the subscription is in the trash or not.
 type Repository interface {
-	FindAll(selector map[string]interface{}) ([]Subscription, error)
-	Remove(selector map[string]interface{}) error
-	Upsert(subscription Subscription) error
+	FindAll(ctx context.Context, selector map[string]interface{}) ([]Subscription, error)
+	// Each calls fn for every subscription matching selector, one at a time, stopping at the first error.
+	Each(ctx context.Context, selector map[string]interface{}, fn func(Subscription) error) error
+	Count(ctx context.Context, selector map[string]interface{}) (int, error)
+	// Tag adds the tags to the subscriptions matching selector, Untag removes them.
+	// Both return the number of subscriptions changed.
+	Tag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error)
+	Untag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error)
+	// Trash moves the subscriptions matching selector to the trash, Restore takes them out.
+	// Both return the number of subscriptions changed.
+	Trash(ctx context.Context, selector map[string]interface{}) (int, error)
+	Restore(ctx context.Context, selector map[string]interface{}) (int, error)
+	// SetVerification stores the mail checker response in the subscriptions matching selector,
+	// leaving their other attributes as they are, and returns the number of subscriptions changed.
+	SetVerification(ctx context.Context, selector map[string]interface{}, resp EmailVerificationResponse) (int, error)
+	// Remove permanently deletes a subscription matching selector.
+	Remove(ctx context.Context, selector map[string]interface{}) error
+	Upsert(ctx context.Context, subscription Subscription) error
 }
 
+// MailChecker abstracts the email verification provider.
+// Every call is bound to the given context: implementations must give up once it is done.
 type MailChecker interface {
-	Validate(email string) (EmailVerificationResponse, error)
+	Validate(ctx context.Context, email string) (EmailVerificationResponse, error)
+}
+
+// Pinger is implemented by the dependencies able to report whether they are reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Tombstone remembers an erased email by its hash, so that it is not imported again by accident.
+type Tombstone struct {
+	Hash     string    `bson:"hash"`
+	ErasedAt time.Time `bson:"erasedAt"`
+}
+
+// EmailHasher hashes the emails identifying the tombstones and the anonymised records.
+// The hashes are HMACs keyed by the `gdpr.hashKey` setting, so that they can't be reversed by
+// hashing a list of known emails.
+type EmailHasher struct {
+	key []byte
+}
+
+// NewEmailHasher returns an EmailHasher keyed by key.
+func NewEmailHasher(key string) EmailHasher {
+	return EmailHasher{key: []byte(key)}
+}
+
+// Hash returns the hash of the normalised email.
+func (h EmailHasher) Hash(email string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(NormalizeEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TombstoneRepository abstracts the tombstones persistance layer.
+type TombstoneRepository interface {
+	AddTombstone(ctx context.Context, tombstone Tombstone) error
+	// ErasedEmails returns which of the given emails have a tombstone.
+	ErasedEmails(ctx context.Context, emails []string) (map[string]bool, error)
+}
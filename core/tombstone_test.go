@@ -0,0 +1,28 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEmailHasher(t *testing.T) {
+	h := NewEmailHasher("0123456789abcdef0123456789abcdef")
+	hash := h.Hash("ada@example.com")
+	if len(hash) != 64 {
+		t.Errorf("got %q, want 64 hexadecimal digits", hash)
+	}
+	if got := h.Hash("  Ada@Example.COM "); got != hash {
+		t.Errorf("the hash of the unnormalised email is %q, want %q", got, hash)
+	}
+	if got := h.Hash("bob@example.com"); got == hash {
+		t.Error("two emails have the same hash")
+	}
+	if got := NewEmailHasher("fedcba9876543210fedcba9876543210").Hash("ada@example.com"); got == hash {
+		t.Error("two keys give the same hash")
+	}
+	sum := sha256.Sum256([]byte("ada@example.com"))
+	if hash == hex.EncodeToString(sum[:]) {
+		t.Error("the hash is the unkeyed SHA-256 of the email")
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// passwordIterations is the PBKDF2 work factor of the new password hashes.
+const passwordIterations = 100000
+
+// User is an admin allowed to sign in the back office.
+type User struct {
+	Name string `bson:"name"`
+	// PasswordHash is "pbkdf2-sha256$iterations$salt$key", the salt and key base64 encoded.
+	PasswordHash string    `bson:"passwordHash"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+// NewUser returns the user name signing in with password.
+func NewUser(name, password string) (User, error) {
+	if !slugRE.MatchString(name) {
+		return User{}, Errorf(InvalidInput, "Invalid user name %q: use lowercase letters, digits and dashes", name)
+	}
+	if len(password) < 8 {
+		return User{}, Errorf(InvalidInput, "The password must have at least 8 characters")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return User{}, err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations)
+	return User{
+		Name: name,
+		PasswordHash: fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// CheckPassword reports whether password is the one of the user.
+func (u User) CheckPassword(password string) bool {
+	parts := strings.Split(u.PasswordHash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2SHA256([]byte(password), salt, iterations), key) == 1
+}
+
+// pbkdf2SHA256 derives a 32 bytes key from password, as specified by RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// UserRepository abstracts the admin users persistance layer.
+type UserRepository interface {
+	FindUser(ctx context.Context, name string) (User, error)
+	UpsertUser(ctx context.Context, user User) error
+	CountUsers(ctx context.Context) (int, error)
+}
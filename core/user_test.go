@@ -0,0 +1,76 @@
+package core
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// The PBKDF2-HMAC-SHA256 vectors of RFC 7914, and the RFC 6070 inputs hashed with SHA-256.
+	for _, c := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(c.password), []byte(c.salt), c.iterations))
+		if got != c.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", c.password, c.salt, c.iterations, got, c.want)
+		}
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	user, err := NewUser("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "admin" || !strings.HasPrefix(user.PasswordHash, "pbkdf2-sha256$100000$") || user.CreatedAt.IsZero() {
+		t.Errorf("got %+v", user)
+	}
+	if !user.CheckPassword("correct horse") {
+		t.Error("the password is refused")
+	}
+	for _, password := range []string{"", "correct horsE", "correct horse "} {
+		if user.CheckPassword(password) {
+			t.Errorf("the password %q is accepted", password)
+		}
+	}
+	if other, _ := NewUser("admin", "correct horse"); other.PasswordHash == user.PasswordHash {
+		t.Error("two hashes of the same password are equal: the salt is not random")
+	}
+}
+
+func TestNewUserRejectsInvalidInput(t *testing.T) {
+	for _, c := range []struct{ name, password string }{
+		{"Admin", "correct horse"},
+		{"", "correct horse"},
+		{"admin", "short"},
+	} {
+		if _, err := NewUser(c.name, c.password); KindOf(err) != InvalidInput {
+			t.Errorf("NewUser(%q, %q): got %v, want an invalid input error", c.name, c.password, err)
+		}
+	}
+}
+
+func TestCheckPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"bcrypt$10$c2FsdA$a2V5",
+		"pbkdf2-sha256$0$c2FsdA$a2V5",
+		"pbkdf2-sha256$x$c2FsdA$a2V5",
+		"pbkdf2-sha256$1$!$a2V5",
+		"pbkdf2-sha256$1$c2FsdA$!",
+		"pbkdf2-sha256$1$c2FsdA",
+	} {
+		if (User{PasswordHash: hash}).CheckPassword("password") {
+			t.Errorf("the hash %q accepts a password", hash)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Verification is a mail checker result kept in the verification history of an email.
+type Verification struct {
+	Email    string                    `bson:"email"`
+	Time     time.Time                 `bson:"time"`
+	Response EmailVerificationResponse `bson:"response"`
+}
+
+// VerificationHistory stores the results of the mail checker, by email.
+type VerificationHistory interface {
+	RecordVerification(ctx context.Context, v Verification) error
+	// FindVerifications returns the history of the email, case insensitively, the most recent first.
+	FindVerifications(ctx context.Context, email string) ([]Verification, error)
+	RemoveVerifications(ctx context.Context, email string) (int, error)
+}
+
+// RecordVerifications decorates a mail checker to keep the history of its results.
+func RecordVerifications(next MailChecker, history VerificationHistory) MailChecker {
+	return recordingMailChecker{next, history}
+}
+
+type recordingMailChecker struct {
+	next    MailChecker
+	history VerificationHistory
+}
+
+func (r recordingMailChecker) Validate(ctx context.Context, email string) (EmailVerificationResponse, error) {
+	resp, err := r.next.Validate(ctx, email)
+	if err != nil {
+		return resp, err
+	}
+	return resp, r.history.RecordVerification(ctx, Verification{Email: email, Time: time.Now(), Response: resp})
+}
+
+func (r recordingMailChecker) Ping(ctx context.Context) error {
+	if p, ok := r.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (r recordingMailChecker) Close() error {
+	if c, ok := r.next.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package exporter writes subscriptions, one at a time, as CSV, JSON Lines or vCard.
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// Supported formats.
+const (
+	CSV   = "csv"
+	JSONL = "jsonl"
+	VCard = "vcard"
+)
+
+// Writer writes subscriptions in a given format.
+type Writer interface {
+	Write(core.Subscription) error
+	// Flush writes any buffered data to the underlying writer.
+	Flush() error
+}
+
+// Format describes an export format.
+type Format struct {
+	ContentType string
+	Extension   string
+	new         func(w io.Writer, fields []string) Writer
+}
+
+var formats = map[string]Format{
+	CSV:   {"text/csv; charset=utf-8", "csv", newCSVWriter},
+	JSONL: {"application/x-ndjson", "jsonl", newJSONLWriter},
+	VCard: {"text/vcard; charset=utf-8", "vcf", newVCardWriter},
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (Format, error) {
+	f, ok := formats[name]
+	if !ok {
+		return f, core.Errorf(core.InvalidInput, "unknown export format %q", name)
+	}
+	return f, nil
+}
+
+// NewWriter returns a writer of the format to w. The given custom fields get their own CSV
+// column, the other fields are written as JSON in the last column.
+func (f Format) NewWriter(w io.Writer, fields ...string) Writer {
+	return f.new(w, fields)
+}
+
+// Record is the exported representation of a subscription.
+type Record struct {
+	Email      string                 `json:"email"`
+	Name       string                 `json:"name"`
+	Valid      bool                   `json:"valid"`
+	Score      float64                `json:"score"`
+	Suggestion string                 `json:"suggestion,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	Consent    *core.Consent          `json:"consent,omitempty"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+}
+
+// NewRecord returns the exported representation of s.
+func NewRecord(s core.Subscription) Record {
+	return Record{
+		Email:      s.Email,
+		Name:       s.Name,
+		Valid:      s.EmailVerificationResponse.Valid,
+		Score:      s.Score,
+		Suggestion: s.Suggestion,
+		Tags:       s.Tags,
+		Consent:    s.Consent,
+		Fields:     s.Fields,
+	}
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	fields        []string
+	headerWritten bool
+}
+
+func newCSVWriter(w io.Writer, fields []string) Writer {
+	return &csvWriter{w: csv.NewWriter(w), fields: fields}
+}
+
+func (c *csvWriter) header() []string {
+	header := []string{"email", "name", "valid", "score", "suggestion", "tags", "consent_version", "consented_at", "consent_confirmed_at", "consent_ip"}
+	for _, f := range c.fields {
+		header = append(header, csvText(f))
+	}
+	return append(header, "fields")
+}
+
+func (c *csvWriter) Write(s core.Subscription) error {
+	if !c.headerWritten {
+		c.headerWritten = true
+		if err := c.w.Write(c.header()); err != nil {
+			return err
+		}
+	}
+
+	r := NewRecord(s)
+	row := []string{
+		csvText(r.Email),
+		csvText(r.Name),
+		strconv.FormatBool(r.Valid),
+		strconv.FormatFloat(r.Score, 'f', -1, 64),
+		csvText(r.Suggestion),
+		csvText(strings.Join(r.Tags, ",")),
+	}
+	for _, column := range consentColumns(r.Consent) {
+		row = append(row, csvText(column))
+	}
+	others := map[string]interface{}{}
+	for k, v := range r.Fields {
+		others[k] = v
+	}
+	for _, f := range c.fields {
+		value := FieldValue(r.Fields[f])
+		switch r.Fields[f].(type) {
+		case float64, int:
+			// Negative numbers stay numbers.
+		default:
+			value = csvText(value)
+		}
+		row = append(row, value)
+		delete(others, f)
+	}
+	extra := ""
+	if len(others) > 0 {
+		b, err := json.Marshal(others)
+		if err != nil {
+			return err
+		}
+		extra = string(b)
+	}
+	return c.w.Write(append(row, extra))
+}
+
+func (c *csvWriter) Flush() error {
+	if !c.headerWritten {
+		c.headerWritten = true
+		c.w.Write(c.header())
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvText neutralizes a text cell which a spreadsheet would evaluate as a formula, such as
+// "=HYPERLINK(...)", by prefixing it with a quote.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer, _ []string) Writer {
+	return jsonlWriter{json.NewEncoder(w)}
+}
+
+func (j jsonlWriter) Write(s core.Subscription) error {
+	return j.enc.Encode(NewRecord(s))
+}
+
+func (j jsonlWriter) Flush() error {
+	return nil
+}
+
+type vcardWriter struct {
+	w io.Writer
+}
+
+func newVCardWriter(w io.Writer, _ []string) Writer {
+	return vcardWriter{w}
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+// Write writes s as a vCard 3.0. The verification and custom fields are written as
+// X-MAILIST-* extended properties.
+func (v vcardWriter) Write(s core.Subscription) error {
+	r := NewRecord(s)
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"FN:" + vcardEscaper.Replace(r.Name),
+		"N:" + vcardEscaper.Replace(r.Name) + ";;;;",
+		"EMAIL;TYPE=INTERNET:" + vcardEscaper.Replace(r.Email),
+		"X-MAILIST-VALID:" + strconv.FormatBool(r.Valid),
+		"X-MAILIST-SCORE:" + strconv.FormatFloat(r.Score, 'f', -1, 64),
+	}
+	if r.Suggestion != "" {
+		lines = append(lines, "X-MAILIST-SUGGESTION:"+vcardEscaper.Replace(r.Suggestion))
+	}
+	if len(r.Tags) > 0 {
+		lines = append(lines, "CATEGORIES:"+strings.Join(r.Tags, ","))
+	}
+	if r.Consent != nil {
+		lines = append(lines, fmt.Sprintf("X-MAILIST-CONSENT;VERSION=%s:%s", vcardParam(r.Consent.TextVersion), r.Consent.GivenAt.UTC().Format(time.RFC3339)))
+	}
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("X-MAILIST-FIELD;NAME=%s:%s", vcardParam(k), vcardEscaper.Replace(FieldValue(r.Fields[k]))))
+	}
+	lines = append(lines, "END:VCARD")
+
+	_, err := io.WriteString(v.w, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
+
+func (v vcardWriter) Flush() error {
+	return nil
+}
+
+// vcardParam quotes a parameter value, which cannot hold double quotes.
+func vcardParam(s string) string {
+	return `"` + strings.Replace(s, `"`, "'", -1) + `"`
+}
+
+// consentColumns returns the CSV columns of the consent: text version, consent time,
+// confirmation time and IP.
+func consentColumns(c *core.Consent) []string {
+	if c == nil {
+		return []string{"", "", "", ""}
+	}
+	confirmed := ""
+	if c.ConfirmedAt != nil {
+		confirmed = c.ConfirmedAt.UTC().Format(time.RFC3339)
+	}
+	return []string{c.TextVersion, c.GivenAt.UTC().Format(time.RFC3339), confirmed, c.IP}
+}
+
+// FieldValue formats a custom field value as text, joining the values of multiselect fields with commas.
+func FieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = fmt.Sprint(v[i])
+		}
+		return strings.Join(values, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+func TestCSVNeutralizesFormulas(t *testing.T) {
+	f, err := Lookup(CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := f.NewWriter(&buf, "company", "seats", "=cmd")
+	sub := core.Subscription{
+		Email: "ada@example.com",
+		Name:  `=HYPERLINK("http://evil.example","click")`,
+		Tags:  []string{"+vip", "news"},
+		Fields: map[string]interface{}{
+			"company": "@SUM(A1:A2)",
+			"seats":   float64(-3),
+			"notes":   "-2+3",
+		},
+		Consent: &core.Consent{TextVersion: "\tv1", GivenAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), IP: "10.0.0.1"},
+	}
+	sub.EmailVerificationResponse.Score = -0.5
+	sub.EmailVerificationResponse.Suggestion = "-ada@example.com"
+	if err := w.Write(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(core.Subscription{Email: "bob@example.com", Name: "\rBob", Fields: map[string]interface{}{"company": "Acme - Labs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"email", "name", "valid", "score", "suggestion", "tags", "consent_version", "consented_at", "consent_confirmed_at", "consent_ip", "company", "seats", "'=cmd", "fields"},
+		{"ada@example.com", `'=HYPERLINK("http://evil.example","click")`, "false", "-0.5", "'-ada@example.com", "'+vip,news", "'\tv1", "2020-01-02T03:04:05Z", "", "10.0.0.1", "'@SUM(A1:A2)", "-3", "", `{"notes":"-2+3"}`},
+		{"bob@example.com", "'\rBob", "false", "0", "", "", "", "", "", "", "Acme - Labs", "", "", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %q", rows)
+	}
+	for i := range want {
+		if len(rows[i]) != len(want[i]) {
+			t.Errorf("row %d: got %q, want %q", i, rows[i], want[i])
+			continue
+		}
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("row %d, column %s: got %q, want %q", i, want[0][j], rows[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestCSVHeaderOnlyWhenEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := formats[CSV].NewWriter(&buf)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "email,name,valid,score,suggestion,tags,consent_version,consented_at,consent_confirmed_at,consent_ip,fields\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLookupUnknownFormat(t *testing.T) {
+	if _, err := Lookup("xlsx"); core.KindOf(err) != core.InvalidInput {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+// Package gdpr answers the data subject requests: the access to everything stored about an
+// email address, and its erasure.
+package gdpr
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// AuditStore is the audit trail, which the erasure anonymises.
+type AuditStore interface {
+	core.AuditLog
+	core.AuditReader
+	// AnonymizeAudit replaces the email by pseudonym in the entries targeting it, and drops their states.
+	AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error)
+}
+
+// Service gathers the stores holding personal data.
+type Service struct {
+	Subscriptions core.Repository
+	Verifications core.VerificationHistory
+	Audit         AuditStore
+	Tombstones    core.TombstoneRepository
+	Consents      core.ConsentRepository
+	// Hasher hashes the erased emails for the tombstones and the pseudonyms.
+	Hasher core.EmailHasher
+}
+
+// Subscription is the exported representation of a subscription.
+type Subscription struct {
+	List         string                         `json:"list"`
+	Email        string                         `json:"email"`
+	Name         string                         `json:"name"`
+	Status       string                         `json:"status"`
+	SubscribedAt time.Time                      `json:"subscribed_at"`
+	PausedUntil  *time.Time                     `json:"paused_until,omitempty"`
+	DeletedAt    *time.Time                     `json:"deleted_at,omitempty"`
+	Tags         []string                       `json:"tags,omitempty"`
+	Fields       map[string]interface{}         `json:"fields,omitempty"`
+	Verification core.EmailVerificationResponse `json:"last_verification"`
+	Consent      *core.Consent                  `json:"consent,omitempty"`
+}
+
+// Verification is the exported representation of a verification.
+type Verification struct {
+	Time     time.Time                      `json:"time"`
+	Response core.EmailVerificationResponse `json:"response"`
+}
+
+// Bundle is everything stored about an email address.
+type Bundle struct {
+	Email         string            `json:"email"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	Subscriptions []Subscription    `json:"subscriptions"`
+	Verifications []Verification    `json:"verifications"`
+	Consents      []core.Consent    `json:"consents"`
+	Audit         []core.AuditEntry `json:"audit"`
+	Notes         []string          `json:"notes"`
+}
+
+// Erasure reports what an erasure removed or anonymised.
+type Erasure struct {
+	Email         string `json:"email"`
+	Hash          string `json:"hash"`
+	Subscriptions int    `json:"subscriptions"`
+	Verifications int    `json:"verifications"`
+	Consents      int    `json:"consents"`
+	AuditEntries  int    `json:"audit_entries"`
+	// Left are the steps a failed erasure didn't complete, among "subscriptions", "verifications",
+	// "consents" and "audit". Erasing the email again completes them.
+	Left []string `json:"left,omitempty"`
+}
+
+// counts returns what the erasure removed or anonymised, for the audit trail.
+func (e Erasure) counts() map[string]interface{} {
+	return map[string]interface{}{
+		"subscriptions": e.Subscriptions,
+		"verifications": e.Verifications,
+		"consents":      e.Consents,
+		"auditEntries":  e.AuditEntries,
+	}
+}
+
+// notes explain the bundle to the data subject.
+var notes = []string{
+	"Mailist does not send the campaigns itself: it stores no delivery of them.",
+}
+
+// subscriptionsOf selects the subscriptions of an email, case insensitively.
+func subscriptionsOf(email string) map[string]interface{} {
+	return map[string]interface{}{
+		"email": map[string]interface{}{"$regex": "^" + regexp.QuoteMeta(email) + "$", "$options": "i"},
+	}
+}
+
+// findSubscriptions returns the subscriptions of an email, including the ones in the trash.
+func (s Service) findSubscriptions(ctx context.Context, email string) ([]core.Subscription, error) {
+	subscriptions, err := s.Subscriptions.FindAll(ctx, subscriptionsOf(email))
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := s.Subscriptions.FindAll(ctx, core.InTrash(subscriptionsOf(email)))
+	if err != nil {
+		return nil, err
+	}
+	return append(subscriptions, trashed...), nil
+}
+
+// Export returns everything stored about the email. The access is itself recorded in the audit trail.
+func (s Service) Export(ctx context.Context, email, actor, ip string) (Bundle, error) {
+	if !core.ValidEmail(email) {
+		return Bundle{}, core.Errorf(core.InvalidInput, "Invalid e-mail %q", email)
+	}
+	bundle := Bundle{Email: email, ExportedAt: time.Now(), Subscriptions: []Subscription{}, Verifications: []Verification{}, Notes: notes}
+
+	subscriptions, err := s.findSubscriptions(ctx, email)
+	if err != nil {
+		return bundle, err
+	}
+	for _, sub := range subscriptions {
+		exported := Subscription{
+			List:         sub.List,
+			Email:        sub.Email,
+			Name:         sub.Name,
+			Status:       sub.Status,
+			SubscribedAt: sub.SubscribedAt,
+			Tags:         sub.Tags,
+			Fields:       sub.Fields,
+			Verification: sub.EmailVerificationResponse,
+			Consent:      sub.Consent,
+		}
+		if !sub.PausedUntil.IsZero() {
+			exported.PausedUntil = &sub.PausedUntil
+		}
+		if !sub.DeletedAt.IsZero() {
+			exported.DeletedAt = &sub.DeletedAt
+		}
+		bundle.Subscriptions = append(bundle.Subscriptions, exported)
+	}
+
+	verifications, err := s.Verifications.FindVerifications(ctx, email)
+	if err != nil {
+		return bundle, err
+	}
+	for _, v := range verifications {
+		bundle.Verifications = append(bundle.Verifications, Verification{Time: v.Time, Response: v.Response})
+	}
+
+	if bundle.Consents, err = s.Consents.FindConsents(ctx, email); err != nil {
+		return bundle, err
+	}
+	if bundle.Consents == nil {
+		bundle.Consents = []core.Consent{}
+	}
+
+	if bundle.Audit, err = s.Audit.FindAudit(ctx, core.AuditFilter{Target: email}); err != nil {
+		return bundle, err
+	}
+	if bundle.Audit == nil {
+		bundle.Audit = []core.AuditEntry{}
+	}
+
+	return bundle, s.Audit.RecordAudit(ctx, core.AuditEntry{Time: time.Now(), Actor: actor, Action: "gdpr.export", Target: email, IP: ip})
+}
+
+// Erase removes the subscriptions and the verification history of the email, anonymises the consents
+// and the audit trail, and leaves a tombstone, so that the email is not imported again.
+// Every step can run again: when one fails, the steps left are recorded in the audit trail as a
+// "gdpr.erase.failed" entry, and erasing the email again completes the erasure.
+func (s Service) Erase(ctx context.Context, email, actor, ip string) (Erasure, error) {
+	if !core.ValidEmail(email) {
+		return Erasure{}, core.Errorf(core.InvalidInput, "Invalid e-mail %q", email)
+	}
+	erasure := Erasure{Email: email, Hash: s.Hasher.Hash(email)}
+	// The tombstone goes first: if the erasure fails midway, the email still can't be imported again.
+	if err := s.Tombstones.AddTombstone(ctx, core.Tombstone{Hash: erasure.Hash, ErasedAt: time.Now()}); err != nil {
+		return erasure, err
+	}
+
+	pseudonym := "erased:" + erasure.Hash
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"subscriptions", func() error {
+			subscriptions, err := s.findSubscriptions(ctx, email)
+			if err != nil {
+				return err
+			}
+			for _, sub := range subscriptions {
+				if err := s.Subscriptions.Remove(ctx, map[string]interface{}{"list": sub.List, "email": sub.Email}); err != nil {
+					return err
+				}
+				erasure.Subscriptions++
+			}
+			return nil
+		}},
+		{"verifications", func() (err error) {
+			erasure.Verifications, err = s.Verifications.RemoveVerifications(ctx, email)
+			return err
+		}},
+		{"consents", func() (err error) {
+			erasure.Consents, err = s.Consents.AnonymizeConsents(ctx, email, pseudonym)
+			return err
+		}},
+		{"audit", func() (err error) {
+			erasure.AuditEntries, err = s.Audit.AnonymizeAudit(ctx, email, pseudonym)
+			return err
+		}},
+	}
+	for i, step := range steps {
+		if err := step.run(); err != nil {
+			for _, left := range steps[i:] {
+				erasure.Left = append(erasure.Left, left.name)
+			}
+			after := erasure.counts()
+			after["left"] = erasure.Left
+			// The audit trail may be what failed: the error is returned whether this entry is recorded or not.
+			s.Audit.RecordAudit(ctx, core.AuditEntry{Time: time.Now(), Actor: actor, Action: "gdpr.erase.failed", Target: pseudonym, IP: ip, After: after})
+			return erasure, err
+		}
+	}
+
+	return erasure, s.Audit.RecordAudit(ctx, core.AuditEntry{
+		Time:   time.Now(),
+		Actor:  actor,
+		Action: "gdpr.erase",
+		Target: pseudonym,
+		IP:     ip,
+		After:  erasure.counts(),
+	})
+}
@@ -0,0 +1,341 @@
+package gdpr
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// fakeStore keeps every store of the Service in memory, and logs the calls in ops.
+// The call named by fail returns an error.
+type fakeStore struct {
+	core.Repository
+	core.VerificationHistory
+	core.ConsentRepository
+	core.TombstoneRepository
+
+	ops  []string
+	fail string
+
+	subscriptions []core.Subscription
+	verifications []core.Verification
+	consents      []core.Consent
+	audit         []core.AuditEntry
+	tombstones    []core.Tombstone
+}
+
+var errStore = errors.New("store unavailable")
+
+func (f *fakeStore) op(name string) error {
+	f.ops = append(f.ops, name)
+	if name == f.fail {
+		return errStore
+	}
+	return nil
+}
+
+// FindAll supports the selectors of subscriptionsOf, in the trash or not.
+func (f *fakeStore) FindAll(ctx context.Context, selector map[string]interface{}) ([]core.Subscription, error) {
+	_, inTrash := selector["deletedAt"]
+	op := "FindAll"
+	if inTrash {
+		op = "FindAll(trash)"
+	}
+	if err := f.op(op); err != nil {
+		return nil, err
+	}
+	email := regexp.MustCompile("(?i)" + selector["email"].(map[string]interface{})["$regex"].(string))
+	var found []core.Subscription
+	for _, s := range f.subscriptions {
+		if email.MatchString(s.Email) && !s.DeletedAt.IsZero() == inTrash {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeStore) Remove(ctx context.Context, selector map[string]interface{}) error {
+	if err := f.op("Remove"); err != nil {
+		return err
+	}
+	kept := f.subscriptions[:0]
+	for _, s := range f.subscriptions {
+		if s.List != selector["list"] || s.Email != selector["email"] {
+			kept = append(kept, s)
+		}
+	}
+	f.subscriptions = kept
+	return nil
+}
+
+func (f *fakeStore) FindVerifications(ctx context.Context, email string) ([]core.Verification, error) {
+	var found []core.Verification
+	for _, v := range f.verifications {
+		if strings.EqualFold(v.Email, email) {
+			found = append(found, v)
+		}
+	}
+	return found, f.op("FindVerifications")
+}
+
+func (f *fakeStore) RemoveVerifications(ctx context.Context, email string) (int, error) {
+	if err := f.op("RemoveVerifications"); err != nil {
+		return 0, err
+	}
+	kept := f.verifications[:0]
+	for _, v := range f.verifications {
+		if !strings.EqualFold(v.Email, email) {
+			kept = append(kept, v)
+		}
+	}
+	n := len(f.verifications) - len(kept)
+	f.verifications = kept
+	return n, nil
+}
+
+func (f *fakeStore) FindConsents(ctx context.Context, email string) ([]core.Consent, error) {
+	var found []core.Consent
+	for _, c := range f.consents {
+		if strings.EqualFold(c.Email, email) {
+			found = append(found, c)
+		}
+	}
+	return found, f.op("FindConsents")
+}
+
+func (f *fakeStore) AnonymizeConsents(ctx context.Context, email, pseudonym string) (int, error) {
+	if err := f.op("AnonymizeConsents"); err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, c := range f.consents {
+		if strings.EqualFold(c.Email, email) {
+			f.consents[i].Email, f.consents[i].IP, f.consents[i].UserAgent = pseudonym, "", ""
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeStore) AddTombstone(ctx context.Context, tombstone core.Tombstone) error {
+	if err := f.op("AddTombstone"); err != nil {
+		return err
+	}
+	for i, t := range f.tombstones {
+		if t.Hash == tombstone.Hash {
+			f.tombstones[i] = tombstone
+			return nil
+		}
+	}
+	f.tombstones = append(f.tombstones, tombstone)
+	return nil
+}
+
+func (f *fakeStore) RecordAudit(ctx context.Context, entry core.AuditEntry) error {
+	if err := f.op("RecordAudit"); err != nil {
+		return err
+	}
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeStore) FindAudit(ctx context.Context, filter core.AuditFilter) ([]core.AuditEntry, error) {
+	var found []core.AuditEntry
+	for _, e := range f.audit {
+		if e.Target == filter.Target {
+			found = append(found, e)
+		}
+	}
+	return found, f.op("FindAudit")
+}
+
+func (f *fakeStore) AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error) {
+	if err := f.op("AnonymizeAudit"); err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, e := range f.audit {
+		if strings.EqualFold(e.Target, email) {
+			f.audit[i].Target, f.audit[i].Before, f.audit[i].After = pseudonym, nil, nil
+			n++
+		}
+	}
+	return n, nil
+}
+
+// newService returns a service on a store holding the data of ada@example.com: a subscription,
+// another in the trash, a verification, a consent and an audit entry, besides bob's subscription.
+func newService() (Service, *fakeStore) {
+	now := time.Now()
+	store := &fakeStore{
+		subscriptions: []core.Subscription{
+			{List: "default", Email: "ada@example.com", Name: "Ada", Status: core.StatusConfirmed, SubscribedAt: now, Tags: []string{"vip"}},
+			{List: "news", Email: "Ada@Example.com", Name: "Ada", Status: core.StatusConfirmed, SubscribedAt: now, DeletedAt: now},
+			{List: "default", Email: "bob@example.com", Name: "Bob", Status: core.StatusConfirmed, SubscribedAt: now},
+		},
+		verifications: []core.Verification{{Email: "ada@example.com", Time: now}},
+		consents:      []core.Consent{{Email: "ada@example.com", List: "default", Form: "subscribe", IP: "192.0.2.1", UserAgent: "Firefox"}},
+		audit:         []core.AuditEntry{{Time: now, Actor: "golang", Action: "subscription.tag", Target: "ada@example.com", After: map[string]interface{}{"tags": []string{"vip"}}}},
+	}
+	return Service{
+		Subscriptions: store,
+		Verifications: store,
+		Audit:         store,
+		Tombstones:    store,
+		Consents:      store,
+		Hasher:        core.NewEmailHasher("0123456789abcdef0123456789abcdef"),
+	}, store
+}
+
+func TestExport(t *testing.T) {
+	service, store := newService()
+	bundle, err := service.Export(context.Background(), "ada@example.com", "golang", "192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bundle.Subscriptions) != 2 || bundle.Subscriptions[0].List != "default" || bundle.Subscriptions[0].DeletedAt != nil {
+		t.Fatalf("got subscriptions %+v", bundle.Subscriptions)
+	}
+	if trashed := bundle.Subscriptions[1]; trashed.List != "news" || trashed.DeletedAt == nil {
+		t.Errorf("got %+v, want the subscription in the trash with its deletion time", trashed)
+	}
+	if len(bundle.Verifications) != 1 || len(bundle.Consents) != 1 || len(bundle.Audit) != 1 || len(bundle.Notes) == 0 {
+		t.Errorf("got %+v", bundle)
+	}
+
+	last := store.audit[len(store.audit)-1]
+	if last.Action != "gdpr.export" || last.Target != "ada@example.com" || last.Actor != "golang" || last.IP != "192.0.2.9" {
+		t.Errorf("recorded %+v, want the access", last)
+	}
+
+	if _, err := service.Export(context.Background(), "nope", "golang", ""); core.KindOf(err) != core.InvalidInput {
+		t.Errorf("got %v, want an invalid input error", err)
+	}
+}
+
+func TestExportWithoutData(t *testing.T) {
+	service, _ := newService()
+	bundle, err := service.Export(context.Background(), "nobody@example.com", "golang", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The empty lists are exported as [] rather than null.
+	if bundle.Subscriptions == nil || bundle.Verifications == nil || bundle.Consents == nil || bundle.Audit == nil {
+		t.Errorf("got %+v", bundle)
+	}
+}
+
+func TestErase(t *testing.T) {
+	service, store := newService()
+	erasure, err := service.Erase(context.Background(), "ada@example.com", "golang", "192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := service.Hasher.Hash("ada@example.com")
+	pseudonym := "erased:" + hash
+	want := Erasure{Email: "ada@example.com", Hash: hash, Subscriptions: 2, Verifications: 1, Consents: 1, AuditEntries: 1}
+	if !reflect.DeepEqual(erasure, want) {
+		t.Errorf("got %+v, want %+v", erasure, want)
+	}
+	if store.ops[0] != "AddTombstone" {
+		t.Errorf("got the calls %v, want the tombstone first", store.ops)
+	}
+	if len(store.tombstones) != 1 || store.tombstones[0].Hash != hash || store.tombstones[0].ErasedAt.IsZero() {
+		t.Errorf("got tombstones %+v", store.tombstones)
+	}
+
+	if len(store.subscriptions) != 1 || store.subscriptions[0].Email != "bob@example.com" {
+		t.Errorf("got subscriptions %+v, want bob's only", store.subscriptions)
+	}
+	if len(store.verifications) != 0 {
+		t.Errorf("got verifications %+v", store.verifications)
+	}
+	if c := store.consents[0]; c.Email != pseudonym || c.IP != "" || c.UserAgent != "" || c.List != "default" {
+		t.Errorf("got consent %+v, want it anonymised", c)
+	}
+
+	if len(store.audit) != 2 {
+		t.Fatalf("got audit %+v", store.audit)
+	}
+	if e := store.audit[0]; e.Target != pseudonym || e.After != nil || e.Action != "subscription.tag" {
+		t.Errorf("got %+v, want the entry anonymised", e)
+	}
+	entry := store.audit[1]
+	if entry.Action != "gdpr.erase" || entry.Target != pseudonym || entry.Actor != "golang" || entry.IP != "192.0.2.9" {
+		t.Errorf("recorded %+v, want the erasure under the pseudonym", entry)
+	}
+	if entry.After["subscriptions"] != 2 || entry.After["auditEntries"] != 1 {
+		t.Errorf("recorded %v", entry.After)
+	}
+	for _, e := range store.audit {
+		if strings.Contains(strings.ToLower(e.Target), "ada") {
+			t.Errorf("the audit trail still names the email: %+v", e)
+		}
+	}
+
+	if _, err := service.Erase(context.Background(), "nope", "golang", ""); core.KindOf(err) != core.InvalidInput {
+		t.Errorf("got %v, want an invalid input error", err)
+	}
+}
+
+func TestEraseRetry(t *testing.T) {
+	for _, c := range []struct {
+		fail string
+		left []string
+	}{
+		{"Remove", []string{"subscriptions", "verifications", "consents", "audit"}},
+		{"RemoveVerifications", []string{"verifications", "consents", "audit"}},
+		{"AnonymizeConsents", []string{"consents", "audit"}},
+		{"AnonymizeAudit", []string{"audit"}},
+	} {
+		service, store := newService()
+		store.fail = c.fail
+		erasure, err := service.Erase(context.Background(), "ada@example.com", "golang", "")
+		if err != errStore {
+			t.Errorf("%s: got %v, want the store error", c.fail, err)
+		}
+		if !reflect.DeepEqual(erasure.Left, c.left) {
+			t.Errorf("%s: got the steps left %v, want %v", c.fail, erasure.Left, c.left)
+		}
+		if len(store.tombstones) != 1 {
+			t.Errorf("%s: got tombstones %+v, want the tombstone written first", c.fail, store.tombstones)
+		}
+		failed := store.audit[len(store.audit)-1]
+		if failed.Action != "gdpr.erase.failed" || failed.Target != "erased:"+erasure.Hash || !reflect.DeepEqual(failed.After["left"], c.left) {
+			t.Errorf("%s: recorded %+v, want the steps left", c.fail, failed)
+		}
+
+		// Erasing again completes the erasure.
+		store.fail = ""
+		erasure, err = service.Erase(context.Background(), "ada@example.com", "golang", "")
+		if err != nil || erasure.Left != nil {
+			t.Fatalf("%s: got %+v, %v", c.fail, erasure, err)
+		}
+		if len(store.subscriptions) != 1 || len(store.verifications) != 0 || store.consents[0].Email != "erased:"+erasure.Hash || len(store.tombstones) != 1 {
+			t.Errorf("%s: got subscriptions %+v, verifications %+v, consents %+v and tombstones %+v after the retry",
+				c.fail, store.subscriptions, store.verifications, store.consents, store.tombstones)
+		}
+		if last := store.audit[len(store.audit)-1]; last.Action != "gdpr.erase" {
+			t.Errorf("%s: recorded %+v, want the erasure", c.fail, last)
+		}
+	}
+}
+
+func TestEraseWithoutTombstone(t *testing.T) {
+	service, store := newService()
+	store.fail = "AddTombstone"
+	if _, err := service.Erase(context.Background(), "ada@example.com", "golang", ""); err != errStore {
+		t.Errorf("got %v, want the store error", err)
+	}
+	if len(store.ops) != 1 || len(store.subscriptions) != 3 || len(store.audit) != 1 {
+		t.Errorf("got the calls %v, want nothing erased without the tombstone", store.ops)
+	}
+}
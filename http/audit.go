@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+// auditPageSize is the default number of entries shown by the audit.html page.
+const auditPageSize = 100
+
+// recordAdmin records a change made by the authenticated admin to the audit trail.
+func recordAdmin(c echo.Context, audit core.AuditLog, entry core.AuditEntry) error {
+	entry.Time = time.Now()
+	entry.Actor = actor(c)
+	entry.IP = c.RealIP()
+	return audit.RecordAudit(c.Request().Context(), entry)
+}
+
+// subscriptionSnapshot returns the state of a subscription recorded in the audit trail.
+func subscriptionSnapshot(s core.Subscription) map[string]interface{} {
+	snapshot := preferencesSnapshot(s)
+	snapshot["status"] = s.Status
+	if len(s.Tags) > 0 {
+		snapshot["tags"] = s.Tags
+	}
+	return snapshot
+}
+
+// verificationSnapshot returns the mail checker response recorded in the audit trail.
+func verificationSnapshot(r core.EmailVerificationResponse) map[string]interface{} {
+	return map[string]interface{}{"valid": r.Valid, "score": r.Score, "suggestion": r.Suggestion}
+}
+
+// auditFilter reads the filter of the audit.html page from the query parameters: `actor`,
+// `action`, `target`, the `since` and `until` days, inclusive, and `limit`.
+func auditFilter(c echo.Context) (core.AuditFilter, error) {
+	filter := core.AuditFilter{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		Target: c.QueryParam("target"),
+		Limit:  auditPageSize,
+	}
+	if since := c.QueryParam("since"); since != "" {
+		t, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			return filter, core.Errorf(core.InvalidInput, "Invalid date %q", since)
+		}
+		filter.Since = t
+	}
+	if until := c.QueryParam("until"); until != "" {
+		t, err := time.ParseInLocation("2006-01-02", until, time.Local)
+		if err != nil {
+			return filter, core.Errorf(core.InvalidInput, "Invalid date %q", until)
+		}
+		filter.Until = t.AddDate(0, 0, 1)
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, core.Errorf(core.InvalidInput, "Invalid limit %q", limit)
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+// AuditHandler renders the audit.html page, the audit trail filtered by the query parameters.
+func AuditHandler(audit core.AuditReader) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, err := auditFilter(c)
+		if err != nil {
+			return err
+		}
+		entries, err := audit.FindAudit(c.Request().Context(), filter)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "audit.html", ViewContext{
+			"page":    "audit",
+			"entries": entries,
+			"filter":  filter,
+			"since":   c.QueryParam("since"),
+			"until":   c.QueryParam("until"),
+		})
+	}
+}
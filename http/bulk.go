@@ -0,0 +1,245 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/exporter"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/metrics"
+
+	"github.com/labstack/echo"
+)
+
+// Bulk actions, the `action` values of the subscriptions.html bulk form.
+const (
+	bulkTrash    = "trash"
+	bulkValidate = "validate"
+	bulkTag      = "tag"
+	bulkUntag    = "untag"
+	bulkMove     = "move"
+	bulkExport   = "export"
+)
+
+// bulkSelector returns the selector of the subscriptions a bulk action applies to: all the
+// subscriptions matching the search and filter query parameters when the `all` form value is
+// set, the subscriptions checked in the table otherwise.
+func bulkSelector(c echo.Context) (map[string]interface{}, error) {
+	if c.FormValue("all") != "" {
+		return subscriptionSelector(c)
+	}
+	return selectedSubscriptions(c)
+}
+
+// bulkHandler starts the bulk action submitted with the subscriptions.html form as a background
+// job, then redirects to its progress page. Every change is written to the audit log.
+func bulkHandler(repo core.Repository, lists core.ListRepository, audit core.AuditLog, mailChecker core.MailChecker, workers *workerGroup, jobs *jobs, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		flash := func(err error) error {
+			if core.KindOf(err) == core.InvalidInput {
+				return redirectWithFlashMessage(c, e, "subscriptions", "error", err.(*core.Error).Msg)
+			}
+			return err
+		}
+
+		action := c.FormValue("action")
+		selector, err := bulkSelector(c)
+		if err != nil {
+			return flash(err)
+		}
+
+		// The changes are recorded on behalf of the admin who started the job.
+		base := core.AuditEntry{Actor: actor(c), IP: c.RealIP()}
+		record := func(ctx context.Context, entry core.AuditEntry) error {
+			entry.Time, entry.Actor, entry.IP = time.Now(), base.Actor, base.IP
+			return audit.RecordAudit(ctx, entry)
+		}
+
+		var apply func(ctx context.Context, s core.Subscription) error
+		var export func(ctx context.Context, j *job, subscriptions []core.Subscription) error
+		switch action {
+		case bulkTrash:
+			apply = func(ctx context.Context, s core.Subscription) error {
+				if _, err := repo.Trash(ctx, map[string]interface{}{"list": s.List, "email": s.Email}); err != nil {
+					return err
+				}
+				subscriptionsUnsubscribed.With().Inc()
+				return record(ctx, core.AuditEntry{Action: "subscription.trash", Target: s.Email, List: s.List, Before: subscriptionSnapshot(s)})
+			}
+		case bulkValidate:
+			apply = func(ctx context.Context, s core.Subscription) error {
+				resp, err := mailChecker.Validate(ctx, s.Email)
+				if err == nil {
+					var n int
+					// The subscription may have changed, or left the list, since it was read.
+					n, err = repo.SetVerification(ctx, map[string]interface{}{"list": s.List, "email": s.Email}, resp)
+					if err == nil && n > 0 {
+						err = record(ctx, core.AuditEntry{Action: "subscription.validate", Target: s.Email, List: s.List, Before: verificationSnapshot(s.EmailVerificationResponse), After: verificationSnapshot(resp)})
+					}
+				}
+				bulkValidationProcessed.With(metrics.Outcome(err)).Inc()
+				return err
+			}
+		case bulkTag, bulkUntag:
+			tags, err := parseTags(c.FormValue("tags"))
+			if err != nil {
+				return flash(err)
+			}
+			apply = func(ctx context.Context, s core.Subscription) error {
+				selector := map[string]interface{}{"list": s.List, "email": s.Email}
+				var err error
+				if action == bulkUntag {
+					_, err = repo.Untag(ctx, selector, tags...)
+				} else {
+					_, err = repo.Tag(ctx, selector, tags...)
+				}
+				if err != nil {
+					return err
+				}
+				after := applyTags(s.Tags, tags, action == bulkUntag)
+				return record(ctx, core.AuditEntry{Action: "subscription." + action, Target: s.Email, List: s.List, Before: map[string]interface{}{"tags": s.Tags}, After: map[string]interface{}{"tags": after}})
+			}
+		case bulkMove:
+			target, err := lists.FindList(ctx, c.FormValue("target-list"))
+			if err != nil {
+				if core.KindOf(err) == core.NotFound {
+					return flash(core.Errorf(core.InvalidInput, "Choose the list to move the subscriptions to"))
+				}
+				return err
+			}
+			apply = func(ctx context.Context, s core.Subscription) error {
+				if s.List == target.Slug {
+					return nil
+				}
+				existing, err := repo.Count(ctx, map[string]interface{}{"list": target.Slug, "email": s.Email})
+				if err != nil {
+					return err
+				}
+				if existing > 0 {
+					return core.Errorf(core.AlreadyExists, "%s is already subscribed to %s", s.Email, target.Name)
+				}
+				moved := s
+				moved.List = target.Slug
+				if err := repo.Upsert(ctx, moved); err != nil {
+					return err
+				}
+				if err := repo.Remove(ctx, map[string]interface{}{"list": s.List, "email": s.Email}); err != nil {
+					return err
+				}
+				return record(ctx, core.AuditEntry{Action: "subscription.move", Target: s.Email, List: target.Slug, Before: map[string]interface{}{"list": s.List}, After: map[string]interface{}{"list": target.Slug}})
+			}
+		case bulkExport:
+			format, err := exporter.Lookup(c.FormValue("format"))
+			if err != nil {
+				return flash(err)
+			}
+			fields, err := exportedFields(c, lists)
+			if err != nil {
+				return err
+			}
+			export = func(ctx context.Context, j *job, subscriptions []core.Subscription) error {
+				var buf bytes.Buffer
+				w := format.NewWriter(&buf, fields...)
+				for _, s := range subscriptions {
+					j.progress(w.Write(s))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				filename := fmt.Sprintf("subscriptions-%s.%s", time.Now().Format("20060102"), format.Extension)
+				j.attach(filename, format.ContentType, buf.Bytes())
+				return record(ctx, core.AuditEntry{Action: "subscriptions.export", Target: filename, After: map[string]interface{}{"subscriptions": len(subscriptions)}})
+			}
+		default:
+			return flash(core.Errorf(core.InvalidInput, "Unknown bulk action %q", action))
+		}
+
+		subscriptions, err := repo.FindAll(ctx, selector)
+		if err != nil {
+			return err
+		}
+		if len(subscriptions) == 0 {
+			return flash(core.Errorf(core.InvalidInput, "No subscription matches"))
+		}
+
+		j := jobs.add(action, len(subscriptions))
+		if action == bulkValidate {
+			bulkValidationPending.With().Add(float64(len(subscriptions)))
+		}
+		workers.Go(logging.FromContext(ctx), func(ctx context.Context) {
+			defer j.finish()
+			logger := logging.FromContext(ctx).With("job", j.status.ID, "action", action)
+			if export != nil {
+				if err := export(ctx, j, subscriptions); err != nil {
+					logger.Error("bulk export failed", "err", err)
+				}
+				return
+			}
+			for _, s := range subscriptions {
+				// Once the server is stopping, the remaining subscriptions are reported as failed.
+				err := ctx.Err()
+				if err == nil {
+					err = apply(ctx, s)
+				}
+				j.progress(err)
+				if action == bulkValidate {
+					bulkValidationPending.With().Add(-1)
+				}
+			}
+			logger.Info("bulk job finished", "total", len(subscriptions), "failed", j.snapshot().Failed)
+		})
+
+		return redirectWithFlashMessage(c, e, "bulk-job", "success", fmt.Sprintf("%s of %d subscriptions started", bulkActionLabels[action], len(subscriptions)), j.status.ID)
+	}
+}
+
+// jobHandler reports the progress of the bulk job given in the URL, as JSON to the scripts and
+// as the job.html page otherwise.
+func jobHandler(jobs *jobs) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		j, ok := jobs.get(c.Param("id"))
+		if !ok {
+			return core.Errorf(core.NotFound, "This job has expired")
+		}
+		status := j.snapshot()
+		if wantsJSON(c.Request()) {
+			return c.JSON(http.StatusOK, status)
+		}
+		return c.Render(http.StatusOK, "job.html", ViewContext{
+			"page":    "job",
+			"job":     status,
+			"action":  bulkActionLabels[status.Action],
+			"success": c.QueryParam("success"),
+		})
+	}
+}
+
+// jobDownloadHandler downloads the file produced by the bulk export given in the URL.
+func jobDownloadHandler(jobs *jobs) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		j, ok := jobs.get(c.Param("id"))
+		if !ok || !j.snapshot().Download {
+			return core.Errorf(core.NotFound, "This export has expired or is not ready")
+		}
+		j.mu.Lock()
+		filename, contentType, result := j.filename, j.contentType, j.result
+		j.mu.Unlock()
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+		return c.Blob(http.StatusOK, contentType, result)
+	}
+}
+
+// bulkActionLabels names the actions in the job.html page.
+var bulkActionLabels = map[string]string{
+	bulkTrash:    "Move to trash",
+	bulkValidate: "Validation",
+	bulkTag:      "Tagging",
+	bulkUntag:    "Untagging",
+	bulkMove:     "Move to list",
+	bulkExport:   "Export",
+}
@@ -0,0 +1,25 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+// ConsentsHandler renders the consents.html page, the consents history of the email given in the URL.
+func ConsentsHandler(consents core.ConsentRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		email := c.Param("email")
+		history, err := consents.FindConsents(c.Request().Context(), email)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "consents.html", ViewContext{
+			"page":     "consents",
+			"email":    email,
+			"consents": history,
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+
+	"github.com/labstack/echo"
+)
+
+// mimeProblemJSON is the media type defined by RFC 7807 for problem details.
+const mimeProblemJSON = "application/problem+json"
+
+// apiPrefix is the path prefix of the routes that always answer with JSON.
+const apiPrefix = "/api/"
+
+// kindStatus maps the core domain errors to HTTP statuses.
+var kindStatus = map[core.Kind]int{
+	core.NotFound:            http.StatusNotFound,
+	core.AlreadyExists:       http.StatusConflict,
+	core.InvalidInput:        http.StatusUnprocessableEntity,
+	core.ProviderUnavailable: http.StatusServiceUnavailable,
+	core.QuotaExceeded:       http.StatusServiceUnavailable,
+}
+
+// Problem is the RFC 7807 representation of an error.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	ErrorID  string `json:"errorId"`
+}
+
+// customHTTPErrorHandler renders errors either as problem details or as the error.html page,
+// depending on what the client is able to handle.
+// Every error is tagged with an ID that is returned to the client and logged alongside the error,
+// so reports from users can be correlated with the logs.
+func customHTTPErrorHandler(err error, c echo.Context) {
+	problem := newProblem(err, c)
+	logger := logging.FromContext(c.Request().Context()).With("error_id", problem.ErrorID)
+	log := logger.Warn
+	if problem.Status >= 500 {
+		log = logger.Error
+	}
+	log("request failed", "status", problem.Status, "method", c.Request().Method, "path", c.Request().URL.Path, "err", err)
+
+	if c.Response().Committed {
+		return
+	}
+	c.Response().Header().Set("X-Error-Id", problem.ErrorID)
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else if wantsJSON(c.Request()) {
+		c.Response().Header().Set(echo.HeaderContentType, mimeProblemJSON)
+		c.Response().WriteHeader(problem.Status)
+		err = json.NewEncoder(c.Response()).Encode(problem)
+	} else {
+		err = c.Render(problem.Status, "error.html", ViewContext{
+			"page":    "error",
+			"status":  problem.Status,
+			"title":   problem.Title,
+			"detail":  problem.Detail,
+			"errorID": problem.ErrorID,
+		})
+	}
+	if err != nil {
+		logger.Error("failed to render error", "err", err)
+	}
+}
+
+func newProblem(err error, c echo.Context) Problem {
+	code := http.StatusInternalServerError
+	detail := ""
+	var domainErr *core.Error
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+		if msg, ok := he.Message.(string); ok && msg != http.StatusText(code) {
+			detail = msg
+		}
+	} else if errors.As(err, &domainErr) && domainErr.Kind != core.Internal {
+		code = kindStatus[domainErr.Kind]
+		detail = domainErr.Msg
+	}
+
+	if code == http.StatusUnauthorized {
+		detail = "You don't have access to this page."
+	}
+
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   detail,
+		Instance: c.Request().URL.Path,
+		ErrorID:  newErrorID(),
+	}
+}
+
+// wantsJSON reports whether the error should be rendered as problem details:
+// API routes, AJAX calls and clients that prefer JSON over HTML.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return true
+	}
+	if r.Header.Get(echo.HeaderXRequestedWith) == "XMLHttpRequest" {
+		return true
+	}
+
+	accept := r.Header.Get(echo.HeaderAccept)
+	jsonAt := strings.Index(accept, "json")
+	htmlAt := strings.Index(accept, echo.MIMETextHTML)
+	return jsonAt >= 0 && (htmlAt < 0 || jsonAt < htmlAt)
+}
+
+func newErrorID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/exporter"
+
+	"github.com/labstack/echo"
+)
+
+// exportFlushEvery is the number of subscriptions written between two flushes of the response.
+const exportFlushEvery = 100
+
+// exportHandler streams the subscriptions matching the current search and filter in the
+// format given by the `format` query parameter, without loading them all in memory.
+// The CSV export has a column per custom field of the filtered list, or of all the lists.
+func exportHandler(repo core.Repository, lists core.ListRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		format, err := exporter.Lookup(c.QueryParam("format"))
+		if err != nil {
+			return err
+		}
+		selector, err := subscriptionSelector(c)
+		if err != nil {
+			return err
+		}
+		fields, err := exportedFields(c, lists)
+		if err != nil {
+			return err
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, format.ContentType)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="subscriptions-%s.%s"`, time.Now().Format("20060102"), format.Extension))
+
+		w := format.NewWriter(res, fields...)
+		n := 0
+		err = repo.Each(c.Request().Context(), selector, func(s core.Subscription) error {
+			if err := w.Write(s); err != nil {
+				return err
+			}
+			if n++; n%exportFlushEvery == 0 {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				res.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if !res.Committed {
+			res.WriteHeader(http.StatusOK)
+		}
+		return nil
+	}
+}
+
+// exportedFields returns the custom fields of the list filtered by the `list` query parameter, or of all the lists.
+func exportedFields(c echo.Context, lists core.ListRepository) ([]string, error) {
+	if slug := c.QueryParam("list"); slug != "" {
+		list, err := lists.FindList(c.Request().Context(), slug)
+		if err != nil {
+			return nil, err
+		}
+		return core.FieldNames(list), nil
+	}
+	all, err := lists.FindLists(c.Request().Context())
+	if err != nil {
+		return nil, err
+	}
+	return core.FieldNames(all...), nil
+}
@@ -0,0 +1,493 @@
+package http
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// fakeRepository is an in-memory Repository. Its selectors match the list, email, token and
+// fullName keys, by value, `$in` or `$regex`, along `$or` and the trash. The other conditions,
+// such as the segments, match every subscription.
+type fakeRepository struct {
+	mu            sync.Mutex
+	subscriptions []core.Subscription
+	lists         []core.List
+	segments      []core.Segment
+	audit         []core.AuditEntry
+	consents      []core.Consent
+	verifications []core.Verification
+	tombstones    []core.Tombstone
+	hasher        core.EmailHasher
+	users         []core.User
+	userLookups   int
+	// beforeSetVerification, when set, runs before SetVerification, to change the subscriptions
+	// while they are validated.
+	beforeSetVerification func()
+}
+
+func (f *fakeRepository) match(s core.Subscription, selector map[string]interface{}) bool {
+	cond, ok := selector["deletedAt"]
+	if !ok {
+		return s.DeletedAt.IsZero() && matchKeys(s, selector)
+	}
+	if before, ok := cond.(map[string]interface{})["$lt"].(time.Time); ok {
+		return !s.DeletedAt.IsZero() && s.DeletedAt.Before(before) && matchKeys(s, selector)
+	}
+	exists, _ := cond.(map[string]interface{})["$exists"].(bool)
+	return exists != s.DeletedAt.IsZero() && matchKeys(s, selector)
+}
+
+// matchKeys reports whether s matches the conditions of selector besides the trash.
+func matchKeys(s core.Subscription, selector map[string]interface{}) bool {
+	values := map[string]string{"list": s.List, "email": s.Email, "token": s.Token, "fullName": s.Name}
+	for key, cond := range selector {
+		switch key {
+		case "$or":
+			matched := false
+			for _, or := range cond.([]interface{}) {
+				matched = matched || matchKeys(s, or.(map[string]interface{}))
+			}
+			if !matched {
+				return false
+			}
+		case "list", "email", "token", "fullName":
+			if !matchValue(values[key], cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchValue reports whether value matches a selector condition.
+func matchValue(value string, cond interface{}) bool {
+	switch cond := cond.(type) {
+	case string:
+		return value == cond
+	case map[string]interface{}:
+		if eq, ok := cond["$eq"].(string); ok {
+			return value == eq
+		}
+		if in, ok := cond["$in"].([]string); ok {
+			for _, v := range in {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		}
+		if pattern, ok := cond["$regex"].(string); ok {
+			if cond["$options"] == "i" {
+				pattern = "(?i)" + pattern
+			}
+			return regexp.MustCompile(pattern).MatchString(value)
+		}
+	}
+	return false
+}
+
+// update applies fn to the subscriptions matching selector, and returns their number.
+func (f *fakeRepository) update(selector map[string]interface{}, fn func(s *core.Subscription)) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for i := range f.subscriptions {
+		if f.match(f.subscriptions[i], selector) {
+			fn(&f.subscriptions[i])
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRepository) FindAll(ctx context.Context, selector map[string]interface{}) ([]core.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []core.Subscription
+	for _, s := range f.subscriptions {
+		if f.match(s, selector) {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeRepository) Each(ctx context.Context, selector map[string]interface{}, fn func(core.Subscription) error) error {
+	found, _ := f.FindAll(ctx, selector)
+	for _, s := range found {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) Count(ctx context.Context, selector map[string]interface{}) (int, error) {
+	found, _ := f.FindAll(ctx, selector)
+	return len(found), nil
+}
+
+func (f *fakeRepository) Tag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error) {
+	return f.update(selector, func(s *core.Subscription) { s.Tags = applyTags(s.Tags, tags, false) }), nil
+}
+
+func (f *fakeRepository) Untag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error) {
+	return f.update(selector, func(s *core.Subscription) { s.Tags = applyTags(s.Tags, tags, true) }), nil
+}
+
+func (f *fakeRepository) Trash(ctx context.Context, selector map[string]interface{}) (int, error) {
+	return f.update(selector, func(s *core.Subscription) { s.DeletedAt = time.Now() }), nil
+}
+
+func (f *fakeRepository) Restore(ctx context.Context, selector map[string]interface{}) (int, error) {
+	return f.update(core.InTrash(selector), func(s *core.Subscription) { s.DeletedAt = time.Time{} }), nil
+}
+
+func (f *fakeRepository) SetVerification(ctx context.Context, selector map[string]interface{}, resp core.EmailVerificationResponse) (int, error) {
+	if f.beforeSetVerification != nil {
+		f.beforeSetVerification()
+	}
+	return f.update(selector, func(s *core.Subscription) { s.EmailVerificationResponse = resp }), nil
+}
+
+// Remove applies whether the subscription is in the trash or not.
+func (f *fakeRepository) Remove(ctx context.Context, selector map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.subscriptions[:0]
+	for _, s := range f.subscriptions {
+		matched := matchKeys(s, selector)
+		if _, ok := selector["deletedAt"]; ok {
+			matched = f.match(s, selector)
+		}
+		if !matched {
+			kept = append(kept, s)
+		}
+	}
+	f.subscriptions = kept
+	return nil
+}
+
+func (f *fakeRepository) Upsert(ctx context.Context, subscription core.Subscription) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, s := range f.subscriptions {
+		if s.List == subscription.List && s.Email == subscription.Email {
+			f.subscriptions[i] = subscription
+			return nil
+		}
+	}
+	f.subscriptions = append(f.subscriptions, subscription)
+	return nil
+}
+
+func (f *fakeRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeRepository) FindLists(ctx context.Context) ([]core.List, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]core.List(nil), f.lists...), nil
+}
+
+func (f *fakeRepository) FindList(ctx context.Context, slug string) (core.List, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.lists {
+		if l.Slug == slug {
+			return l, nil
+		}
+	}
+	return core.List{}, core.Errorf(core.NotFound, "list not found")
+}
+
+func (f *fakeRepository) UpsertList(ctx context.Context, list core.List) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, l := range f.lists {
+		if l.Slug == list.Slug {
+			f.lists[i] = list
+			return nil
+		}
+	}
+	f.lists = append(f.lists, list)
+	return nil
+}
+
+func (f *fakeRepository) FindSegments(ctx context.Context) ([]core.Segment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]core.Segment(nil), f.segments...), nil
+}
+
+func (f *fakeRepository) FindSegment(ctx context.Context, slug string) (core.Segment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.segments {
+		if s.Slug == slug {
+			return s, nil
+		}
+	}
+	return core.Segment{}, core.Errorf(core.NotFound, "segment not found")
+}
+
+func (f *fakeRepository) UpsertSegment(ctx context.Context, segment core.Segment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, s := range f.segments {
+		if s.Slug == segment.Slug {
+			f.segments[i] = segment
+			return nil
+		}
+	}
+	f.segments = append(f.segments, segment)
+	return nil
+}
+
+func (f *fakeRepository) RemoveSegment(ctx context.Context, slug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, s := range f.segments {
+		if s.Slug == slug {
+			f.segments = append(f.segments[:i], f.segments[i+1:]...)
+			return nil
+		}
+	}
+	return core.Errorf(core.NotFound, "segment not found")
+}
+
+func (f *fakeRepository) AddTombstone(ctx context.Context, tombstone core.Tombstone) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tombstones = append(f.tombstones, tombstone)
+	return nil
+}
+
+func (f *fakeRepository) ErasedEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	erased := map[string]bool{}
+	for _, email := range emails {
+		for _, t := range f.tombstones {
+			if t.Hash == f.hasher.Hash(email) {
+				erased[email] = true
+			}
+		}
+	}
+	return erased, nil
+}
+
+func (f *fakeRepository) RecordConsent(ctx context.Context, consent core.Consent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.consents = append(f.consents, consent)
+	return nil
+}
+
+func (f *fakeRepository) ConfirmConsent(ctx context.Context, list, email string, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.consents {
+		if c.List == list && c.Email == email && c.ConfirmedAt == nil {
+			f.consents[i].ConfirmedAt = &at
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) FindConsents(ctx context.Context, email string) ([]core.Consent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []core.Consent
+	for _, c := range f.consents {
+		if strings.EqualFold(c.Email, email) {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeRepository) AnonymizeConsents(ctx context.Context, email, pseudonym string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for i, c := range f.consents {
+		if strings.EqualFold(c.Email, email) {
+			f.consents[i].Email, f.consents[i].IP, f.consents[i].UserAgent = pseudonym, "", ""
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeRepository) FindUser(ctx context.Context, name string) (core.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.userLookups++
+	for _, u := range f.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return core.User{}, core.Errorf(core.NotFound, "user not found")
+}
+
+func (f *fakeRepository) UpsertUser(ctx context.Context, user core.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users = append(f.users, user)
+	return nil
+}
+
+func (f *fakeRepository) CountUsers(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.users), nil
+}
+
+func (f *fakeRepository) RecordVerification(ctx context.Context, v core.Verification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.verifications = append(f.verifications, v)
+	return nil
+}
+
+func (f *fakeRepository) FindVerifications(ctx context.Context, email string) ([]core.Verification, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []core.Verification
+	for _, v := range f.verifications {
+		if strings.EqualFold(v.Email, email) {
+			found = append(found, v)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeRepository) RemoveVerifications(ctx context.Context, email string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.verifications[:0]
+	for _, v := range f.verifications {
+		if !strings.EqualFold(v.Email, email) {
+			kept = append(kept, v)
+		}
+	}
+	n := len(f.verifications) - len(kept)
+	f.verifications = kept
+	return n, nil
+}
+
+func (f *fakeRepository) CountVerificationsBefore(ctx context.Context, t time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, v := range f.verifications {
+		if v.Time.Before(t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeRepository) RemoveVerificationsBefore(ctx context.Context, t time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.verifications[:0]
+	for _, v := range f.verifications {
+		if !v.Time.Before(t) {
+			kept = append(kept, v)
+		}
+	}
+	n := len(f.verifications) - len(kept)
+	f.verifications = kept
+	return n, nil
+}
+
+func (f *fakeRepository) RecordAudit(ctx context.Context, entry core.AuditEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeRepository) FindAudit(ctx context.Context, filter core.AuditFilter) ([]core.AuditEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []core.AuditEntry
+	for _, a := range f.audit {
+		if (filter.Actor == "" || a.Actor == filter.Actor) && (filter.Action == "" || a.Action == filter.Action) && (filter.Target == "" || a.Target == filter.Target) &&
+			(len(filter.Targets) == 0 || matchValue(strings.ToLower(a.Target), map[string]interface{}{"$in": filter.Targets})) &&
+			(filter.Since.IsZero() || !a.Time.Before(filter.Since)) && (filter.Until.IsZero() || a.Time.Before(filter.Until)) {
+			found = append(found, a)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Time.After(found[j].Time) })
+	if filter.Limit > 0 && len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, nil
+}
+
+func (f *fakeRepository) AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for i, a := range f.audit {
+		if strings.EqualFold(a.Target, email) {
+			f.audit[i].Target, f.audit[i].Before, f.audit[i].After = pseudonym, nil, nil
+			n++
+		}
+	}
+	return n, nil
+}
+
+// actions returns the actions recorded in the audit trail, in order.
+func (f *fakeRepository) actions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var actions []string
+	for _, a := range f.audit {
+		actions = append(actions, a.Action)
+	}
+	return actions
+}
+
+// fakeMailChecker finds every email valid, unless it is listed in invalid.
+type fakeMailChecker struct {
+	invalid map[string]bool
+}
+
+func (f fakeMailChecker) Validate(ctx context.Context, email string) (core.EmailVerificationResponse, error) {
+	return core.EmailVerificationResponse{Email: email, Valid: !f.invalid[email], Score: 0.9}, nil
+}
+
+// fakeMailer records the messages sent, and fails when err is set.
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []core.Message
+	err  error
+}
+
+func (f *fakeMailer) Send(ctx context.Context, msg core.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeMailer) messages() []core.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]core.Message(nil), f.sent...)
+}
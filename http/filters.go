@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/exporter"
+	"github.com/klebervirgilio/go-echo-basics/segment"
+
+	"github.com/labstack/echo"
+)
+
+// filterParams are the query parameters of the subscriptions page search and filter.
+var filterParams = []string{"list", "q", "valid", "segment"}
+
+// subscriptionSelector builds the repository selector from the search and filter query
+// parameters of the subscriptions page: `list` filters on the list slug, `q` searches the name and
+// email, case insensitively, `valid` ("true" or "false") filters on the last verification result
+// and `segment` is a segment expression, see the segment package.
+func subscriptionSelector(c echo.Context) (map[string]interface{}, error) {
+	selector := map[string]interface{}{}
+	if expression := c.QueryParam("segment"); expression != "" {
+		query, err := segment.Compile(expression, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		selector["$and"] = []interface{}{query}
+	}
+	if list := c.QueryParam("list"); list != "" {
+		selector["list"] = list
+	}
+	if q := c.QueryParam("q"); q != "" {
+		pattern := map[string]interface{}{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		selector["$or"] = []interface{}{
+			map[string]interface{}{"email": pattern},
+			map[string]interface{}{"fullName": pattern},
+		}
+	}
+	switch c.QueryParam("valid") {
+	case "true":
+		selector["emailVerificationResponse.valid"] = true
+	case "false":
+		selector["emailVerificationResponse.valid"] = false
+	}
+	return selector, nil
+}
+
+// exportLinks returns the URL of the export of the current search and filter, by format.
+func exportLinks(c echo.Context) map[string]string {
+	links := map[string]string{}
+	for _, format := range []string{exporter.CSV, exporter.JSONL, exporter.VCard} {
+		query := filterQuery(c)
+		query.Set("format", format)
+		links[format] = c.Echo().Reverse("export-subscriptions") + "?" + query.Encode()
+	}
+	return links
+}
+
+// filterQuery returns the current search and filter, to be kept by the links of the page.
+func filterQuery(c echo.Context) url.Values {
+	values := url.Values{}
+	for _, p := range filterParams {
+		if v := c.QueryParam(p); v != "" {
+			values.Set(p, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+
+	"github.com/labstack/echo"
+)
+
+// GDPRHandler renders the gdpr.html page, where the admins answer the data subject requests.
+func GDPRHandler(c echo.Context) error {
+	return c.Render(http.StatusOK, "gdpr.html", ViewContext{
+		"page":    "gdpr",
+		"success": c.QueryParam("success"),
+		"error":   c.QueryParam("error"),
+	})
+}
+
+// gdprExportHandler downloads everything stored about the email given by the `email` query parameter as JSON.
+func gdprExportHandler(service gdpr.Service) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		email := c.QueryParam("email")
+		bundle, err := service.Export(c.Request().Context(), email, actor(c), c.RealIP())
+		if err != nil {
+			return err
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="gdpr-%s.json"`, service.Hasher.Hash(email)[:12]))
+		res.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(res)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bundle)
+	}
+}
+
+// gdprEraseHandler erases the email submitted with the gdpr.html form, once confirmed by typing it again.
+// An erasure failing midway is reported with its steps left, for the admin to erase the email again.
+func gdprEraseHandler(service gdpr.Service, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		email := c.FormValue("email")
+		if email == "" || c.FormValue("confirm") != email {
+			return redirectWithFlashMessage(c, e, "gdpr", "error", "Type the e-mail again to confirm the erasure")
+		}
+		erasure, err := service.Erase(c.Request().Context(), email, actor(c), c.RealIP())
+		if core.KindOf(err) == core.InvalidInput {
+			return redirectWithFlashMessage(c, e, "gdpr", "error", err.Error())
+		}
+		if err != nil && len(erasure.Left) > 0 {
+			logging.FromContext(c.Request().Context()).Error("erasure failed midway", "left", erasure.Left, "err", err)
+			return redirectWithFlashMessage(c, e, "gdpr", "error", fmt.Sprintf(
+				"The erasure of %s failed before erasing its %s: erase it again to complete it",
+				email, strings.Join(erasure.Left, ", ")))
+		}
+		if err != nil {
+			return err
+		}
+		return redirectWithFlashMessage(c, e, "gdpr", "success", fmt.Sprintf(
+			"%s has been erased: %d subscriptions and %d verifications removed, %d consents and %d audit entries anonymised",
+			email, erasure.Subscriptions, erasure.Verifications, erasure.Consents, erasure.AuditEntries))
+	}
+}
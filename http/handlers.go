@@ -1,14 +1,14 @@
 package http
 
 import (
-	"errors"
+	"context"
 	"net/http"
-	"regexp"
 	"sync"
 	"time"
 
-	"github.com/klebervirgilio/go-echo-basics/config"
 	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/metrics"
 
 	"github.com/labstack/echo"
 )
@@ -17,64 +17,95 @@ import (
 type ViewContext map[string]interface{}
 
 // checkEmailHandler performs a correctness check on a given email provided via URL parameter or
-// runs the check in parallel for all subscriptons email found in the database.
+// runs the check in parallel for all subscriptons email found in the database, concurrency emails at a time.
 // The handler purposes is to exercise the ability of conditionally use a handler and
 // how Go make it easy to achieve concurrency.
-func checkEmailHandler(repo core.Repository, e *echo.Echo, mailChecker core.MailChecker, config *config.Config) echo.HandlerFunc {
+func checkEmailHandler(repo core.Repository, audit core.AuditLog, e *echo.Echo, mailChecker core.MailChecker, workers *workerGroup, concurrency func() int) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		if email := c.Param("email"); email != "" {
-			resp, err := mailChecker.Validate(email)
+			resp, err := mailChecker.Validate(ctx, email)
 			if err != nil {
 				return err
 			}
 
-			subscriptions, err := repo.FindAll(map[string]interface{}{"email": email})
+			subscriptions, err := repo.FindAll(ctx, map[string]interface{}{"email": email})
 			if err != nil {
 				return err
 			}
 			if len(subscriptions) == 0 {
-				return errors.New("Could not find a subscription for the given email")
+				return core.Errorf(core.NotFound, "Could not find a subscription for the given email")
 			}
-			subscription := subscriptions[0]
-			subscription.EmailVerificationResponse = resp
 
-			err = repo.Upsert(subscription)
-			if err != nil {
-				return err
+			// The verification is about the address, so it applies to all its lists.
+			for _, subscription := range subscriptions {
+				n, err := repo.SetVerification(ctx, map[string]interface{}{"list": subscription.List, "email": subscription.Email}, resp)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					continue
+				}
+				err = recordAdmin(c, audit, core.AuditEntry{
+					Action: "subscription.validate",
+					Target: subscription.Email,
+					List:   subscription.List,
+					Before: verificationSnapshot(subscription.EmailVerificationResponse),
+					After:  verificationSnapshot(resp),
+				})
+				if err != nil {
+					return err
+				}
 			}
 
 			return c.JSON(http.StatusOK, resp)
 		}
 
-		subscriptions, err := repo.FindAll(map[string]interface{}{})
+		subscriptions, err := repo.FindAll(ctx, map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		err = recordAdmin(c, audit, core.AuditEntry{
+			Action: "subscriptions.validate-all",
+			Target: "all",
+			After:  map[string]interface{}{"subscriptions": len(subscriptions)},
+		})
 		if err != nil {
 			return err
 		}
 
 		var wg sync.WaitGroup
-		errCh := make(chan error, 1)
+		errCh := make(chan error, len(subscriptions))
 		doneCh := make(chan struct{}, 1)
+		slots := make(chan struct{}, concurrency())
 
+		// The validations may outlive the request, so they run in the worker group
+		// which is drained when the server shuts down.
 		wg.Add(len(subscriptions))
+		bulkValidationPending.With().Add(float64(len(subscriptions)))
 		for _, subscription := range subscriptions {
-			go func(sub core.Subscription) {
-				e.Logger.Info("Checking email ", sub.Email)
-				resp, err := mailChecker.Validate(sub.Email)
-				if err != nil {
-					errCh <- err
+			sub := subscription
+			workers.Go(logging.FromContext(ctx), func(ctx context.Context) {
+				defer wg.Done()
+				defer bulkValidationPending.With().Add(-1)
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					errCh <- ctx.Err()
 					return
 				}
-
-				sub.EmailVerificationResponse = resp
-
-				err = repo.Upsert(sub)
+				logging.FromContext(ctx).Debug("checking email", "email", sub.Email)
+				resp, err := mailChecker.Validate(ctx, sub.Email)
+				if err == nil {
+					// The subscription may have changed, or left the list, since it was read.
+					_, err = repo.SetVerification(ctx, map[string]interface{}{"list": sub.List, "email": sub.Email}, resp)
+				}
+				bulkValidationProcessed.With(metrics.Outcome(err)).Inc()
 				if err != nil {
 					errCh <- err
-					return
 				}
-
-				wg.Done()
-			}(subscription)
+			})
 		}
 
 		go func() {
@@ -93,12 +124,50 @@ func checkEmailHandler(repo core.Repository, e *echo.Echo, mailChecker core.Mail
 	}
 }
 
+// deleteEmailHandler moves to the trash the subscription of the email given in the URL to the list
+// given by the `list` query parameter. See TrashHandler.
+func deleteEmailHandler(repo core.Repository, audit core.AuditLog) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		list := c.QueryParam("list")
+		if list == "" {
+			return core.Errorf(core.InvalidInput, "The list of the subscription is required")
+		}
+		selector := map[string]interface{}{"list": list, "email": c.Param("email")}
+		subscriptions, err := repo.FindAll(ctx, selector)
+		if err != nil {
+			return err
+		}
+		if len(subscriptions) == 0 {
+			return core.Errorf(core.NotFound, "Could not find a subscription for the given email")
+		}
+		if _, err := repo.Trash(ctx, selector); err != nil {
+			return err
+		}
+		subscriptionsUnsubscribed.With().Inc()
+		return recordAdmin(c, audit, core.AuditEntry{
+			Action: "subscription.trash",
+			Target: subscriptions[0].Email,
+			List:   list,
+			Before: subscriptionSnapshot(subscriptions[0]),
+		})
+	}
+}
+
 // FullListHandler renders the subscriptions.html page.
 // The user should able to see all subscriptions when the properly authenticated.
 // The handler purposes is to show how dependencies can be injected.
-func FullListHandler(repo core.Repository) echo.HandlerFunc {
+func FullListHandler(repo core.Repository, lists core.ListRepository) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		subscriptions, err := repo.FindAll(map[string]interface{}{})
+		selector, err := subscriptionSelector(c)
+		if err != nil {
+			return err
+		}
+		subscriptions, err := repo.FindAll(c.Request().Context(), selector)
+		if err != nil {
+			return err
+		}
+		allLists, err := lists.FindLists(c.Request().Context())
 		if err != nil {
 			return err
 		}
@@ -107,58 +176,219 @@ func FullListHandler(repo core.Repository) echo.HandlerFunc {
 			"success":       c.QueryParam("success"),
 			"error":         c.QueryParam("error"),
 			"page":          "subscriptions",
+			"q":             c.QueryParam("q"),
+			"valid":         c.QueryParam("valid"),
+			"list":          c.QueryParam("list"),
+			"segment":       c.QueryParam("segment"),
+			"lists":         allLists,
+			"exports":       exportLinks(c),
+			"query":         filterQuery(c).Encode(),
 		})
 	}
 }
 
 // HomeHandler is the application landing page.
 // Visitors should be able to subscribe themselves to a mailist using the subscribe form.
+// The page subscribes to the list given by the `slug` URL parameter, or to the default list.
 // The handler purposes is to show how simple it is to render dynamic html pages.
-func HomeHandler(c echo.Context) error {
-	return c.Render(http.StatusOK, "subscribe.html", ViewContext{
-		"page":    "subscribe",
-		"success": c.QueryParam("success"),
-		"error":   c.QueryParam("error"),
-	})
+// The form shows the consent text, which subscribers must agree to.
+func HomeHandler(lists core.ListRepository, consent core.ConsentText, defaultList string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		slug := c.Param("slug")
+		if slug == "" {
+			slug = defaultList
+		}
+		list, err := lists.FindList(c.Request().Context(), slug)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "subscribe.html", ViewContext{
+			"page":    "subscribe",
+			"list":    list,
+			"consent": consent,
+			"success": c.QueryParam("success"),
+			"error":   c.QueryParam("error"),
+		})
+	}
 }
 
 // SubscribeHandler handles the subscribe form submission
 // The handler purposes is to perform a very basic validation in the request inputs with the regexp package as well as
 // introduce Echo's Redirect function.
-func SubscribeHandler(repo core.Repository, e *echo.Echo) echo.HandlerFunc {
+// Subscriptions to double opt-in lists stay pending until confirmed with ConfirmHandler, whose
+// link is sent by e-mail.
+// The consent to the text shown by the form is recorded with the request IP and user agent.
+// An existing subscription is not changed, its confirmation or welcome mail is sent again.
+func SubscribeHandler(repo core.Repository, lists core.ListRepository, consents core.ConsentRepository, consent core.ConsentText, mailer core.Mailer, baseURL string, e *echo.Echo, defaultList string) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		email := c.FormValue("email")
 		fullName := c.FormValue("full-name")
+		slug := c.FormValue("list")
+		if slug == "" {
+			slug = defaultList
+		}
 
-		if email == "" || fullName == "" {
-			return c.Render(http.StatusUnprocessableEntity, "subscribe.html", ViewContext{
-				"page":     "subscribe",
-				"email":    email,
-				"fullName": fullName,
-				"error":    "Invalid name or e-mail",
-			})
+		list, err := lists.FindList(ctx, slug)
+		if err != nil {
+			return err
 		}
 
-		emailRE := regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
-		if !emailRE.MatchString(email) {
+		form, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		invalid := func(msg string) error {
 			return c.Render(http.StatusUnprocessableEntity, "subscribe.html", ViewContext{
 				"page":     "subscribe",
+				"list":     list,
 				"email":    email,
 				"fullName": fullName,
-				"error":    "Invalid e-mail",
+				"values":   form,
+				"consent":  consent,
+				"error":    msg,
 			})
 		}
 
-		err := repo.Upsert(core.Subscription{Email: email, Name: fullName})
+		if email == "" || fullName == "" {
+			return invalid("Invalid name or e-mail")
+		}
+
+		if !core.ValidEmail(email) {
+			return invalid("Invalid e-mail")
+		}
+		email = core.NormalizeEmail(email)
+
+		values := map[string][]string{}
+		for _, f := range list.Fields {
+			values[f.Name] = form[fieldInput(f.Name)]
+		}
+		fields, err := list.ParseFields(values)
+		if err != nil {
+			return invalid(err.Error())
+		}
+
+		if form.Get("consent-version") != consent.Version {
+			return invalid("Our terms have changed, please review them")
+		}
+		if form.Get("consent") == "" {
+			return invalid("Please, agree to the terms to subscribe")
+		}
+
+		existing, err := repo.FindAll(ctx, map[string]interface{}{"list": list.Slug, "email": email})
 		if err != nil {
 			return err
 		}
+		var subscription core.Subscription
+		if len(existing) > 0 {
+			// Anyone can submit the form with the email of a subscriber: the subscription is
+			// left as is, and the owner of the email changes it from the preferences page.
+			subscription = existing[0]
+		} else {
+			subscription = core.Subscription{List: list.Slug, Email: email, Name: fullName, Status: core.StatusConfirmed, Token: core.NewToken(), SubscribedAt: time.Now()}
+			if list.DoubleOptIn {
+				subscription.Status = core.StatusPending
+			}
+			if len(list.Fields) > 0 {
+				subscription.Fields = fields
+			}
+			subscription.Consent = &core.Consent{
+				Email:       email,
+				List:        list.Slug,
+				Form:        "subscribe",
+				GivenAt:     time.Now(),
+				IP:          c.RealIP(),
+				UserAgent:   c.Request().UserAgent(),
+				TextVersion: consent.Version,
+				Text:        consent.Text,
+			}
+
+			if err := consents.RecordConsent(ctx, *subscription.Consent); err != nil {
+				return err
+			}
+			if err := repo.Upsert(ctx, subscription); err != nil {
+				return err
+			}
+			subscriptionsCreated.With().Inc()
+		}
+
+		msg := "You have been successfully subscribed"
+		if subscription.Status == core.StatusPending {
+			msg = "Almost there! Please, confirm your subscription with the link we have sent you"
+			if err := mailer.Send(ctx, confirmationMessage(e, baseURL, list, subscription)); err != nil {
+				return err
+			}
+			logging.FromContext(ctx).Debug("subscription pending confirmation", "list", list.Slug, "email", email)
+		} else {
+			sendWelcome(ctx, mailer, welcomeMessage(e, baseURL, list, subscription))
+			logging.FromContext(ctx).Debug("subscription confirmed", "list", list.Slug, "email", email)
+		}
 
 		if hd := c.Request().Header["Authorization"]; len(hd) != 0 {
-			return redirectWithFlashMessage(c, e, "root", "subscriptions", "You have been successfully subscribed")
+			return redirectWithFlashMessage(c, e, "root", "subscriptions", msg)
+		}
+		if list.Slug != defaultList {
+			return redirectWithFlashMessage(c, e, "list-home", "success", msg, list.Slug)
 		}
+		return redirectWithFlashMessage(c, e, "root", "success", msg)
+	}
+
+}
+
+// fieldInput returns the name of the subscribe form input of a custom field.
+func fieldInput(name string) string {
+	return "field." + name
+}
 
-		return redirectWithFlashMessage(c, e, "root", "success", "You have been successfully subscribed")
+// mergeFields replaces the values of the list fields in current, keeping the values of
+// the fields which are not asked by the list, such as the imported ones.
+func mergeFields(current map[string]interface{}, defs []core.Field, values map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range current {
+		merged[k] = v
 	}
+	for _, f := range defs {
+		delete(merged, f.Name)
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ConfirmHandler confirms the pending subscription owning the token given in the URL, and sends
+// the link to the preferences by e-mail.
+func ConfirmHandler(repo core.Repository, lists core.ListRepository, consents core.ConsentRepository, mailer core.Mailer, baseURL string, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		subscriptions, err := repo.FindAll(ctx, map[string]interface{}{"token": c.Param("token")})
+		if err != nil {
+			return err
+		}
+		if len(subscriptions) == 0 {
+			return core.Errorf(core.NotFound, "This confirmation link is invalid")
+		}
 
+		subscription := subscriptions[0]
+		if subscription.Status != core.StatusConfirmed {
+			now := time.Now()
+			subscription.Status = core.StatusConfirmed
+			if subscription.Consent != nil {
+				subscription.Consent.ConfirmedAt = &now
+			}
+			if err := consents.ConfirmConsent(ctx, subscription.List, subscription.Email, now); err != nil {
+				return err
+			}
+			if err := repo.Upsert(ctx, subscription); err != nil {
+				return err
+			}
+			subscriptionsConfirmed.With().Inc()
+			list, err := lists.FindList(ctx, subscription.List)
+			if err != nil {
+				return err
+			}
+			sendWelcome(ctx, mailer, welcomeMessage(e, baseURL, list, subscription))
+		}
+		return redirectWithFlashMessage(c, e, "list-home", "success", "Your subscription is confirmed", subscription.List)
+	}
 }
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+// CheckResult is the outcome of a dependency check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Readiness is the /readyz response body.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// LivenessHandler reports the process is alive. It does not touch any dependency.
+func LivenessHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessHandler pings every given dependency in parallel and reports whether the application
+// can serve traffic. While the server is shutting down it always reports not ready, so the load
+// balancer stops routing requests to it.
+func readinessHandler(s *Server, deps map[string]core.Pinger, timeout time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.isShuttingDown() {
+			return c.JSON(http.StatusServiceUnavailable, Readiness{Status: "shutting down", Checks: map[string]CheckResult{}})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+		defer cancel()
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		readiness := Readiness{Status: "ready", Checks: make(map[string]CheckResult, len(deps))}
+		for name, dep := range deps {
+			wg.Add(1)
+			go func(name string, dep core.Pinger) {
+				defer wg.Done()
+				start := time.Now()
+				err := dep.Ping(ctx)
+				result := CheckResult{Status: "up", LatencyMS: float64(time.Since(start)) / float64(time.Millisecond)}
+				if err != nil {
+					result.Status = "down"
+					result.Error = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				readiness.Checks[name] = result
+				if err != nil {
+					readiness.Status = "not ready"
+				}
+			}(name, dep)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		if readiness.Status != "ready" {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, readiness)
+	}
+}
+
+// readinessChecks returns the dependencies probed by /readyz: the repository and,
+// when the `health.mailChecker` setting is on, the mail checker.
+func (s *Server) readinessChecks() map[string]core.Pinger {
+	deps := map[string]core.Pinger{}
+	if p, ok := s.SubscriptionRepository.(core.Pinger); ok {
+		deps["mongo"] = p
+	}
+	if p, ok := s.MailChecker.(core.Pinger); ok && s.Config.Health.MailChecker {
+		deps["mailChecker"] = p
+	}
+	return deps
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/importer"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+
+	"github.com/labstack/echo"
+)
+
+// maxImportReports is the number of import reports kept for download.
+const maxImportReports = 50
+
+// importReports keeps the latest import reports in memory so their errors can be downloaded.
+type importReports struct {
+	mu      sync.Mutex
+	ids     []string
+	reports map[string]importer.Report
+}
+
+func newImportReports() *importReports {
+	return &importReports{reports: map[string]importer.Report{}}
+}
+
+func (r *importReports) add(report importer.Report) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := newErrorID()
+	r.ids = append(r.ids, id)
+	r.reports[id] = report
+	if len(r.ids) > maxImportReports {
+		delete(r.reports, r.ids[0])
+		r.ids = r.ids[1:]
+	}
+	return id
+}
+
+func (r *importReports) get(id string) (importer.Report, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report, ok := r.reports[id]
+	return report, ok
+}
+
+// ImportFormHandler renders the import.html page.
+func ImportFormHandler(lists core.ListRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		all, err := lists.FindLists(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "import.html", ViewContext{
+			"page":  "import",
+			"lists": all,
+			"list":  c.QueryParam("list"),
+		})
+	}
+}
+
+// importHandler imports the uploaded CSV or TSV file, then renders the report.
+// When asked, the imported addresses are validated in background.
+func importHandler(repo core.Repository, lists core.ListRepository, tombstones core.TombstoneRepository, consents core.ConsentRepository, audit core.AuditLog, mailChecker core.MailChecker, workers *workerGroup, reports *importReports) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		all, err := lists.FindLists(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		render := func(code int, ctx ViewContext) error {
+			ctx["page"] = "import"
+			ctx["mapping"] = c.FormValue("mapping")
+			ctx["consentSource"] = c.FormValue("consent-source")
+			ctx["lists"] = all
+			ctx["list"] = c.FormValue("list")
+			return c.Render(code, "import.html", ctx)
+		}
+
+		list, err := lists.FindList(c.Request().Context(), c.FormValue("list"))
+		if err != nil {
+			return err
+		}
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			return render(http.StatusUnprocessableEntity, ViewContext{"error": "Please, choose a file to import"})
+		}
+		mapping, err := importer.ParseMapping(c.FormValue("mapping"))
+		if err != nil {
+			return render(http.StatusUnprocessableEntity, ViewContext{"error": err.Error()})
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		report, err := importer.Import(c.Request().Context(), repo, f, importer.Options{
+			List:          list,
+			Mapping:       mapping,
+			Comma:         importer.CommaFor(file.Filename),
+			DryRun:        c.FormValue("dry-run") != "",
+			Tombstones:    tombstones,
+			ConsentSource: c.FormValue("consent-source"),
+			Consents:      consents,
+		})
+		if core.KindOf(err) == core.InvalidInput {
+			return render(http.StatusUnprocessableEntity, ViewContext{"error": err.Error()})
+		}
+		if err != nil {
+			return err
+		}
+		subscriptionsCreated.With().Add(float64(report.Imported))
+		if !report.DryRun {
+			err := recordAdmin(c, audit, core.AuditEntry{
+				Action: "subscriptions.import",
+				Target: list.Slug,
+				List:   list.Slug,
+				After: map[string]interface{}{
+					"file":          file.Filename,
+					"imported":      report.Imported,
+					"duplicates":    report.Duplicates,
+					"rejected":      len(report.Errors),
+					"consentSource": strings.TrimSpace(c.FormValue("consent-source")),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if !report.DryRun && c.FormValue("validate") != "" && len(report.Emails) > 0 {
+			emails := report.Emails
+			workers.Go(logging.FromContext(c.Request().Context()), func(ctx context.Context) {
+				if err := importer.Validate(ctx, repo, mailChecker, emails); err != nil {
+					logging.FromContext(ctx).Error("failed to validate imported subscriptions", "err", err)
+				}
+			})
+		}
+
+		return render(http.StatusOK, ViewContext{
+			"report":   report,
+			"reportID": reports.add(report),
+		})
+	}
+}
+
+// importErrorsHandler downloads the rejected rows of an import as CSV.
+func importErrorsHandler(reports *importReports) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, ok := reports.get(c.Param("id"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "This import report has expired")
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="import-errors.csv"`)
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		return report.WriteErrors(c.Response())
+	}
+}
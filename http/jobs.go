@@ -0,0 +1,112 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// maxJobs is the number of bulk jobs kept for their progress and results.
+const maxJobs = 50
+
+// maxJobErrors is the number of failures listed by a job status.
+const maxJobErrors = 20
+
+// JobStatus is the progress of a bulk job.
+type JobStatus struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	Total      int       `json:"total"`
+	Done       int       `json:"done"`
+	Failed     int       `json:"failed"`
+	Errors     []string  `json:"errors,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	Finished   bool      `json:"finished"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Download is set once an export is ready.
+	Download bool `json:"download"`
+}
+
+// Percent is the share of the subscriptions processed.
+func (s JobStatus) Percent() int {
+	if s.Total == 0 {
+		return 100
+	}
+	return s.Done * 100 / s.Total
+}
+
+// job is a bulk action running in background, updated by its worker and read by the requests.
+type job struct {
+	mu     sync.Mutex
+	status JobStatus
+	// result is the file produced by an export.
+	result      []byte
+	filename    string
+	contentType string
+}
+
+// progress records that a subscription has been processed, and failed unless err is nil.
+func (j *job) progress(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done++
+	if err != nil {
+		j.status.Failed++
+		if len(j.status.Errors) < maxJobErrors {
+			j.status.Errors = append(j.status.Errors, err.Error())
+		}
+	}
+}
+
+func (j *job) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Finished, j.status.FinishedAt = true, time.Now()
+}
+
+// attach stores the file produced by the job.
+func (j *job) attach(filename, contentType string, b []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.filename, j.contentType, j.result = filename, contentType, b
+	j.status.Download = true
+}
+
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Errors = append([]string(nil), j.status.Errors...)
+	return status
+}
+
+// jobs keeps the latest bulk jobs in memory.
+type jobs struct {
+	mu   sync.Mutex
+	ids  []string
+	byID map[string]*job
+}
+
+func newJobs() *jobs {
+	return &jobs{byID: map[string]*job{}}
+}
+
+func (js *jobs) add(action string, total int) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	j := &job{status: JobStatus{ID: newErrorID(), Action: action, Total: total, StartedAt: time.Now()}}
+	js.ids = append(js.ids, j.status.ID)
+	js.byID[j.status.ID] = j
+	if len(js.ids) > maxJobs {
+		delete(js.byID, js.ids[0])
+		js.ids = js.ids[1:]
+	}
+	return j
+}
+
+func (js *jobs) get(id string) (*job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	return j, ok
+}
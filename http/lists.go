@@ -0,0 +1,120 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+// newFieldRows is the number of blank custom field rows of the lists.html form.
+const newFieldRows = 3
+
+// ListsHandler renders the lists.html page, where the admins manage the mailing lists.
+func ListsHandler(lists core.ListRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		all, err := lists.FindLists(c.Request().Context())
+		if err != nil {
+			return err
+		}
+
+		edit := core.List{}
+		if slug := c.QueryParam("edit"); slug != "" {
+			if edit, err = lists.FindList(c.Request().Context(), slug); err != nil {
+				return err
+			}
+		}
+
+		// Blank rows let the admins add fields.
+		rows := append(append([]core.Field{}, edit.Fields...), make([]core.Field, newFieldRows)...)
+
+		return c.Render(http.StatusOK, "lists.html", ViewContext{
+			"page":       "lists",
+			"lists":      all,
+			"edit":       edit,
+			"fieldRows":  rows,
+			"fieldTypes": core.FieldTypes,
+			"success":    c.QueryParam("success"),
+			"error":      c.QueryParam("error"),
+		})
+	}
+}
+
+// SaveListHandler creates or updates the list submitted with the lists.html form.
+func SaveListHandler(lists core.ListRepository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		list := core.List{
+			Slug:        c.FormValue("slug"),
+			Name:        c.FormValue("name"),
+			Description: c.FormValue("description"),
+			DoubleOptIn: c.FormValue("double-opt-in") != "",
+		}
+		form, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		list.Fields = formFields(form)
+		if err := list.Validate(); err != nil {
+			return redirectWithFlashMessage(c, e, "lists", "error", err.(*core.Error).Msg)
+		}
+		entry := core.AuditEntry{Action: "list.save", Target: list.Slug, List: list.Slug, After: listSnapshot(list)}
+		previous, err := lists.FindList(c.Request().Context(), list.Slug)
+		switch {
+		case err == nil:
+			entry.Before = listSnapshot(previous)
+		case core.KindOf(err) != core.NotFound:
+			return err
+		}
+		if err := lists.UpsertList(c.Request().Context(), list); err != nil {
+			return err
+		}
+		if err := recordAdmin(c, audit, entry); err != nil {
+			return err
+		}
+		return redirectWithFlashMessage(c, e, "lists", "success", "The list "+list.Name+" has been saved")
+	}
+}
+
+// listSnapshot returns the state of a list recorded in the audit trail.
+func listSnapshot(l core.List) map[string]interface{} {
+	snapshot := map[string]interface{}{"name": l.Name, "description": l.Description, "doubleOptIn": l.DoubleOptIn}
+	if len(l.Fields) > 0 {
+		snapshot["fields"] = l.Fields
+	}
+	return snapshot
+}
+
+// formFields reads the custom field rows of the lists.html form, skipping the ones without name.
+func formFields(form url.Values) []core.Field {
+	at := func(key string, i int) string {
+		if i < len(form[key]) {
+			return strings.TrimSpace(form[key][i])
+		}
+		return ""
+	}
+
+	var fields []core.Field
+	for i := range form["field-name"] {
+		name := at("field-name", i)
+		if name == "" {
+			continue
+		}
+		var options []string
+		for _, o := range strings.Split(at("field-options", i), ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				options = append(options, o)
+			}
+		}
+		fields = append(fields, core.Field{
+			Name:     name,
+			Label:    at("field-label", i),
+			Type:     core.FieldType(at("field-type", i)),
+			Required: at("field-required", i) != "",
+			Options:  options,
+		})
+	}
+	return fields
+}
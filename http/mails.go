@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/labstack/echo"
+)
+
+// mailLink returns the absolute URL of a named route, on the `mail.baseURL` setting.
+func mailLink(e *echo.Echo, baseURL, name string, params ...interface{}) string {
+	return strings.TrimRight(baseURL, "/") + e.Reverse(name, params...)
+}
+
+// confirmationMessage asks the subscriber of a double opt-in list to confirm the subscription.
+func confirmationMessage(e *echo.Echo, baseURL string, list core.List, s core.Subscription) core.Message {
+	return core.Message{
+		To:      s.Email,
+		Subject: "Confirm your subscription to " + list.Name,
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Please, confirm your subscription to %s by opening this link:\n\n%s\n\n"+
+			"If you did not subscribe, ignore this e-mail and you will not hear from us again.\n",
+			s.Name, list.Name, mailLink(e, baseURL, "confirm-subscription", s.Token)),
+	}
+}
+
+// welcomeMessage gives a confirmed subscriber the link to the preferences, and the one-click
+// unsubscribe link of RFC 8058, leaving only this list, to the mail clients.
+func welcomeMessage(e *echo.Echo, baseURL string, list core.List, s core.Subscription) core.Message {
+	return core.Message{
+		To:      s.Email,
+		Subject: "Welcome to " + list.Name,
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Your subscription to %s is confirmed.\n\n"+
+			"Change your preferences or unsubscribe at any time with this link:\n\n%s\n",
+			s.Name, list.Name, mailLink(e, baseURL, "preferences", s.Token)),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + mailLink(e, baseURL, "list-unsubscribe", s.Token) + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+}
+
+// sendWelcome sends the welcome message. The subscription is confirmed whether it is delivered
+// or not, so a failure is only logged.
+func sendWelcome(ctx context.Context, mailer core.Mailer, msg core.Message) {
+	if err := mailer.Send(ctx, msg); err != nil {
+		logging.FromContext(ctx).Error("failed to send the welcome e-mail", "email", msg.To, "err", err)
+	}
+}
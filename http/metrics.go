@@ -0,0 +1,16 @@
+package http
+
+import "github.com/klebervirgilio/go-echo-basics/metrics"
+
+var (
+	subscriptionsCreated = metrics.NewCounterVec("mailist_subscriptions_created_total",
+		"Subscriptions created through the subscribe form.")
+	subscriptionsConfirmed = metrics.NewCounterVec("mailist_subscriptions_confirmed_total",
+		"Subscriptions confirmed by their owners.")
+	subscriptionsUnsubscribed = metrics.NewCounterVec("mailist_subscriptions_unsubscribed_total",
+		"Subscriptions removed.")
+	bulkValidationPending = metrics.NewGaugeVec("mailist_bulk_validation_pending",
+		"Subscriptions queued by the bulk validations and not processed yet.")
+	bulkValidationProcessed = metrics.NewCounterVec("mailist_bulk_validation_processed_total",
+		"Subscriptions processed by the bulk validations by outcome.", "outcome")
+)
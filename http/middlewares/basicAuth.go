@@ -1,13 +1,117 @@
 package middlewares
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
-var RequireAuth = middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
-	if username == "golang" && password == "echo!" {
-		return true, nil
+// Default admin credentials, accepted until the first user is created with `mailist users create`.
+const (
+	defaultUser     = "golang"
+	defaultPassword = "echo!"
+)
+
+// authCacheTTL is how long verified credentials are accepted without hashing the password again.
+// A changed password, or the first user replacing the default credentials, takes up to this long
+// to apply.
+const authCacheTTL = time.Minute
+
+// RequireAuth checks the basic auth credentials against the admin users.
+// While there is no user yet, the default credentials are accepted instead.
+// The password of an unknown user is hashed too, so that the response time doesn't tell which
+// users exist, and the verified credentials are cached: the back office pages poll the jobs.
+func RequireAuth(users core.UserRepository) echo.MiddlewareFunc {
+	cache := newAuthCache()
+	return middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
+		now := time.Now()
+		if cache.verified(username, password, now) {
+			return true, nil
+		}
+		ok, err := checkCredentials(c, users, username, password)
+		if ok {
+			cache.add(username, password, now)
+		}
+		return ok, err
+	})
+}
+
+func checkCredentials(c echo.Context, users core.UserRepository, username, password string) (bool, error) {
+	ctx := c.Request().Context()
+	user, err := users.FindUser(ctx, username)
+	if err == nil {
+		return user.CheckPassword(password), nil
+	}
+	if core.KindOf(err) != core.NotFound {
+		return false, err
+	}
+	unknownUser().CheckPassword(password)
+	n, err := users.CountUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	return n == 0 && username == defaultUser && password == defaultPassword, nil
+}
+
+var (
+	unknownUserOnce sync.Once
+	unknownUserHash core.User
+)
+
+// unknownUser returns a user whose password is hashed like the real ones, checked instead of the
+// missing users.
+func unknownUser() core.User {
+	unknownUserOnce.Do(func() {
+		unknownUserHash, _ = core.NewUser("unknown", "unknown-password")
+	})
+	return unknownUserHash
+}
+
+// authCache remembers the credentials verified recently. They are identified by an HMAC under a
+// random key, so that the passwords are not kept in memory.
+type authCache struct {
+	mu      sync.Mutex
+	key     []byte
+	expires map[string]time.Time
+}
+
+func newAuthCache() *authCache {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &authCache{key: key, expires: map[string]time.Time{}}
+}
+
+func (a *authCache) id(username, password string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+func (a *authCache) verified(username, password string, now time.Time) bool {
+	id := a.id(username, password)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return now.Before(a.expires[id])
+}
+
+// add remembers the credentials, dropping the expired ones.
+func (a *authCache) add(username, password string, now time.Time) {
+	id := a.id(username, password)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, t := range a.expires {
+		if !now.Before(t) {
+			delete(a.expires, k)
+		}
 	}
-	return false, nil
-})
+	a.expires[id] = now.Add(authCacheTTL)
+}
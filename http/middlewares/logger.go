@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
+	"github.com/labstack/echo"
+)
+
+// RequestLogger attaches to the request context a logger carrying the request ID set by
+// echo's RequestID middleware, so the repository and mail checker logs can be correlated
+// with the request, then writes one access record per request.
+// Errors are handled here so the recorded status is the one sent to the client.
+func RequestLogger(logger *logging.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+			reqLogger := logger.With("request_id", c.Response().Header().Get(echo.HeaderXRequestID))
+			if sc, ok := tracing.SpanContextFromContext(req.Context()); ok {
+				reqLogger = reqLogger.With("trace_id", sc.TraceID.String())
+			}
+			c.SetRequest(req.WithContext(logging.NewContext(req.Context(), reqLogger)))
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			log := reqLogger.Info
+			if status >= 500 {
+				log = reqLogger.Error
+			}
+			log("request",
+				"method", req.Method,
+				"path", redactedPath(c),
+				"route", c.Path(),
+				"status", status,
+				"bytes", c.Response().Size,
+				"duration", time.Since(start),
+				"remote_ip", c.RealIP(),
+				"user_agent", req.UserAgent(),
+			)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/metrics"
+	"github.com/labstack/echo"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("mailist_http_requests_total",
+		"HTTP requests by route name, method and status code.", "route", "method", "code")
+	httpLatency = metrics.NewHistogramVec("mailist_http_request_duration_seconds",
+		"HTTP requests latency by route name.", metrics.DefaultBuckets, "route", "method")
+)
+
+// Metrics counts the requests and observes their latency per echo route name.
+// Errors are handled here so the recorded status is the one sent to the client.
+func Metrics(e *echo.Echo) echo.MiddlewareFunc {
+	routeName := routeNamer(e)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			method := c.Request().Method
+			route := routeName(method, c.Path())
+			httpRequests.With(route, method, strconv.Itoa(c.Response().Status)).Inc()
+			httpLatency.With(route, method).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
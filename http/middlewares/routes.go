@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo"
+)
+
+// routeNamer returns a function resolving the name of the route matched by a request,
+// falling back to its path for unnamed routes and to "unmatched" when no route matched.
+// The routes are indexed on the first call, once they are all registered.
+func routeNamer(e *echo.Echo) func(method, path string) string {
+	var (
+		once   sync.Once
+		routes map[string]string
+	)
+	return func(method, path string) string {
+		once.Do(func() {
+			routes = map[string]string{}
+			for _, r := range e.Routes() {
+				routes[r.Method+" "+r.Path] = r.Name
+			}
+		})
+		if name := routes[method+" "+path]; name != "" {
+			return name
+		}
+		if path == "" {
+			return "unmatched"
+		}
+		return path
+	}
+}
+
+// secretParams are the route parameters kept out of the logs and traces: the subscription
+// tokens grant access to the preferences.
+var secretParams = map[string]bool{"token": true}
+
+// redactedPath returns the path of the request with the values of the secret parameters replaced.
+func redactedPath(c echo.Context) string {
+	path := c.Request().URL.Path
+	values := c.ParamValues()
+	for i, name := range c.ParamNames() {
+		if secretParams[name] && i < len(values) && values[i] != "" {
+			path = strings.Replace(path, values[i], "REDACTED", 1)
+		}
+	}
+	return path
+}
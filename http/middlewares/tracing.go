@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"github.com/klebervirgilio/go-echo-basics/tracing"
+	"github.com/labstack/echo"
+)
+
+// Tracing starts a server span per request, named after the echo route, continuing the
+// trace given by the W3C traceparent header of the request when there is one.
+func Tracing(tracer *tracing.Tracer, e *echo.Echo) echo.MiddlewareFunc {
+	routeName := routeNamer(e)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			route := routeName(req.Method, c.Path())
+			ctx, span := tracer.Start(tracing.Extract(req.Context(), req.Header), req.Method+" "+route, tracing.KindServer)
+			defer span.End()
+			span.SetAttribute("http.method", req.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", redactedPath(c))
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			status := c.Response().Status
+			span.SetAttribute("http.status_code", status)
+			if status >= 500 {
+				span.RecordError(err)
+			}
+			return nil
+		}
+	}
+}
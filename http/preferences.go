@@ -0,0 +1,323 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/exporter"
+	"github.com/klebervirgilio/go-echo-basics/segment"
+
+	"github.com/labstack/echo"
+)
+
+// pauseOptions are the delivery pauses offered by the preferences.html page.
+var pauseOptions = []struct{ Value, Label string }{
+	{"7d", "1 week"},
+	{"30d", "1 month"},
+	{"90d", "3 months"},
+}
+
+// findByToken returns the subscription owning the token given in the URL.
+func findByToken(c echo.Context, repo core.Repository) (core.Subscription, error) {
+	subscriptions, err := repo.FindAll(c.Request().Context(), map[string]interface{}{"token": c.Param("token")})
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	if len(subscriptions) == 0 {
+		return core.Subscription{}, core.Errorf(core.NotFound, "This preferences link is invalid")
+	}
+	return subscriptions[0], nil
+}
+
+// fieldValues returns the custom fields of a subscription as the subscribe form values.
+func fieldValues(defs []core.Field, fields map[string]interface{}) url.Values {
+	values := url.Values{}
+	for _, f := range defs {
+		switch v := fields[f.Name].(type) {
+		case nil:
+		case []string:
+			values[fieldInput(f.Name)] = v
+		case []interface{}:
+			for _, s := range v {
+				values.Add(fieldInput(f.Name), exporter.FieldValue(s))
+			}
+		default:
+			values.Set(fieldInput(f.Name), exporter.FieldValue(v))
+		}
+	}
+	return values
+}
+
+// preferencesSnapshot returns the subscription preferences recorded in the audit trail.
+func preferencesSnapshot(s core.Subscription) map[string]interface{} {
+	snapshot := map[string]interface{}{"name": s.Name}
+	if len(s.Fields) > 0 {
+		snapshot["fields"] = s.Fields
+	}
+	if !s.PausedUntil.IsZero() {
+		snapshot["pausedUntil"] = s.PausedUntil
+	}
+	return snapshot
+}
+
+// PreferencesHandler renders the preferences.html page of the subscription owning the token
+// given in the URL, where subscribers manage their subscriptions.
+func PreferencesHandler(repo core.Repository, lists core.ListRepository, consent core.ConsentText) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		subscription, err := findByToken(c, repo)
+		if err != nil {
+			return err
+		}
+		list, err := lists.FindList(ctx, subscription.List)
+		if err != nil {
+			return err
+		}
+		allLists, err := lists.FindLists(ctx)
+		if err != nil {
+			return err
+		}
+		all, err := repo.FindAll(ctx, map[string]interface{}{"email": subscription.Email})
+		if err != nil {
+			return err
+		}
+		subscribed := map[string]bool{}
+		for _, s := range all {
+			subscribed[s.List] = true
+		}
+
+		return c.Render(http.StatusOK, "preferences.html", ViewContext{
+			"page":         "preferences",
+			"subscription": subscription,
+			"paused":       subscription.Paused(time.Now()),
+			"list":         list,
+			"lists":        allLists,
+			"subscribed":   subscribed,
+			"values":       fieldValues(list.Fields, subscription.Fields),
+			"pauses":       pauseOptions,
+			"consent":      consent,
+			"success":      c.QueryParam("success"),
+			"error":        c.QueryParam("error"),
+		})
+	}
+}
+
+// SavePreferencesHandler updates the preferences submitted with the preferences.html form.
+// The name and the delivery pause apply to all the subscriptions of the email, the custom fields
+// to the subscription owning the token. Every change is written to the audit log, and the
+// subscriptions to new topics record the consent to the text shown by the page.
+func SavePreferencesHandler(repo core.Repository, lists core.ListRepository, audit core.AuditLog, consents core.ConsentRepository, consent core.ConsentText, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		token := c.Param("token")
+		subscription, err := findByToken(c, repo)
+		if err != nil {
+			return err
+		}
+		list, err := lists.FindList(ctx, subscription.List)
+		if err != nil {
+			return err
+		}
+		form, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+
+		name := form.Get("full-name")
+		if name == "" {
+			return redirectWithFlashMessage(c, e, "preferences", "error", "The name is required", token)
+		}
+		values := map[string][]string{}
+		for _, f := range list.Fields {
+			values[f.Name] = form[fieldInput(f.Name)]
+		}
+		fields, err := list.ParseFields(values)
+		if err != nil {
+			return redirectWithFlashMessage(c, e, "preferences", "error", err.Error(), token)
+		}
+
+		now := time.Now()
+		var pausedUntil *time.Time
+		switch pause := form.Get("pause"); pause {
+		case "":
+		case "resume":
+			pausedUntil = &time.Time{}
+		default:
+			d, err := segment.ParseDuration(pause)
+			if err != nil {
+				return redirectWithFlashMessage(c, e, "preferences", "error", "Invalid pause", token)
+			}
+			until := now.Add(d)
+			pausedUntil = &until
+		}
+
+		all, err := repo.FindAll(ctx, map[string]interface{}{"email": subscription.Email})
+		if err != nil {
+			return err
+		}
+		subscribed := map[string]core.Subscription{}
+		for _, s := range all {
+			subscribed[s.List] = s
+		}
+
+		// The topics are the other lists. The subscriber leaves the list of the token with the unsubscribe button.
+		topics := map[string]bool{list.Slug: true}
+		for _, slug := range form["topic"] {
+			topics[slug] = true
+		}
+		allLists, err := lists.FindLists(ctx)
+		if err != nil {
+			return err
+		}
+		for _, l := range allLists {
+			if _, ok := subscribed[l.Slug]; topics[l.Slug] && !ok && form.Get("consent-version") != consent.Version {
+				return redirectWithFlashMessage(c, e, "preferences", "error", "Our terms have changed, please review them", token)
+			}
+		}
+
+		entry := core.AuditEntry{Actor: core.ActorSubscriber, Target: subscription.Email, IP: c.RealIP()}
+		for _, s := range all {
+			before := preferencesSnapshot(s)
+			s.Name = name
+			if pausedUntil != nil {
+				s.PausedUntil = *pausedUntil
+			}
+			if s.List == list.Slug {
+				s.Fields = mergeFields(s.Fields, list.Fields, fields)
+			}
+			after := preferencesSnapshot(s)
+			if reflect.DeepEqual(before, after) {
+				continue
+			}
+			if err := repo.Upsert(ctx, s); err != nil {
+				return err
+			}
+			entry.Time, entry.Action, entry.List, entry.Before, entry.After = time.Now(), "preferences.update", s.List, before, after
+			if err := audit.RecordAudit(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		for _, l := range allLists {
+			s, ok := subscribed[l.Slug]
+			switch {
+			case topics[l.Slug] && !ok:
+				// The token proves the ownership of the email, no need to confirm it again.
+				s = core.Subscription{
+					List:                      l.Slug,
+					Email:                     subscription.Email,
+					Name:                      name,
+					Status:                    core.StatusConfirmed,
+					Token:                     core.NewToken(),
+					SubscribedAt:              now,
+					EmailVerificationResponse: subscription.EmailVerificationResponse,
+				}
+				if pausedUntil != nil {
+					s.PausedUntil = *pausedUntil
+				}
+				s.Consent = &core.Consent{
+					Email:       s.Email,
+					List:        l.Slug,
+					Form:        "preferences",
+					GivenAt:     now,
+					IP:          c.RealIP(),
+					UserAgent:   c.Request().UserAgent(),
+					TextVersion: consent.Version,
+					Text:        consent.Text,
+				}
+				if err := consents.RecordConsent(ctx, *s.Consent); err != nil {
+					return err
+				}
+				if err := repo.Upsert(ctx, s); err != nil {
+					return err
+				}
+				subscriptionsCreated.With().Inc()
+				entry.Time, entry.Action, entry.List, entry.Before, entry.After = time.Now(), "topic.subscribe", l.Slug, nil, preferencesSnapshot(s)
+			case !topics[l.Slug] && ok:
+				if err := repo.Remove(ctx, map[string]interface{}{"list": l.Slug, "email": subscription.Email}); err != nil {
+					return err
+				}
+				subscriptionsUnsubscribed.With().Inc()
+				entry.Time, entry.Action, entry.List, entry.Before, entry.After = time.Now(), "topic.unsubscribe", l.Slug, preferencesSnapshot(s), nil
+			default:
+				continue
+			}
+			if err := audit.RecordAudit(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		return redirectWithFlashMessage(c, e, "preferences", "success", "Your preferences have been saved", token)
+	}
+}
+
+// UnsubscribeHandler removes all the subscriptions of the email owning the token given in the URL.
+func UnsubscribeHandler(repo core.Repository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		subscription, err := findByToken(c, repo)
+		if err != nil {
+			return err
+		}
+		all, err := repo.FindAll(ctx, map[string]interface{}{"email": subscription.Email})
+		if err != nil {
+			return err
+		}
+		for _, s := range all {
+			if err := repo.Remove(ctx, map[string]interface{}{"list": s.List, "email": s.Email}); err != nil {
+				return err
+			}
+			subscriptionsUnsubscribed.With().Inc()
+			err := audit.RecordAudit(ctx, core.AuditEntry{
+				Time:   time.Now(),
+				Actor:  core.ActorSubscriber,
+				Action: "unsubscribe",
+				Target: s.Email,
+				List:   s.List,
+				IP:     c.RealIP(),
+				Before: preferencesSnapshot(s),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return redirectWithFlashMessage(c, e, "root", "success", "You have been unsubscribed from all our lists")
+	}
+}
+
+// ListUnsubscribeHandler removes the subscription owning the token given in the URL, leaving the
+// other lists of its email. It is the one-click unsubscribe link of RFC 8058, sent in the
+// List-Unsubscribe header of the list mails.
+func ListUnsubscribeHandler(repo core.Repository, lists core.ListRepository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		subscription, err := findByToken(c, repo)
+		if err != nil {
+			return err
+		}
+		list, err := lists.FindList(ctx, subscription.List)
+		if err != nil {
+			return err
+		}
+		if err := repo.Remove(ctx, map[string]interface{}{"list": subscription.List, "email": subscription.Email}); err != nil {
+			return err
+		}
+		subscriptionsUnsubscribed.With().Inc()
+		err = audit.RecordAudit(ctx, core.AuditEntry{
+			Time:   time.Now(),
+			Actor:  core.ActorSubscriber,
+			Action: "unsubscribe",
+			Target: subscription.Email,
+			List:   subscription.List,
+			IP:     c.RealIP(),
+			Before: preferencesSnapshot(subscription),
+		})
+		if err != nil {
+			return err
+		}
+		return redirectWithFlashMessage(c, e, "root", "success", "You have been unsubscribed from "+list.Name)
+	}
+}
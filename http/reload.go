@@ -0,0 +1,74 @@
+package http
+
+import (
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+)
+
+// liveSettings are the settings applied when the configuration file changes. The others
+// require a restart.
+//
+// The rate limits and the feature flags were meant to be reloaded too, but the app has neither
+// yet: their settings belong here once they are added.
+var liveSettings = map[string]bool{
+	"log.level":              true,
+	"mailChecker.url":        true,
+	"mailChecker.accessKey":  true,
+	"mailChecker.timeout":    true,
+	"validation.concurrency": true,
+}
+
+// reloader is implemented by the dependencies applying the new settings while they are used,
+// such as the mail checker.
+type reloader interface {
+	Reload(cfg *config.Config)
+}
+
+// settings returns the latest valid settings. Only liveSettings should be read from them.
+func (s *Server) settings() config.Settings {
+	return s.live.Load().(config.Settings)
+}
+
+// validationConcurrency returns the live `validation.concurrency` setting.
+func (s *Server) validationConcurrency() int {
+	return s.settings().Validation.Concurrency
+}
+
+// reload applies the live settings of a new version of the configuration file, and logs
+// the changed settings which require a restart. Invalid versions are logged and ignored.
+func (s *Server) reload(next *config.Config, err error) {
+	if err != nil {
+		s.Logger.Error("configuration change rejected", "files", s.Config.Files, "err", err)
+		return
+	}
+	for _, w := range next.Warnings {
+		s.Logger.Warn("configuration", "files", next.Files, "warning", w)
+	}
+
+	var applied []string
+	for _, key := range s.settings().Changes(next.Settings) {
+		if liveSettings[key] {
+			applied = append(applied, key)
+		}
+	}
+	// The restart is required for as long as the file differs from the settings in use.
+	var restart []string
+	for _, key := range s.Config.Changes(next.Settings) {
+		if !liveSettings[key] {
+			restart = append(restart, key)
+		}
+	}
+
+	if len(applied) > 0 {
+		level, _ := logging.ParseLevel(next.Log.Level)
+		s.Logger.SetLevel(level)
+		for _, r := range s.reloaders {
+			r.Reload(next)
+		}
+		s.live.Store(next.Settings)
+		s.Logger.Info("configuration reloaded", "files", next.Files, "applied", applied)
+	}
+	if len(restart) > 0 {
+		s.Logger.Warn("configuration changes require a restart", "files", next.Files, "settings", restart)
+	}
+}
@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/retention"
+
+	"github.com/labstack/echo"
+)
+
+// RetentionHandler renders the retention.html page: the retention policy and a dry run of the
+// sweeper, reporting what it would purge now.
+func RetentionHandler(sweeper retention.Sweeper) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := sweeper.Sweep(c.Request().Context(), time.Now(), true)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "retention.html", ViewContext{
+			"page":   "retention",
+			"policy": sweeper.Policy,
+			"report": report,
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/segment"
+
+	"github.com/labstack/echo"
+)
+
+// SegmentView is a saved segment with the number of subscriptions it currently matches.
+type SegmentView struct {
+	core.Segment
+	Count int
+	Error string
+}
+
+// SegmentsHandler renders the segments.html page, where the admins manage the saved segments.
+func SegmentsHandler(repo core.Repository, segments core.SegmentRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		all, err := segments.FindSegments(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		views := make([]SegmentView, 0, len(all))
+		for _, s := range all {
+			view := SegmentView{Segment: s}
+			query, err := segment.Compile(s.Expression, now)
+			if err == nil {
+				view.Count, err = repo.Count(ctx, query)
+			}
+			if err != nil {
+				if core.KindOf(err) != core.InvalidInput {
+					return err
+				}
+				view.Error = err.Error()
+			}
+			views = append(views, view)
+		}
+
+		return c.Render(http.StatusOK, "segments.html", ViewContext{
+			"page":     "segments",
+			"segments": views,
+			"success":  c.QueryParam("success"),
+			"error":    c.QueryParam("error"),
+		})
+	}
+}
+
+// SaveSegmentHandler creates or updates the segment submitted with the segments.html form.
+func SaveSegmentHandler(segments core.SegmentRepository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		s := core.Segment{
+			Slug:       c.FormValue("slug"),
+			Name:       c.FormValue("name"),
+			Expression: c.FormValue("expression"),
+		}
+		err := s.Validate()
+		if err == nil {
+			_, err = segment.Compile(s.Expression, time.Now())
+		}
+		if err != nil {
+			return redirectWithFlashMessage(c, e, "segments", "error", err.Error())
+		}
+		entry := core.AuditEntry{Action: "segment.save", Target: s.Slug, After: segmentSnapshot(s)}
+		previous, err := segments.FindSegment(c.Request().Context(), s.Slug)
+		switch {
+		case err == nil:
+			entry.Before = segmentSnapshot(previous)
+		case core.KindOf(err) != core.NotFound:
+			return err
+		}
+		if err := segments.UpsertSegment(c.Request().Context(), s); err != nil {
+			return err
+		}
+		if err := recordAdmin(c, audit, entry); err != nil {
+			return err
+		}
+		return redirectWithFlashMessage(c, e, "segments", "success", "The segment "+s.Name+" has been saved")
+	}
+}
+
+// deleteSegmentHandler removes the segment given by the `slug` URL parameter.
+func deleteSegmentHandler(segments core.SegmentRepository, audit core.AuditLog) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		s, err := segments.FindSegment(ctx, c.Param("slug"))
+		if err != nil {
+			return err
+		}
+		if err := segments.RemoveSegment(ctx, s.Slug); err != nil {
+			return err
+		}
+		if err := recordAdmin(c, audit, core.AuditEntry{Action: "segment.delete", Target: s.Slug, Before: segmentSnapshot(s)}); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// segmentSnapshot returns the state of a segment recorded in the audit trail.
+func segmentSnapshot(s core.Segment) map[string]interface{} {
+	return map[string]interface{}{"name": s.Name, "expression": s.Expression}
+}
+
+// previewSegmentHandler counts the subscriptions matching the `expression` query parameter,
+// for the live preview of the segments.html form.
+func previewSegmentHandler(repo core.Repository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		query, err := segment.Compile(c.QueryParam("expression"), time.Now())
+		if err != nil {
+			return err
+		}
+		n, err := repo.Count(c.Request().Context(), query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, map[string]int{"count": n})
+	}
+}
@@ -1,63 +1,398 @@
 package http
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/klebervirgilio/go-echo-basics/auditlog"
 	"github.com/klebervirgilio/go-echo-basics/config"
-	"github.com/klebervirgilio/go-echo-basics/mailchecker"
-	mongorepository "github.com/klebervirgilio/go-echo-basics/storage"
 	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
 	"github.com/klebervirgilio/go-echo-basics/http/middlewares"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/mailchecker"
+	"github.com/klebervirgilio/go-echo-basics/mailer"
+	"github.com/klebervirgilio/go-echo-basics/metrics"
+	"github.com/klebervirgilio/go-echo-basics/retention"
+	mongorepository "github.com/klebervirgilio/go-echo-basics/storage"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
-func NewServer() *Server {
-	cfg := config.New()
-	repository := mongorepository.NewMongoRepo(cfg)
-	mailChecker := apilayer.New(cfg)
+// Repository is the storage of the server, implemented by the Mongo repository.
+type Repository interface {
+	core.Repository
+	core.ListRepository
+	core.SegmentRepository
+	core.TombstoneRepository
+	core.ConsentRepository
+	core.UserRepository
+	core.VerificationHistory
+	retention.VerificationStore
+	gdpr.AuditStore
+}
+
+// Open builds the server of the app from the CONF_FILE configuration: it connects to Mongo,
+// migrates it, checks the emails with APILayer and sends the e-mails with the `mail` settings.
+func Open() (*Server, error) {
+	cfg, err := config.New()
+	if err != nil {
+		return nil, err
+	}
+	repository, err := mongorepository.NewMongoRepo(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := SetupLists(context.Background(), repository, cfg); err != nil {
+		repository.Close()
+		return nil, err
+	}
+	m, err := mailer.New(cfg)
+	if err != nil {
+		repository.Close()
+		return nil, err
+	}
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		repository.Close()
+		return nil, err
+	}
+	logger := logging.New(os.Stdout, cfg.Log.Format, level)
+	s, err := NewServer(cfg, logger, repository, apilayer.New(cfg), m)
+	if err != nil {
+		repository.Close()
+		return nil, err
+	}
+	logging.Default = s.Logger
+	tracing.Default = s.Tracer
+	return s, nil
+}
+
+// NewServer builds a server on the given dependencies, logging with logger. The mail checker
+// results are recorded in the repository, and the mail checker follows the configuration changes
+// when it is able to.
+func NewServer(cfg *config.Config, logger *logging.Logger, repository Repository, checker core.MailChecker, mailer core.Mailer) (*Server, error) {
+	for _, w := range cfg.Warnings {
+		logger.Warn("configuration", "files", cfg.Files, "warning", w)
+	}
+
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Server{
-		repository,
-		cfg,
-		mailChecker,
+	mailChecker := core.RecordVerifications(checker, repository)
+	subscriptions := metrics.InstrumentRepository(repository)
+	audit, err := auditlog.New(cfg, repository)
+	if err != nil {
+		return nil, err
+	}
+	hasher := core.NewEmailHasher(cfg.GDPR.HashKey)
+	s := &Server{
+		SubscriptionRepository: subscriptions,
+		ListRepository:         repository,
+		SegmentRepository:      repository,
+		Audit:                  audit,
+		TombstoneRepository:    repository,
+		ConsentRepository:      repository,
+		UserRepository:         repository,
+		GDPR: gdpr.Service{
+			Subscriptions: subscriptions,
+			Verifications: repository,
+			Audit:         audit,
+			Tombstones:    repository,
+			Consents:      repository,
+			Hasher:        hasher,
+		},
+		Retention: retention.Sweeper{
+			Subscriptions: subscriptions,
+			Verifications: repository,
+			Consents:      repository,
+			Audit:         audit,
+			Policy:        retention.NewPolicy(cfg),
+			Hasher:        hasher,
+		},
+		Config:      cfg,
+		MailChecker: metrics.InstrumentMailChecker(mailChecker),
+		Mailer:      mailer,
+		Logger:      logger,
+		Tracer:      tracer,
+		workers:     newWorkerGroup(),
+		imports:     newImportReports(),
+		jobs:        newJobs(),
 	}
+	if r, ok := checker.(reloader); ok {
+		s.reloaders = append(s.reloaders, r)
+	}
+	s.live.Store(cfg.Settings)
+	return s, nil
 }
 
 type Server struct {
 	SubscriptionRepository core.Repository
+	ListRepository         core.ListRepository
+	SegmentRepository      core.SegmentRepository
+	Audit                  gdpr.AuditStore
+	TombstoneRepository    core.TombstoneRepository
+	ConsentRepository      core.ConsentRepository
+	UserRepository         core.UserRepository
+	GDPR                   gdpr.Service
+	Retention              retention.Sweeper
 	Config                 *config.Config
 	MailChecker            core.MailChecker
+	Mailer                 core.Mailer
+	Logger                 *logging.Logger
+	Tracer                 *tracing.Tracer
+
+	// echo serves the routes once Serve is called. It is guarded by mu, Shutdown running
+	// concurrently with Serve.
+	mu        sync.Mutex
+	echo      *echo.Echo
+	workers   *workerGroup
+	imports   *importReports
+	jobs      *jobs
+	reloaders []reloader
+	// live are the latest valid settings, of which only liveSettings are applied until a restart.
+	live         atomic.Value
+	shuttingDown int32
+	closeOnce    sync.Once
+	closeErr     error
 }
 
-func (s Server) Serve() {
+// Routes returns the echo instance serving the app: its middlewares, assets and named routes.
+func (s *Server) Routes() (*echo.Echo, error) {
 	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.StdLogger = log.New(s.Logger.Writer(logging.ErrorLevel), "", 0)
 	e.HTTPErrorHandler = customHTTPErrorHandler
-	e.Renderer = newTemplate(e)
+	renderer, err := newTemplate(e)
+	if err != nil {
+		return nil, err
+	}
+	e.Renderer = renderer
 
 	// Configure middlewares
-	e.Use(middleware.Logger())
+	e.Use(middleware.RequestID())
+	e.Use(middlewares.Tracing(s.Tracer, e))
+	e.Use(middlewares.RequestLogger(s.Logger))
+	e.Use(middlewares.Metrics(e))
 	// e.Use(middleware.Recover())
 
 	// Configure assets endpoint
 	e.Static("/assets", "assets")
-	e.GET("/", HomeHandler).Name = "root"
-	e.POST("/subscribe", SubscribeHandler(s.SubscriptionRepository, e)).Name = "subscribe"
+	requireAuth := middlewares.RequireAuth(s.UserRepository)
+	metricsAuth := []echo.MiddlewareFunc{requireAuth}
+	if s.Config.Metrics.Public {
+		metricsAuth = nil
+	}
+	e.GET("/metrics", echo.WrapHandler(metrics.Default.Handler()), metricsAuth...).Name = "metrics"
+	e.GET("/healthz", LivenessHandler).Name = "healthz"
+	e.GET("/readyz", readinessHandler(s, s.readinessChecks(), s.Config.Health.Timeout)).Name = "readyz"
+	defaultList := s.Config.Lists.Default.Slug
+	consent := core.ConsentText{Version: s.Config.Consent.Version, Text: s.Config.Consent.Text}
+	e.GET("/", HomeHandler(s.ListRepository, consent, defaultList)).Name = "root"
+	e.GET("/l/:slug", HomeHandler(s.ListRepository, consent, defaultList)).Name = "list-home"
+	e.POST("/subscribe", SubscribeHandler(s.SubscriptionRepository, s.ListRepository, s.ConsentRepository, consent, s.Mailer, s.Config.Mail.BaseURL, e, defaultList)).Name = "subscribe"
+	e.GET("/confirm/:token", ConfirmHandler(s.SubscriptionRepository, s.ListRepository, s.ConsentRepository, s.Mailer, s.Config.Mail.BaseURL, e)).Name = "confirm-subscription"
+	e.GET("/preferences/:token", PreferencesHandler(s.SubscriptionRepository, s.ListRepository, consent)).Name = "preferences"
+	e.POST("/preferences/:token", SavePreferencesHandler(s.SubscriptionRepository, s.ListRepository, s.Audit, s.ConsentRepository, consent, e)).Name = "save-preferences"
+	e.POST("/preferences/:token/unsubscribe", UnsubscribeHandler(s.SubscriptionRepository, s.Audit, e)).Name = "unsubscribe"
+	e.POST("/unsubscribe/:token", ListUnsubscribeHandler(s.SubscriptionRepository, s.ListRepository, s.Audit, e)).Name = "list-unsubscribe"
 
 	// Echo Groups/Nested Routes
-	g := e.Group("/subscriptions", middlewares.RequireAuth)
-	g.GET("/", FullListHandler(s.SubscriptionRepository), middlewares.RequireAuth).Name = "subscriptions"
-	g.GET("/validate", checkEmailHandler(s.SubscriptionRepository, e, s.MailChecker, s.Config)).Name = "validate-all-subscriptions"
+	g := e.Group("/subscriptions", requireAuth)
+	g.GET("/", FullListHandler(s.SubscriptionRepository, s.ListRepository)).Name = "subscriptions"
+	g.GET("/validate", checkEmailHandler(s.SubscriptionRepository, s.Audit, e, s.MailChecker, s.workers, s.validationConcurrency)).Name = "validate-all-subscriptions"
+	g.GET("/export", exportHandler(s.SubscriptionRepository, s.ListRepository)).Name = "export-subscriptions"
+	g.GET("/import", ImportFormHandler(s.ListRepository)).Name = "import-subscriptions"
+	g.POST("/import", importHandler(s.SubscriptionRepository, s.ListRepository, s.TombstoneRepository, s.ConsentRepository, s.Audit, s.MailChecker, s.workers, s.imports)).Name = "import-subscriptions-upload"
+	g.GET("/import/:id", importErrorsHandler(s.imports)).Name = "import-errors"
+	g.POST("/bulk", bulkHandler(s.SubscriptionRepository, s.ListRepository, s.Audit, s.MailChecker, s.workers, s.jobs, e)).Name = "bulk-subscriptions"
+	g.GET("/jobs/:id", jobHandler(s.jobs)).Name = "bulk-job"
+	g.GET("/jobs/:id/download", jobDownloadHandler(s.jobs)).Name = "bulk-job-download"
+	g.GET("/trash", TrashHandler(s.SubscriptionRepository, s.Retention.Policy.Trash)).Name = "trash"
+	g.POST("/trash/restore", restoreHandler(s.SubscriptionRepository, s.Audit, e)).Name = "restore-subscriptions"
+	g.POST("/trash/purge", purgeHandler(s.SubscriptionRepository, s.Audit, e)).Name = "purge-subscriptions"
 
 	// Nesting even more...
 	g = g.Group("/:email")
-	g.GET("/validate", checkEmailHandler(s.SubscriptionRepository, e, s.MailChecker, s.Config)).Name = "validate-email"
-	g.DELETE("/", func(c echo.Context) error {
-		if err := s.SubscriptionRepository.Remove(map[string]interface{}{"email": c.Param("email")}); err != nil {
-			return c.String(http.StatusNotFound, err.Error())
-		}
+	g.GET("/validate", checkEmailHandler(s.SubscriptionRepository, s.Audit, e, s.MailChecker, s.workers, s.validationConcurrency)).Name = "validate-email"
+	g.GET("/consents", ConsentsHandler(s.ConsentRepository)).Name = "subscription-consents"
+	g.DELETE("/", deleteEmailHandler(s.SubscriptionRepository, s.Audit)).Name = "delete-email"
+
+	lists := e.Group("/lists", requireAuth)
+	lists.GET("/", ListsHandler(s.ListRepository)).Name = "lists"
+	lists.POST("/", SaveListHandler(s.ListRepository, s.Audit, e)).Name = "save-list"
+
+	privacy := e.Group("/gdpr", requireAuth)
+	privacy.GET("/", GDPRHandler).Name = "gdpr"
+	privacy.GET("/export", gdprExportHandler(s.GDPR)).Name = "gdpr-export"
+	privacy.POST("/erase", gdprEraseHandler(s.GDPR, e)).Name = "gdpr-erase"
+
+	segments := e.Group("/segments", requireAuth)
+	segments.GET("/", SegmentsHandler(s.SubscriptionRepository, s.SegmentRepository)).Name = "segments"
+	segments.POST("/", SaveSegmentHandler(s.SegmentRepository, s.Audit, e)).Name = "save-segment"
+	segments.GET("/preview", previewSegmentHandler(s.SubscriptionRepository)).Name = "preview-segment"
+	segments.DELETE("/:slug", deleteSegmentHandler(s.SegmentRepository, s.Audit)).Name = "delete-segment"
+
+	admin := e.Group("/retention", requireAuth)
+	admin.GET("/", RetentionHandler(s.Retention)).Name = "retention"
+
+	audit := e.Group("/audit", requireAuth)
+	audit.GET("/", AuditHandler(s.Audit)).Name = "audit"
+
+	return e, nil
+}
+
+// Serve registers the routes and blocks serving requests until the process receives
+// SIGINT or SIGTERM, or until Close is called. On a signal the server is gracefully closed.
+func (s *Server) Serve() error {
+	e, err := s.Routes()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		s.mu.Unlock()
 		return nil
-	}).Name = "delete-email"
+	}
+	s.echo = e
+	s.mu.Unlock()
+
+	if interval := s.Config.Retention.Interval; interval > 0 {
+		dryRun := s.Config.Retention.DryRun
+		s.workers.Loop(s.Logger, func(ctx context.Context) {
+			s.Retention.Run(ctx, interval, dryRun)
+		})
+	}
+
+	s.workers.Loop(s.Logger, func(ctx context.Context) {
+		if err := s.Config.Watch(ctx, s.reload); err != nil {
+			s.Logger.Error("failed to watch the configuration", "files", s.Config.Files, "err", err)
+		}
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- e.Start(s.Config.BindAddr)
+	}()
+	s.Logger.Info("http server started", "addr", s.Config.BindAddr)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	select {
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		s.Close()
+		return err
+	case sig := <-sigCh:
+		s.Logger.Info("shutting down", "signal", sig)
+		return s.Close()
+	}
+}
+
+// Close gracefully stops the server: it stops accepting connections, waits for the in-flight
+// requests and background workers up to the `shutdownTimeout` setting, then releases the
+// repository, mail checker and mailer resources. It is safe to call Close more than once.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+		defer cancel()
+		s.closeErr = s.Shutdown(ctx)
+	})
+	return s.closeErr
+}
+
+// Shutdown is like Close but drains the requests and workers until ctx is done.
+// The server reports not ready for the `shutdownDelay` setting before it stops accepting
+// connections, giving the load balancer time to take it out of rotation.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	select {
+	case <-time.After(s.Config.ShutdownDelay):
+	case <-ctx.Done():
+	}
+
+	var errs []error
+	s.mu.Lock()
+	e := s.echo
+	s.mu.Unlock()
+	if e != nil {
+		if err := e.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.workers.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.Tracer.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	for _, dep := range []interface{}{s.MailChecker, s.Mailer, s.SubscriptionRepository, s.Audit} {
+		if closer, ok := dep.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// SetupLists migrates the subscriptions created before the lists existed to the default list,
+// which is created from the `lists.default` settings when missing.
+func SetupLists(ctx context.Context, repository mongorepository.MongoRepo, cfg *config.Config) error {
+	slug := cfg.Lists.Default.Slug
+	if err := repository.Migrate(ctx, slug); err != nil {
+		return err
+	}
+	_, err := repository.FindList(ctx, slug)
+	if core.KindOf(err) != core.NotFound {
+		return err
+	}
+	return repository.UpsertList(ctx, core.List{
+		Slug: slug,
+		Name: cfg.Lists.Default.Name,
+	})
+}
+
+// newTracer builds the tracer exporting to the `tracing.exporter` setting:
+// "otlp" posts to the collector at `tracing.endpoint`, "stdout" prints the spans
+// and "none" only propagates the trace context.
+func newTracer(cfg *config.Config, logger *logging.Logger) (*tracing.Tracer, error) {
+	switch exporter := cfg.Tracing.Exporter; exporter {
+	case "none":
+		return tracing.NewTracer(nil), nil
+	case "stdout":
+		return tracing.NewTracer(tracing.NewWriterExporter(os.Stdout)), nil
+	case "otlp":
+		otlp := tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.Timeout)
+		otlp.OnError = func(err error) {
+			logger.Warn("failed to export spans", "err", err)
+		}
+		return tracing.NewTracer(otlp), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+}
 
-	e.Logger.Fatal(e.Start(s.Config.GetString("bindAddr")))
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
 }
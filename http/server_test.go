@@ -0,0 +1,963 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
+	"github.com/klebervirgilio/go-echo-basics/importer"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+
+	"github.com/labstack/echo"
+)
+
+func TestMain(m *testing.M) {
+	// The templates and the assets are resolved from the root of the repository, as when the app runs.
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server on a repository holding two lists, three subscriptions, the one
+// of carol@example.com being in the trash, a segment, a consent and an audit entry.
+func newTestServer(t *testing.T) (*Server, *fakeRepository, *echo.Echo) {
+	return newTestServerWith(t, testConfig(), discardLogger())
+}
+
+// testConfig returns the default configuration, with the settings required by the tests.
+func testConfig() *config.Config {
+	cfg := config.Defaults()
+	cfg.GDPR.HashKey = "0123456789abcdef0123456789abcdef"
+	return cfg
+}
+
+func discardLogger() *logging.Logger {
+	return logging.New(ioutil.Discard, logging.FormatJSON, logging.ErrorLevel)
+}
+
+// newTestServerWith is newTestServer with the given configuration and logger.
+func newTestServerWith(t *testing.T, cfg *config.Config, logger *logging.Logger) (*Server, *fakeRepository, *echo.Echo) {
+	now := time.Now()
+	repo := &fakeRepository{
+		lists: []core.List{
+			{Slug: "default", Name: "Mailist"},
+			{Slug: "news", Name: "News", DoubleOptIn: true, Fields: []core.Field{
+				{Name: "company", Label: "Company", Type: core.FieldText, Required: true},
+			}},
+		},
+		subscriptions: []core.Subscription{
+			{List: "default", Email: "ada@example.com", Name: "Ada Lovelace", Status: core.StatusConfirmed, Token: "ada-token", SubscribedAt: now},
+			{List: "news", Email: "bob@example.com", Name: "Bob", Status: core.StatusPending, Token: "bob-token", SubscribedAt: now, Fields: map[string]interface{}{"company": "Acme"}},
+			{List: "default", Email: "carol@example.com", Name: "Carol", Status: core.StatusConfirmed, Token: "carol-token", SubscribedAt: now, DeletedAt: now.Add(-40 * 24 * time.Hour)},
+		},
+		segments: []core.Segment{{Slug: "beta", Name: "Beta testers", Expression: `tag = "beta"`}},
+		consents: []core.Consent{{Email: "ada@example.com", List: "default", Form: "subscribe", GivenAt: now, TextVersion: "1", Text: "I agree to the seeded terms."}},
+		audit:    []core.AuditEntry{{Time: now, Actor: "golang", Action: "list.save", Target: "news"}},
+		hasher:   core.NewEmailHasher(cfg.GDPR.HashKey),
+	}
+
+	s, err := NewServer(cfg, logger, repo, fakeMailChecker{}, &fakeMailer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, repo, e
+}
+
+// routeTest is a request to a named route, and the expected response. Redirects are checked
+// against their Location header, the other responses against their body.
+type routeTest struct {
+	route  string
+	method string
+	path   string
+	// id is formatted into path, for the routes of the jobs and import reports.
+	id     func(s *Server) string
+	form   url.Values
+	file   string
+	public bool
+	accept string
+	// headers are set on the request, along with accept.
+	headers map[string]string
+
+	code   int
+	body   string
+	header string
+	// contentType prefixes the Content-Type of the response.
+	contentType string
+	// check inspects the repository once the server, and its background jobs, are stopped.
+	check func(t *testing.T, repo *fakeRepository)
+	// mails inspects the messages sent.
+	mails func(t *testing.T, sent []core.Message)
+	// response inspects the body of the response.
+	response func(t *testing.T, body []byte)
+}
+
+func (rt routeTest) do(t *testing.T) {
+	s, repo, e := newTestServer(t)
+
+	path := rt.path
+	if rt.id != nil {
+		path = strings.Replace(path, ":id", rt.id(s), 1)
+	}
+	var body io.Reader
+	contentType := ""
+	switch {
+	case rt.file != "":
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		for k, vs := range rt.form {
+			for _, v := range vs {
+				w.WriteField(k, v)
+			}
+		}
+		f, _ := w.CreateFormFile("file", "subscriptions.csv")
+		f.Write([]byte(rt.file))
+		w.Close()
+		body, contentType = &buf, w.FormDataContentType()
+	case rt.form != nil:
+		body, contentType = strings.NewReader(rt.form.Encode()), echo.MIMEApplicationForm
+	}
+
+	req := httptest.NewRequest(rt.method, path, body)
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	if rt.accept != "" {
+		req.Header.Set(echo.HeaderAccept, rt.accept)
+	}
+	for k, v := range rt.headers {
+		req.Header.Set(k, v)
+	}
+	if !rt.public {
+		req.SetBasicAuth("golang", "echo!")
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != rt.code {
+		t.Fatalf("%s %s: got status %d, want %d\n%s", rt.method, path, rec.Code, rt.code, rec.Body)
+	}
+	got := rec.Body.String()
+	if rec.Code == http.StatusFound {
+		got, _ = url.QueryUnescape(rec.Header().Get(echo.HeaderLocation))
+	}
+	if !strings.Contains(got, rt.body) {
+		t.Errorf("%s %s: got %q, want it to contain %q", rt.method, path, got, rt.body)
+	}
+	if rt.header != "" && !strings.Contains(rec.Header().Get(echo.HeaderContentDisposition), rt.header) {
+		t.Errorf("%s %s: got Content-Disposition %q, want it to contain %q", rt.method, path, rec.Header().Get(echo.HeaderContentDisposition), rt.header)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, rt.contentType) {
+		t.Errorf("%s %s: got Content-Type %q, want %q", rt.method, path, ct, rt.contentType)
+	}
+	if rt.response != nil {
+		rt.response(t, rec.Body.Bytes())
+	}
+	if rt.check != nil {
+		rt.check(t, repo)
+	}
+	sent := s.Mailer.(*fakeMailer).messages()
+	if rt.mails != nil {
+		rt.mails(t, sent)
+	} else if len(sent) > 0 {
+		t.Errorf("%s %s: sent %d unexpected e-mails", rt.method, path, len(sent))
+	}
+}
+
+// sentTo checks a single message has been sent to email, with a body containing each of want.
+// The subject is checked along with the body.
+func sentTo(email string, want ...string) func(t *testing.T, sent []core.Message) {
+	return func(t *testing.T, sent []core.Message) {
+		if len(sent) != 1 || sent[0].To != email {
+			t.Fatalf("got %+v, want a message to %s", sent, email)
+		}
+		for _, w := range want {
+			if !strings.Contains(sent[0].Subject+"\n"+sent[0].Body, w) {
+				t.Errorf("got body %q, want it to contain %q", sent[0].Body, w)
+			}
+		}
+	}
+}
+
+// find returns the subscription of email to list, including the ones in the trash.
+func (f *fakeRepository) find(list, email string) (core.Subscription, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.subscriptions {
+		if s.List == list && s.Email == email {
+			return s, true
+		}
+	}
+	return core.Subscription{}, false
+}
+
+// recorded checks the audit trail ends with action.
+func recorded(action string) func(t *testing.T, repo *fakeRepository) {
+	return func(t *testing.T, repo *fakeRepository) {
+		actions := repo.actions()
+		if len(actions) == 0 || actions[len(actions)-1] != action {
+			t.Errorf("got audit actions %v, want the last one to be %s", actions, action)
+		}
+	}
+}
+
+var routeTests = []routeTest{
+	{route: "metrics", method: "GET", path: "/metrics", code: 200, body: "# TYPE mailist_http_requests_total counter"},
+	{route: "metrics", method: "GET", path: "/metrics", public: true, code: 401, body: "Unauthorized"},
+	{route: "healthz", method: "GET", path: "/healthz", public: true, code: 200, body: `"status":"ok"`},
+	{route: "readyz", method: "GET", path: "/readyz", public: true, code: 200, body: `"mongo":{"status":"up"`},
+
+	{route: "root", method: "GET", path: "/", public: true, code: 200, body: "I agree to receive the e-mails of this list"},
+	{route: "list-home", method: "GET", path: "/l/news", public: true, code: 200, body: "Company"},
+	{route: "list-home", method: "GET", path: "/l/nope", public: true, code: 404, body: "list not found"},
+	// The errors are rendered as problem details or as a page, depending on the client.
+	{route: "list-home", method: "GET", path: "/l/nope", public: true, code: 404, body: "Error ID: ", contentType: echo.MIMETextHTML},
+	{route: "list-home", method: "GET", path: "/l/nope", public: true, accept: "text/html,application/xhtml+xml,application/json;q=0.9", code: 404, body: "Error ID: ", contentType: echo.MIMETextHTML},
+	{route: "list-home", method: "GET", path: "/l/nope", public: true, accept: "application/json, text/html", code: 404, body: `"detail":"list not found"`, contentType: mimeProblemJSON},
+	{route: "list-home", method: "GET", path: "/l/nope", public: true, accept: mimeProblemJSON, code: 404, body: `"status":404`, contentType: mimeProblemJSON},
+	{
+		route: "list-home", method: "GET", path: "/l/nope", public: true, accept: echo.MIMETextHTML,
+		headers: map[string]string{echo.HeaderXRequestedWith: "XMLHttpRequest"},
+		code:    404, body: `"title":"Not Found"`, contentType: mimeProblemJSON,
+	},
+	{route: "unmatched", method: "GET", path: "/api/nope", public: true, accept: echo.MIMETextHTML, code: 404, body: `"instance":"/api/nope"`, contentType: mimeProblemJSON},
+	{
+		route: "unmatched", method: "HEAD", path: "/api/nope", public: true, accept: mimeProblemJSON, code: 404,
+		response: func(t *testing.T, body []byte) {
+			if len(body) != 0 {
+				t.Errorf("got body %q, want none", body)
+			}
+		},
+	},
+	{
+		// The GET routes don't answer HEAD requests, which get an empty error.
+		route: "list-home", method: "HEAD", path: "/l/news", public: true, accept: mimeProblemJSON, code: 405,
+		response: func(t *testing.T, body []byte) {
+			if len(body) != 0 {
+				t.Errorf("got body %q, want none", body)
+			}
+		},
+	},
+	{
+		route: "subscribe", method: "POST", path: "/subscribe", public: true,
+		form: url.Values{"email": {"dan@example.com"}, "full-name": {"Dan"}, "consent": {"on"}, "consent-version": {"1"}},
+		code: 302, body: "/?success=You have been successfully subscribed",
+		check: func(t *testing.T, repo *fakeRepository) {
+			s, ok := repo.find("default", "dan@example.com")
+			if !ok || s.Status != core.StatusConfirmed || s.Consent == nil {
+				t.Errorf("got subscription %+v, want it confirmed with its consent", s)
+			}
+		},
+		mails: sentTo("dan@example.com", "Welcome to Mailist", "http://localhost:4000/preferences/"),
+	},
+	{
+		route: "subscribe", method: "POST", path: "/subscribe", public: true,
+		form: url.Values{"email": {"dan@example.com"}, "full-name": {"Dan"}, "list": {"news"}, "field.company": {"Acme"}, "consent": {"on"}, "consent-version": {"1"}},
+		code: 302, body: "/l/news?success=Almost there!",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("news", "dan@example.com"); s.Status != core.StatusPending || s.Fields["company"] != "Acme" {
+				t.Errorf("got subscription %+v, want it pending with its company", s)
+			}
+		},
+		mails: sentTo("dan@example.com", "Hello Dan", "to News", "http://localhost:4000/confirm/"),
+	},
+	{
+		// Subscribing again sends the confirmation link again, leaving the subscription as is.
+		route: "subscribe", method: "POST", path: "/subscribe", public: true,
+		form: url.Values{"email": {"Bob@Example.com"}, "full-name": {"Mallory"}, "list": {"news"}, "field.company": {"Evil Corp"}, "consent": {"on"}, "consent-version": {"1"}},
+		code: 302, body: "/l/news?success=Almost there!",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("news", "bob@example.com"); s.Name != "Bob" || s.Fields["company"] != "Acme" || s.Consent != nil {
+				t.Errorf("got subscription %+v, want it unchanged", s)
+			}
+			if len(repo.consents) != 1 {
+				t.Errorf("recorded consents %+v", repo.consents)
+			}
+		},
+		mails: sentTo("bob@example.com", "Hello Bob", "http://localhost:4000/confirm/bob-token"),
+	},
+	{
+		// The confirmed subscribers get the welcome message again.
+		route: "subscribe", method: "POST", path: "/subscribe", public: true,
+		form: url.Values{"email": {"ada@example.com"}, "full-name": {"Mallory"}, "consent": {"on"}, "consent-version": {"1"}},
+		code: 302, body: "/?success=You have been successfully subscribed",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("default", "ada@example.com"); s.Name != "Ada Lovelace" {
+				t.Errorf("got subscription %+v, want it unchanged", s)
+			}
+		},
+		mails: sentTo("ada@example.com", "Welcome to Mailist", "http://localhost:4000/preferences/ada-token"),
+	},
+	{
+		route: "subscribe", method: "POST", path: "/subscribe", public: true,
+		form: url.Values{"email": {"dan@example.com"}, "full-name": {"Dan"}, "consent-version": {"1"}},
+		code: 422, body: "Please, agree to the terms to subscribe",
+	},
+	{
+		route: "confirm-subscription", method: "GET", path: "/confirm/bob-token", public: true,
+		code: 302, body: "/l/news?success=Your subscription is confirmed",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("news", "bob@example.com"); s.Status != core.StatusConfirmed {
+				t.Errorf("got status %s, want %s", s.Status, core.StatusConfirmed)
+			}
+		},
+		mails: sentTo("bob@example.com", "confirmed", "http://localhost:4000/preferences/bob-token"),
+	},
+	{route: "confirm-subscription", method: "GET", path: "/confirm/nope", public: true, code: 404, body: "This confirmation link is invalid"},
+	{route: "preferences", method: "GET", path: "/preferences/ada-token", public: true, code: 200, body: "Ada Lovelace"},
+	{
+		route: "save-preferences", method: "POST", path: "/preferences/ada-token", public: true,
+		form: url.Values{"full-name": {"Ada King"}},
+		code: 302, body: "/preferences/ada-token?success=Your preferences have been saved",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("default", "ada@example.com"); s.Name != "Ada King" {
+				t.Errorf("got name %q, want %q", s.Name, "Ada King")
+			}
+			recorded("preferences.update")(t, repo)
+		},
+	},
+	{
+		route: "list-unsubscribe", method: "POST", path: "/unsubscribe/bob-token", public: true,
+		code: 302, body: "/?success=You have been unsubscribed from News",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("news", "bob@example.com"); ok {
+				t.Error("the subscription has not been removed")
+			}
+			recorded("unsubscribe")(t, repo)
+		},
+	},
+	{route: "list-unsubscribe", method: "POST", path: "/unsubscribe/nope", public: true, code: 404, body: "This preferences link is invalid"},
+	{
+		route: "unsubscribe", method: "POST", path: "/preferences/ada-token/unsubscribe", public: true,
+		code: 302, body: "/?success=You have been unsubscribed from all our lists",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("default", "ada@example.com"); ok {
+				t.Error("the subscription has not been removed")
+			}
+			recorded("unsubscribe")(t, repo)
+		},
+	},
+
+	{route: "subscriptions", method: "GET", path: "/subscriptions/", code: 200, body: "ada@example.com"},
+	{route: "subscriptions", method: "GET", path: "/subscriptions/", public: true, code: 401, body: "Unauthorized"},
+	{
+		route: "validate-all-subscriptions", method: "GET", path: "/subscriptions/validate",
+		code: 302, body: "/subscriptions/?success=All emails have been successfully checked",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("default", "ada@example.com"); !s.Valid {
+				t.Error("the subscription has not been validated")
+			}
+			recorded("subscriptions.validate-all")(t, repo)
+		},
+	},
+	{route: "export-subscriptions", method: "GET", path: "/subscriptions/export?format=csv&list=news", code: 200, body: "bob@example.com", header: ".csv"},
+	{route: "export-subscriptions", method: "GET", path: "/subscriptions/export?format=pdf", code: 422, body: "pdf"},
+	{route: "import-subscriptions", method: "GET", path: "/subscriptions/import?list=news", code: 200, body: "News"},
+	{
+		route: "import-subscriptions-upload", method: "POST", path: "/subscriptions/import",
+		form: url.Values{"list": {"default"}}, file: "email,name\neve@example.com,Eve\nnot-an-email,Nope\n",
+		code: 200, body: "Rejected: 1",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("default", "eve@example.com"); !ok {
+				t.Error("the subscription has not been imported")
+			}
+			recorded("subscriptions.import")(t, repo)
+		},
+	},
+	{
+		route: "import-subscriptions-upload", method: "POST", path: "/subscriptions/import",
+		form: url.Values{"list": {"news"}}, file: "email\neve@example.com\n",
+		code: 422, body: "requires double opt-in",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("news", "eve@example.com"); ok {
+				t.Error("the subscription has been imported without consent")
+			}
+		},
+	},
+	{
+		route: "import-subscriptions-upload", method: "POST", path: "/subscriptions/import",
+		form: url.Values{"list": {"news"}, "consent-source": {"Old signup form"}}, file: "email,company\neve@example.com,Acme\nnot-an-email,Acme\n",
+		code: 200, body: "Rejected: 1",
+		check: func(t *testing.T, repo *fakeRepository) {
+			eve, ok := repo.find("news", "eve@example.com")
+			if !ok || eve.Status != core.StatusConfirmed || eve.Consent == nil || eve.Consent.Form != "import" {
+				t.Errorf("got %+v", eve)
+			}
+			if len(repo.consents) != 2 || repo.consents[1].Text != "Old signup form" {
+				t.Errorf("recorded %+v", repo.consents)
+			}
+		},
+	},
+	{
+		route: "import-subscriptions-upload", method: "POST", path: "/subscriptions/import",
+		form: url.Values{"list": {"default"}}, file: "email,name\ncarol@example.com,Carol\n",
+		code: 200, body: "Rejected: 1",
+		check: func(t *testing.T, repo *fakeRepository) {
+			carol, _ := repo.find("default", "carol@example.com")
+			if carol.DeletedAt.IsZero() || carol.Token != "carol-token" {
+				t.Errorf("the subscription in the trash has been overwritten: %+v", carol)
+			}
+		},
+	},
+	{
+		route: "import-errors", method: "GET", path: "/subscriptions/import/:id",
+		id: func(s *Server) string {
+			return s.imports.add(importer.Report{Errors: []importer.RowError{{Line: 3, Email: "not-an-email", Reason: "invalid e-mail"}}})
+		},
+		code: 200, body: "3,not-an-email,invalid e-mail", header: "import-errors.csv",
+	},
+	{route: "import-errors", method: "GET", path: "/subscriptions/import/nope", code: 404, body: "This import report has expired"},
+	{
+		route: "bulk-subscriptions", method: "POST", path: "/subscriptions/bulk",
+		form: url.Values{"action": {"tag"}, "tags": {"VIP"}, "subscription": {"default/ada@example.com", "news/bob@example.com"}},
+		code: 302, body: "?success=Tagging of 2 subscriptions started",
+		check: func(t *testing.T, repo *fakeRepository) {
+			for _, s := range []struct{ list, email string }{{"default", "ada@example.com"}, {"news", "bob@example.com"}} {
+				if sub, _ := repo.find(s.list, s.email); !sub.HasTag("vip") {
+					t.Errorf("%s has not been tagged: %v", s.email, sub.Tags)
+				}
+			}
+			recorded("subscription.tag")(t, repo)
+		},
+	},
+	{
+		route: "bulk-subscriptions", method: "POST", path: "/subscriptions/bulk",
+		form: url.Values{"action": {"tag"}, "tags": {"VIP"}},
+		code: 302, body: "/subscriptions/?error=No subscription selected",
+	},
+	{
+		route: "bulk-job", method: "GET", path: "/subscriptions/jobs/:id",
+		id: func(s *Server) string {
+			j := s.jobs.add(bulkTrash, 2)
+			j.progress(nil)
+			return j.status.ID
+		},
+		code: 200, body: "Move to trash",
+	},
+	{
+		route: "bulk-job", method: "GET", path: "/subscriptions/jobs/:id", accept: echo.MIMEApplicationJSON,
+		id: func(s *Server) string {
+			j := s.jobs.add(bulkTrash, 2)
+			j.progress(nil)
+			return j.status.ID
+		},
+		code: 200, body: `"total":2,"done":1`,
+	},
+	{
+		route: "bulk-job-download", method: "GET", path: "/subscriptions/jobs/:id/download",
+		id: func(s *Server) string {
+			j := s.jobs.add(bulkExport, 1)
+			j.attach("subscriptions.csv", "text/csv", []byte("email\nada@example.com\n"))
+			return j.status.ID
+		},
+		code: 200, body: "ada@example.com", header: "subscriptions.csv",
+	},
+	{
+		route: "bulk-job-download", method: "GET", path: "/subscriptions/jobs/:id/download",
+		id:   func(s *Server) string { return s.jobs.add(bulkExport, 1).status.ID },
+		code: 404, body: "This export has expired or is not ready",
+	},
+	{route: "trash", method: "GET", path: "/subscriptions/trash", code: 200, body: "carol@example.com"},
+	{
+		route: "restore-subscriptions", method: "POST", path: "/subscriptions/trash/restore",
+		form: url.Values{"subscription": {"default/carol@example.com"}},
+		code: 302, body: "/subscriptions/trash?success=1 subscriptions restored",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("default", "carol@example.com"); !s.DeletedAt.IsZero() {
+				t.Error("the subscription is still in the trash")
+			}
+			recorded("subscription.restore")(t, repo)
+		},
+	},
+	{
+		route: "purge-subscriptions", method: "POST", path: "/subscriptions/trash/purge",
+		form: url.Values{"all": {"1"}},
+		code: 302, body: "/subscriptions/trash?success=1 subscriptions permanently deleted",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("default", "carol@example.com"); ok {
+				t.Error("the subscription has not been deleted")
+			}
+			if _, ok := repo.find("default", "ada@example.com"); !ok {
+				t.Error("a subscription out of the trash has been deleted")
+			}
+			recorded("subscription.purge")(t, repo)
+		},
+	},
+	{
+		route: "validate-email", method: "GET", path: "/subscriptions/ada@example.com/validate",
+		code: 200, body: `"format_valid":true`,
+		check: func(t *testing.T, repo *fakeRepository) {
+			if len(repo.verifications) != 1 {
+				t.Errorf("got %d verifications recorded, want 1", len(repo.verifications))
+			}
+			recorded("subscription.validate")(t, repo)
+		},
+	},
+	{route: "validate-email", method: "GET", path: "/subscriptions/nobody@example.com/validate", code: 404, body: "Could not find a subscription for the given email"},
+	{route: "subscription-consents", method: "GET", path: "/subscriptions/ada@example.com/consents", code: 200, body: "I agree to the seeded terms."},
+	{
+		route: "delete-email", method: "DELETE", path: "/subscriptions/ada@example.com/?list=default",
+		code: 200,
+		check: func(t *testing.T, repo *fakeRepository) {
+			if s, _ := repo.find("default", "ada@example.com"); s.DeletedAt.IsZero() {
+				t.Error("the subscription has not been moved to the trash")
+			}
+			recorded("subscription.trash")(t, repo)
+		},
+	},
+	{route: "delete-email", method: "DELETE", path: "/subscriptions/ada@example.com/", code: 422, body: "The list of the subscription is required"},
+
+	{route: "lists", method: "GET", path: "/lists/?edit=news", code: 200, body: "company"},
+	{
+		route: "save-list", method: "POST", path: "/lists/",
+		form: url.Values{"slug": {"events"}, "name": {"Events"}, "field-name": {"city"}, "field-type": {"text"}},
+		code: 302, body: "/lists/?success=The list Events has been saved",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if l, err := repo.FindList(context.Background(), "events"); err != nil || len(l.Fields) != 1 {
+				t.Errorf("got list %+v, %v, want it saved with its field", l, err)
+			}
+			recorded("list.save")(t, repo)
+		},
+	},
+
+	{route: "gdpr", method: "GET", path: "/gdpr/", code: 200, body: `<form action="/gdpr/erase" method="POST"`},
+	{
+		route: "gdpr-export", method: "GET", path: "/gdpr/export?email=ada@example.com",
+		code: 200, body: `"email": "ada@example.com"`, header: "gdpr-",
+		response: func(t *testing.T, body []byte) {
+			var bundle gdpr.Bundle
+			if err := json.Unmarshal(body, &bundle); err != nil {
+				t.Fatal(err)
+			}
+			if len(bundle.Subscriptions) != 1 || bundle.Subscriptions[0].List != "default" || bundle.Subscriptions[0].Name != "Ada Lovelace" {
+				t.Errorf("got subscriptions %+v, want ada's subscription to the default list", bundle.Subscriptions)
+			}
+			if len(bundle.Consents) != 1 || bundle.Consents[0].Text != "I agree to the seeded terms." {
+				t.Errorf("got consents %+v, want ada's seeded consent", bundle.Consents)
+			}
+			if len(bundle.Verifications) != 0 || len(bundle.Audit) != 0 {
+				t.Errorf("got %d verifications and %d audit entries, want none", len(bundle.Verifications), len(bundle.Audit))
+			}
+		},
+	},
+	{route: "gdpr-export", method: "GET", path: "/gdpr/export?email=nope", code: 422, body: "Invalid e-mail"},
+	{
+		route: "gdpr-erase", method: "POST", path: "/gdpr/erase",
+		form: url.Values{"email": {"ada@example.com"}, "confirm": {"ada@example.com"}},
+		code: 302, body: "/gdpr/?success=ada@example.com has been erased: 1 subscriptions",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("default", "ada@example.com"); ok {
+				t.Error("the subscription has not been erased")
+			}
+			if len(repo.tombstones) != 1 || repo.tombstones[0].Hash != repo.hasher.Hash("ada@example.com") {
+				t.Errorf("got tombstones %+v, want 1 with the keyed hash of the email", repo.tombstones)
+			}
+		},
+	},
+	{
+		route: "gdpr-erase", method: "POST", path: "/gdpr/erase",
+		form: url.Values{"email": {"ada@example.com"}, "confirm": {"ada@example.org"}},
+		code: 302, body: "/gdpr/?error=Type the e-mail again to confirm the erasure",
+	},
+
+	{route: "segments", method: "GET", path: "/segments/", code: 200, body: "Beta testers"},
+	{
+		route: "save-segment", method: "POST", path: "/segments/",
+		form: url.Values{"slug": {"vip"}, "name": {"VIP"}, "expression": {`tag = "vip"`}},
+		code: 302, body: "/segments/?success=The segment VIP has been saved",
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, err := repo.FindSegment(context.Background(), "vip"); err != nil {
+				t.Error(err)
+			}
+			recorded("segment.save")(t, repo)
+		},
+	},
+	{
+		route: "save-segment", method: "POST", path: "/segments/",
+		form: url.Values{"slug": {"vip"}, "name": {"VIP"}, "expression": {"nope = 1"}},
+		code: 302, body: "/segments/?error=",
+	},
+	{route: "preview-segment", method: "GET", path: "/segments/preview?expression=" + url.QueryEscape(`email = "Ada@example.com"`), code: 200, body: `{"count":1}`},
+	// The subscriptions in the trash are not counted.
+	{route: "preview-segment", method: "GET", path: "/segments/preview?expression=" + url.QueryEscape(`email ~ "example.com"`), code: 200, body: `{"count":2}`},
+	{route: "preview-segment", method: "GET", path: "/segments/preview?expression=nope", code: 422},
+	{
+		route: "delete-segment", method: "DELETE", path: "/segments/beta",
+		code: 204,
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, err := repo.FindSegment(context.Background(), "beta"); core.KindOf(err) != core.NotFound {
+				t.Errorf("got %v, want the segment removed", err)
+			}
+			recorded("segment.delete")(t, repo)
+		},
+	},
+	{route: "delete-segment", method: "DELETE", path: "/segments/nope", code: 404, body: "segment not found"},
+
+	{
+		route: "retention", method: "GET", path: "/retention/", code: 200, body: "<td>Trash</td><td>720h0m0s</td>",
+		response: func(t *testing.T, body []byte) {
+			// Carol has been in the trash for 40 days.
+			if !regexp.MustCompile(`<td>trash</td>\s*<td>[^<]+</td>\s*<td>1</td>\s*<td class="small">carol@example.com</td>`).Match(body) {
+				t.Errorf("got %s, want the trash rule matching carol", body)
+			}
+		},
+		check: func(t *testing.T, repo *fakeRepository) {
+			if _, ok := repo.find("default", "carol@example.com"); !ok {
+				t.Error("the dry run purged carol")
+			}
+		},
+	},
+	{route: "audit", method: "GET", path: "/audit/?action=list.save", code: 200, body: "news"},
+	{route: "audit", method: "GET", path: "/audit/?since=yesterday", code: 422, body: "Invalid date"},
+}
+
+func TestRoutes(t *testing.T) {
+	for _, rt := range routeTests {
+		rt := rt
+		t.Run(rt.route, rt.do)
+	}
+}
+
+// TestRoutesCovered checks every named route has a test.
+func TestRoutesCovered(t *testing.T) {
+	covered := map[string]bool{}
+	for _, rt := range routeTests {
+		covered[rt.route] = true
+	}
+	_, _, e := newTestServer(t)
+	for _, r := range e.Routes() {
+		// The routes without name are named after their handler function.
+		if strings.Contains(r.Name, ".") {
+			continue
+		}
+		if !covered[r.Name] {
+			t.Errorf("the route %s (%s %s) has no test", r.Name, r.Method, r.Path)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	s, repo, e := newTestServer(t)
+	defer s.Close()
+	user, err := core.NewUser("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.users = append(repo.users, user)
+
+	for _, c := range []struct {
+		user, password string
+		code           int
+	}{
+		{"admin", "correct horse", http.StatusOK},
+		{"admin", "wrong horse", http.StatusUnauthorized},
+		// The default credentials are only accepted until the first user is created.
+		{"golang", "echo!", http.StatusUnauthorized},
+		{"nobody", "correct horse", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/lists/", nil)
+		req.SetBasicAuth(c.user, c.password)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("%s:%s: got status %d, want %d", c.user, c.password, rec.Code, c.code)
+		}
+	}
+}
+
+func TestRequireAuthCachesVerifiedCredentials(t *testing.T) {
+	s, repo, e := newTestServer(t)
+	defer s.Close()
+	user, err := core.NewUser("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.users = append(repo.users, user)
+
+	for _, c := range []struct {
+		password string
+		code     int
+		lookups  int
+	}{
+		{"correct horse", http.StatusOK, 1},
+		// The verified credentials are not checked again.
+		{"correct horse", http.StatusOK, 1},
+		{"wrong horse", http.StatusUnauthorized, 2},
+		{"wrong horse", http.StatusUnauthorized, 3},
+		{"correct horse", http.StatusOK, 3},
+	} {
+		req := httptest.NewRequest("GET", "/lists/", nil)
+		req.SetBasicAuth("admin", c.password)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != c.code || repo.userLookups != c.lookups {
+			t.Errorf("%s: got status %d after %d lookups, want %d after %d", c.password, rec.Code, repo.userLookups, c.code, c.lookups)
+		}
+	}
+}
+
+func TestPublicMetrics(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Metrics.Public = true
+	s, _, e := newTestServerWith(t, cfg, discardLogger())
+	defer s.Close()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestValidationKeepsConcurrentChanges(t *testing.T) {
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/subscriptions/validate", nil),
+		httptest.NewRequest("GET", "/subscriptions/ada@example.com/validate", nil),
+		httptest.NewRequest("POST", "/subscriptions/bulk", strings.NewReader(url.Values{
+			"action": {"validate"}, "subscription": {"default/ada@example.com", "news/bob@example.com"},
+		}.Encode())),
+	} {
+		s, repo, e := newTestServer(t)
+		// Ada's subscription is moved to the trash, and bob's renamed, once read.
+		var once sync.Once
+		repo.beforeSetVerification = func() {
+			once.Do(func() {
+				ctx := context.Background()
+				repo.Trash(ctx, map[string]interface{}{"list": "default", "email": "ada@example.com"})
+				bob, _ := repo.find("news", "bob@example.com")
+				bob.Name = "Robert"
+				repo.Upsert(ctx, bob)
+			})
+		}
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.SetBasicAuth("golang", "echo!")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusFound && rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: got status %d\n%s", req.Method, req.URL, rec.Code, rec.Body)
+		}
+
+		if ada, _ := repo.find("default", "ada@example.com"); ada.DeletedAt.IsZero() || ada.Valid {
+			t.Errorf("%s %s: got %+v, want ada's subscription left in the trash", req.Method, req.URL, ada)
+		}
+		if bob, _ := repo.find("news", "bob@example.com"); req.URL.Path != "/subscriptions/ada@example.com/validate" && (bob.Name != "Robert" || !bob.Valid) {
+			t.Errorf("%s %s: got %+v, want bob's subscription validated with its new name", req.Method, req.URL, bob)
+		}
+	}
+}
+
+func TestSubscribeMails(t *testing.T) {
+	var logs bytes.Buffer
+	s, repo, e := newTestServerWith(t, testConfig(), logging.New(&logs, logging.FormatJSON, logging.DebugLevel))
+	defer s.Close()
+	mailer := s.Mailer.(*fakeMailer)
+	subscribe := func() *httptest.ResponseRecorder {
+		form := url.Values{"email": {"dan@example.com"}, "full-name": {"Dan"}, "list": {"news"}, "field.company": {"Acme"}, "consent": {"on"}, "consent-version": {"1"}}
+		req := httptest.NewRequest("POST", "/subscribe", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		// The links point to the mail.baseURL setting, whatever the request host.
+		req.Host = "evil.example"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The subscriber is told when the confirmation link cannot be sent, and may subscribe again.
+	mailer.err = core.Errorf(core.ProviderUnavailable, "could not send the e-mail")
+	if rec := subscribe(); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	mailer.err = nil
+	if rec := subscribe(); rec.Code != http.StatusFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusFound)
+	}
+
+	sub, _ := repo.find("news", "dan@example.com")
+	sent := mailer.messages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Body, "http://localhost:4000/confirm/"+sub.Token) || sent[0].Headers != nil {
+		t.Errorf("got %+v, want the confirmation link of %s", sent, sub.Token)
+	}
+	if !strings.Contains(logs.String(), "subscription pending confirmation") {
+		t.Errorf("got logs %s", logs.String())
+	}
+	if strings.Contains(logs.String(), sub.Token) || strings.Contains(logs.String(), "dan@example.com") {
+		t.Errorf("the logs leak the token or the email: %s", logs.String())
+	}
+
+	// The confirmed subscriptions get the preferences link, which is not logged either.
+	logs.Reset()
+	form := url.Values{"email": {"eve@example.com"}, "full-name": {"Eve"}, "consent": {"on"}, "consent-version": {"1"}}
+	req := httptest.NewRequest("POST", "/subscribe", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	sub, _ = repo.find("default", "eve@example.com")
+	if sent := mailer.messages(); len(sent) != 2 || !strings.Contains(sent[1].Body, "/preferences/"+sub.Token) {
+		t.Errorf("got %+v, want the preferences link of %s", sent, sub.Token)
+	}
+	if !strings.Contains(logs.String(), "subscription confirmed") || strings.Contains(logs.String(), sub.Token) {
+		t.Errorf("got logs %s, want them without the token %s", logs.String(), sub.Token)
+	}
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	var logs bytes.Buffer
+	s, repo, e := newTestServerWith(t, testConfig(), logging.New(&logs, logging.FormatJSON, logging.DebugLevel))
+	defer s.Close()
+
+	// Confirming bob's subscription sends the welcome message.
+	repo.Upsert(context.Background(), core.Subscription{List: "default", Email: "bob@example.com", Name: "Bob", Status: core.StatusConfirmed, Token: "bob-default-token"})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/confirm/bob-token", nil))
+	sent := s.Mailer.(*fakeMailer).messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %+v, want the welcome message", sent)
+	}
+	if got := sent[0].Headers["List-Unsubscribe-Post"]; got != "List-Unsubscribe=One-Click" {
+		t.Errorf("got List-Unsubscribe-Post %q", got)
+	}
+	link := strings.Trim(sent[0].Headers["List-Unsubscribe"], "<>")
+	if link != "http://localhost:4000/unsubscribe/bob-token" {
+		t.Fatalf("got List-Unsubscribe %q", link)
+	}
+
+	// The mail client posts the RFC 8058 body to the link.
+	req := httptest.NewRequest("POST", link, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusFound)
+	}
+	if _, ok := repo.find("news", "bob@example.com"); ok {
+		t.Error("the subscription has not been removed")
+	}
+	if _, ok := repo.find("default", "bob@example.com"); !ok {
+		t.Error("the subscription to the other list has been removed")
+	}
+	if strings.Contains(logs.String(), "bob-token") || !strings.Contains(logs.String(), `"path":"/unsubscribe/REDACTED"`) {
+		t.Errorf("got logs %s, want the token redacted from the paths", logs.String())
+	}
+}
+
+func TestConfirmSurvivesMailFailure(t *testing.T) {
+	s, repo, e := newTestServer(t)
+	defer s.Close()
+	s.Mailer.(*fakeMailer).err = core.Errorf(core.ProviderUnavailable, "could not send the e-mail")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/confirm/bob-token", nil))
+	if rec.Code != http.StatusFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusFound)
+	}
+	if sub, _ := repo.find("news", "bob@example.com"); sub.Status != core.StatusConfirmed {
+		t.Errorf("got status %s, want %s", sub.Status, core.StatusConfirmed)
+	}
+}
+
+func TestReload(t *testing.T) {
+	var logs bytes.Buffer
+	s, _, _ := newTestServerWith(t, testConfig(), logging.New(&logs, logging.FormatJSON, logging.InfoLevel))
+	defer s.Close()
+
+	next := config.Defaults()
+	next.GDPR.HashKey = s.Config.GDPR.HashKey
+	next.Validation.Concurrency = 3
+	next.Log.Level = "debug"
+	next.BindAddr = ":5000"
+	s.reload(next, nil)
+	if got := s.validationConcurrency(); got != 3 {
+		t.Errorf("got concurrency %d, want 3", got)
+	}
+	if s.Config.BindAddr == ":5000" {
+		t.Error("bindAddr has been applied")
+	}
+	s.Logger.Debug("debug enabled")
+	for _, want := range []string{
+		`"msg":"configuration reloaded"`,
+		`"applied":"[log.level validation.concurrency]"`,
+		`"msg":"configuration changes require a restart"`,
+		`"settings":"[bindAddr]"`,
+		`"msg":"debug enabled"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs\n%s\nwant %s", logs.String(), want)
+		}
+	}
+
+	// An invalid edit leaves the settings unchanged.
+	logs.Reset()
+	s.reload(nil, &config.Error{Files: []string{"dev.yaml"}, Problems: []string{"validation.concurrency: must be positive"}})
+	if got := s.validationConcurrency(); got != 3 {
+		t.Errorf("got concurrency %d after an invalid edit, want 3", got)
+	}
+	if !strings.Contains(logs.String(), `"msg":"configuration change rejected"`) || strings.Contains(logs.String(), "reloaded") {
+		t.Errorf("got logs\n%s\nwant the change rejected", logs.String())
+	}
+
+	// The restart is still required while the file differs from the settings in use.
+	logs.Reset()
+	s.reload(next, nil)
+	if strings.Contains(logs.String(), "reloaded") || !strings.Contains(logs.String(), `"settings":"[bindAddr]"`) {
+		t.Errorf("got logs\n%s\nwant only the restart required", logs.String())
+	}
+}
+
+func TestServeAndClose(t *testing.T) {
+	cfg := testConfig()
+	cfg.BindAddr = "127.0.0.1:0"
+	cfg.Retention.Interval = 0
+	s, _, _ := newTestServerWith(t, cfg, discardLogger())
+
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	// Close races with Serve registering its echo instance.
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
+
+func TestServeAfterClose(t *testing.T) {
+	cfg := testConfig()
+	cfg.BindAddr = "127.0.0.1:0"
+	s, _, _ := newTestServerWith(t, cfg, discardLogger())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(); err != nil {
+		t.Errorf("got %v, want Serve to return at once", err)
+	}
+}
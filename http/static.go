@@ -1,12 +1,17 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
-	"net/http"
+	"net/url"
 	"path/filepath"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
 
 	"github.com/labstack/echo"
 )
@@ -18,14 +23,22 @@ type Template struct {
 
 // Render executes the template with a given context.
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	fmt.Println(name, data)
-	return t.templates.ExecuteTemplate(w, name, data)
+	ctx, span := tracing.Start(c.Request().Context(), "render "+name, tracing.KindInternal)
+	defer span.End()
+	logging.FromContext(ctx).Debug("rendering template", "template", name)
+
+	err := t.templates.ExecuteTemplate(w, name, data)
+	span.RecordError(err)
+	return err
 }
 
-func newTemplate(e *echo.Echo) echo.Renderer {
+func newTemplate(e *echo.Echo) (echo.Renderer, error) {
 	files, err := filepath.Glob("http/pages/*.html")
-	if err != nil || len(files) == 0 {
-		log.Fatalf("Fail to load tempates: %s", err)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to load templates: %s", err)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("Fail to load templates: no template found")
 	}
 
 	var t *template.Template
@@ -38,29 +51,27 @@ func newTemplate(e *echo.Echo) echo.Renderer {
 			"urlFor": func(routeName string, params ...interface{}) string {
 				return e.Reverse(routeName, params...)
 			},
+			"fieldInput": fieldInput,
+			"join":       strings.Join,
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+			"formValue": func(values url.Values, name string) string {
+				return values.Get(name)
+			},
+			"formHas": func(values url.Values, name, value string) bool {
+				for _, v := range values[name] {
+					if v == value {
+						return true
+					}
+				}
+				return false
+			},
 		}).ParseFiles(file))
 	}
 
 	return &Template{
 		templates: t,
-	}
-}
-
-func customHTTPErrorHandler(err error, c echo.Context) {
-	code := http.StatusInternalServerError
-	if he, ok := err.(*echo.HTTPError); ok {
-		code = he.Code
-	}
-
-	if code == http.StatusUnauthorized {
-		c.HTML(http.StatusUnauthorized, `You don't have access to this page. Please, head back to the <a href="/" >home page</a> .`)
-		return
-	}
-
-	errorPage := fmt.Sprintf("http/pages/%d.html", code)
-	if err := c.File(errorPage); err != nil {
-		c.Logger().Error(err)
-	}
-
-	c.Logger().Error(err)
+	}, nil
 }
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+var tagRE = regexp.MustCompile(`^[a-z0-9]+([-_][a-z0-9]+)*$`)
+
+// parseTags splits the comma separated tags typed by the admin, lowercased.
+func parseTags(value string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if !tagRE.MatchString(tag) {
+			return nil, core.Errorf(core.InvalidInput, "Invalid tag %q: use letters, digits, dashes and underscores", tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, core.Errorf(core.InvalidInput, "No tag given")
+	}
+	return tags, nil
+}
+
+// selectedSubscriptions builds the selector of the subscriptions checked in the subscriptions.html table,
+// submitted as `subscription` values formatted as "<list>/<email>".
+func selectedSubscriptions(c echo.Context) (map[string]interface{}, error) {
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, err
+	}
+	var or []interface{}
+	for _, v := range form["subscription"] {
+		parts := strings.SplitN(v, "/", 2)
+		if len(parts) != 2 {
+			return nil, core.Errorf(core.InvalidInput, "Invalid subscription %q", v)
+		}
+		or = append(or, map[string]interface{}{"list": parts[0], "email": parts[1]})
+	}
+	if len(or) == 0 {
+		return nil, core.Errorf(core.InvalidInput, "No subscription selected")
+	}
+	return map[string]interface{}{"$or": or}, nil
+}
+
+// applyTags returns the tags of a subscription once the given tags are added, or removed.
+func applyTags(current, tags []string, remove bool) []string {
+	has := map[string]bool{}
+	for _, t := range current {
+		has[t] = true
+	}
+	result := []string{}
+	if remove {
+		removed := map[string]bool{}
+		for _, t := range tags {
+			removed[t] = true
+		}
+		for _, t := range current {
+			if !removed[t] {
+				result = append(result, t)
+			}
+		}
+		return result
+	}
+	result = append(result, current...)
+	for _, t := range tags {
+		if !has[t] {
+			result = append(result, t)
+			has[t] = true
+		}
+	}
+	return result
+}
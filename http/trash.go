@@ -0,0 +1,101 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/labstack/echo"
+)
+
+// TrashHandler renders the trash.html page, the subscriptions deleted from the subscriptions.html
+// page, which are permanently deleted emptyAfter their deletion. A zero emptyAfter keeps them forever.
+func TrashHandler(repo core.Repository, emptyAfter time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subscriptions, err := repo.FindAll(c.Request().Context(), core.InTrash(nil))
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "trash.html", ViewContext{
+			"page":          "trash",
+			"subscriptions": subscriptions,
+			"emptyAfter":    emptyAfter,
+			"success":       c.QueryParam("success"),
+			"error":         c.QueryParam("error"),
+		})
+	}
+}
+
+// trashFlash redirects to the trash.html page with the message of err, when it is an invalid input.
+func trashFlash(c echo.Context, e *echo.Echo, err error) error {
+	if core.KindOf(err) == core.InvalidInput {
+		return redirectWithFlashMessage(c, e, "trash", "error", err.(*core.Error).Msg)
+	}
+	return err
+}
+
+// restoreHandler takes the subscriptions checked in the trash.html table out of the trash.
+func restoreHandler(repo core.Repository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		selector, err := selectedSubscriptions(c)
+		if err != nil {
+			return trashFlash(c, e, err)
+		}
+		ctx := c.Request().Context()
+		subscriptions, err := repo.FindAll(ctx, core.InTrash(selector))
+		if err != nil {
+			return err
+		}
+		for _, s := range subscriptions {
+			if _, err := repo.Restore(ctx, map[string]interface{}{"list": s.List, "email": s.Email}); err != nil {
+				return err
+			}
+			err := recordAdmin(c, audit, core.AuditEntry{
+				Action: "subscription.restore",
+				Target: s.Email,
+				List:   s.List,
+				Before: map[string]interface{}{"deletedAt": s.DeletedAt},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return redirectWithFlashMessage(c, e, "trash", "success", fmt.Sprintf("%d subscriptions restored", len(subscriptions)))
+	}
+}
+
+// purgeHandler permanently deletes the subscriptions checked in the trash.html table, or all the
+// subscriptions in the trash when the `all` form value is set.
+func purgeHandler(repo core.Repository, audit core.AuditLog, e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var selector map[string]interface{}
+		if c.FormValue("all") == "" {
+			var err error
+			if selector, err = selectedSubscriptions(c); err != nil {
+				return trashFlash(c, e, err)
+			}
+		}
+		ctx := c.Request().Context()
+		subscriptions, err := repo.FindAll(ctx, core.InTrash(selector))
+		if err != nil {
+			return err
+		}
+		for _, s := range subscriptions {
+			if err := repo.Remove(ctx, core.InTrash(map[string]interface{}{"list": s.List, "email": s.Email})); err != nil {
+				return err
+			}
+			err := recordAdmin(c, audit, core.AuditEntry{
+				Action: "subscription.purge",
+				Target: s.Email,
+				List:   s.List,
+				Before: subscriptionSnapshot(s),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return redirectWithFlashMessage(c, e, "trash", "success", fmt.Sprintf("%d subscriptions permanently deleted", len(subscriptions)))
+	}
+}
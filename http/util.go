@@ -3,11 +3,23 @@ package http
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/labstack/echo"
 )
 
-func redirectWithFlashMessage(c echo.Context, e *echo.Echo, routeName, msgType, msg string) error {
-	path := e.Reverse(routeName)
-	return c.Redirect(http.StatusFound, fmt.Sprintf("http://%s%s?%s=%s", c.Request().Host, path, msgType, msg))
+// redirectWithFlashMessage redirects to the named route, built with the given params,
+// passing the message in the msgType query parameter.
+func redirectWithFlashMessage(c echo.Context, e *echo.Echo, routeName, msgType, msg string, params ...interface{}) error {
+	path := e.Reverse(routeName, params...)
+	query := url.Values{msgType: {msg}}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("http://%s%s?%s", c.Request().Host, path, query.Encode()))
+}
+
+// actor returns the name of the authenticated admin, recorded in the audit trail.
+func actor(c echo.Context) string {
+	if user, _, ok := c.Request().BasicAuth(); ok {
+		return user
+	}
+	return "admin"
 }
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"sync"
+
+	"github.com/klebervirgilio/go-echo-basics/logging"
+)
+
+// workerGroup tracks the goroutines that outlive the requests which started them,
+// such as the bulk validations, so they can be drained when the server stops.
+type workerGroup struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping context.Context
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopping, stop := context.WithCancel(ctx)
+	return &workerGroup{ctx: ctx, cancel: cancel, stopping: stopping, stop: stop}
+}
+
+// Go runs fn in a new goroutine. The given context carries logger and is cancelled when the
+// group is stopped.
+func (w *workerGroup) Go(logger *logging.Logger, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(logging.NewContext(w.ctx, logger))
+	}()
+}
+
+// Loop is like Go for the workers running until the server stops, such as the retention
+// sweeper: their context is cancelled as soon as the group is stopped.
+func (w *workerGroup) Loop(logger *logging.Logger, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(logging.NewContext(w.stopping, logger))
+	}()
+}
+
+// Stop waits for the running workers until ctx is done, then cancels the ones still running
+// and waits for them to return. The loops are cancelled right away.
+func (w *workerGroup) Stop(ctx context.Context) error {
+	w.stop()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
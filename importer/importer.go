@@ -0,0 +1,374 @@
+// Package importer loads subscribers from CSV or TSV files exported by other mailing tools.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// Targets a column can be mapped to, besides the name of a custom field.
+const (
+	TargetEmail  = "email"
+	TargetName   = "name"
+	TargetIgnore = "-"
+)
+
+// batchSize is the number of rows checked against the existing subscriptions at once.
+const batchSize = 500
+
+// Mapping maps the file columns, by header, to the subscription attributes: TargetEmail,
+// TargetName, TargetIgnore or the name of a custom field.
+type Mapping map[string]string
+
+// ParseMapping parses a comma separated list of `Column=target` pairs, such as
+// "E-mail Address=email,Full Name=name,Company=company".
+func ParseMapping(spec string) (Mapping, error) {
+	m := Mapping{}
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected Column=target", pair)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+// guessTarget maps the usual column headers to the subscription attributes, and the name or
+// label of a field of list to that field; every other column becomes a custom field.
+func guessTarget(header string, list core.List) string {
+	header = strings.TrimSpace(header)
+	switch strings.ToLower(header) {
+	case "email", "e-mail", "mail", "email address", "e-mail address":
+		return TargetEmail
+	case "name", "full name", "full-name", "fullname":
+		return TargetName
+	}
+	for _, f := range list.Fields {
+		if strings.EqualFold(header, f.Name) || strings.EqualFold(header, f.Label) {
+			return f.Name
+		}
+	}
+	return header
+}
+
+// Options tune an import.
+type Options struct {
+	// List is the list the subscribers are imported to.
+	List core.List
+	// Mapping of the columns. Unmapped columns are guessed from their header when Mapping is empty
+	// and ignored otherwise.
+	// The columns mapped to the fields of List are validated like the subscribe form, the values of
+	// the multiselect fields being separated by commas; the other custom fields are kept as text.
+	Mapping Mapping
+	// Comma is the field delimiter. See CommaFor.
+	Comma rune
+	// DryRun reports what would be imported without writing anything.
+	DryRun bool
+	// Tombstones, when set, reject the emails erased at the request of their owner.
+	Tombstones core.TombstoneRepository
+	// ConsentSource tells where the subscribers gave their consent, such as "the signup form of
+	// our previous tool". It is recorded as the consent of every imported subscription, with
+	// Consents, and required by the double opt-in lists, as the subscribers are imported confirmed.
+	ConsentSource string
+	Consents      core.ConsentRepository
+}
+
+// CommaFor returns the delimiter matching a file name: tab for .tsv and .tab files, comma otherwise.
+func CommaFor(filename string) rune {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tsv", ".tab":
+		return '\t'
+	}
+	return ','
+}
+
+// RowError describes a rejected row.
+type RowError struct {
+	Line   int
+	Email  string
+	Reason string
+}
+
+// Report summarizes an import.
+type Report struct {
+	DryRun     bool
+	Total      int
+	Imported   int
+	Duplicates int
+	Errors     []RowError
+	// Emails are the addresses imported, or that would be imported in a dry run.
+	Emails []string
+}
+
+// WriteErrors writes the rejected rows as CSV.
+func (r Report) WriteErrors(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "email", "reason"})
+	for _, e := range r.Errors {
+		cw.Write([]string{fmt.Sprint(e.Line), e.Email, e.Reason})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type row struct {
+	line int
+	// email is the address as spelled in the file, to match the subscriptions stored before
+	// the emails were normalized.
+	email        string
+	subscription core.Subscription
+}
+
+// Import reads the subscribers from r, whose first line is the header, and upserts the valid
+// ones that are not subscribed yet. Rows are validated with the same rules as the subscribe form.
+func Import(ctx context.Context, repo core.Repository, r io.Reader, opts Options) (Report, error) {
+	report := Report{DryRun: opts.DryRun}
+	opts.ConsentSource = strings.TrimSpace(opts.ConsentSource)
+	if opts.List.DoubleOptIn && opts.ConsentSource == "" {
+		return report, core.Errorf(core.InvalidInput, "the list %s requires double opt-in: tell where the subscribers gave their consent", opts.List.Slug)
+	}
+
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return report, core.Errorf(core.InvalidInput, "the file is empty")
+	}
+	if err != nil {
+		return report, core.WrapError(core.InvalidInput, err, "invalid file")
+	}
+
+	targets := make([]string, len(header))
+	hasEmail := false
+	for i, h := range header {
+		h = strings.TrimPrefix(h, "\ufeff")
+		if len(opts.Mapping) == 0 {
+			targets[i] = guessTarget(h, opts.List)
+		} else if t, ok := opts.Mapping[strings.TrimSpace(h)]; ok {
+			targets[i] = t
+		} else {
+			targets[i] = TargetIgnore
+		}
+		if t := targets[i]; t != TargetEmail && t != TargetName && t != TargetIgnore && strings.ContainsAny(t, ".$") {
+			return report, core.Errorf(core.InvalidInput, "invalid field name %q: it cannot contain '.' or '$'", t)
+		}
+		hasEmail = hasEmail || targets[i] == TargetEmail
+	}
+	if !hasEmail {
+		return report, core.Errorf(core.InvalidInput, "no column is mapped to the email")
+	}
+	defs := map[string]core.Field{}
+	for _, f := range opts.List.Fields {
+		defs[f.Name] = f
+		if f.Required && !contains(targets, f.Name) {
+			return report, core.Errorf(core.InvalidInput, "no column is mapped to the required field %q", f.Name)
+		}
+	}
+
+	seen := map[string]bool{}
+	var batch []row
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return report, err
+			}
+			report.Total++
+			report.Errors = append(report.Errors, RowError{Line: line, Reason: err.Error()})
+			continue
+		}
+		report.Total++
+
+		sub := core.Subscription{List: opts.List.Slug, Status: core.StatusConfirmed}
+		var email string
+		values := map[string][]string{}
+		for i, value := range record {
+			if i >= len(targets) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			switch targets[i] {
+			case TargetIgnore:
+			case TargetEmail:
+				email, sub.Email = value, core.NormalizeEmail(value)
+			case TargetName:
+				sub.Name = value
+			default:
+				if f, ok := defs[targets[i]]; ok {
+					if f.Type == core.FieldMultiSelect {
+						values[f.Name] = append(values[f.Name], strings.Split(value, ",")...)
+					} else {
+						values[f.Name] = append(values[f.Name], value)
+					}
+					continue
+				}
+				if value == "" {
+					continue
+				}
+				if sub.Fields == nil {
+					sub.Fields = map[string]interface{}{}
+				}
+				sub.Fields[targets[i]] = value
+			}
+		}
+		fields, fieldsErr := opts.List.ParseFields(values)
+		for name, value := range fields {
+			if sub.Fields == nil {
+				sub.Fields = map[string]interface{}{}
+			}
+			sub.Fields[name] = value
+		}
+
+		switch {
+		case sub.Email == "":
+			report.Errors = append(report.Errors, RowError{Line: line, Reason: "missing e-mail"})
+		case !core.ValidEmail(sub.Email):
+			report.Errors = append(report.Errors, RowError{Line: line, Email: sub.Email, Reason: "invalid e-mail"})
+		case fieldsErr != nil:
+			report.Errors = append(report.Errors, RowError{Line: line, Email: sub.Email, Reason: fieldsErr.Error()})
+		case seen[sub.Email]:
+			report.Duplicates++
+		default:
+			seen[sub.Email] = true
+			batch = append(batch, row{line, email, sub})
+		}
+
+		if len(batch) == batchSize {
+			if err := flush(ctx, repo, opts, batch, &report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return report, flush(ctx, repo, opts, batch, &report)
+}
+
+// flush skips the rows already subscribed, rejects the ones in the trash or erased, and upserts
+// the others.
+func flush(ctx context.Context, repo core.Repository, opts Options, batch []row, report *Report) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	emails := make([]string, len(batch))
+	spellings := make([]string, 0, len(batch))
+	for i, r := range batch {
+		emails[i] = r.subscription.Email
+		spellings = append(spellings, r.subscription.Email)
+		if r.email != r.subscription.Email {
+			spellings = append(spellings, r.email)
+		}
+	}
+	selector := map[string]interface{}{
+		"list":  batch[0].subscription.List,
+		"email": map[string]interface{}{"$in": spellings},
+	}
+	existing, err := repo.FindAll(ctx, selector)
+	if err != nil {
+		return err
+	}
+	subscribed := map[string]bool{}
+	for _, s := range existing {
+		subscribed[core.NormalizeEmail(s.Email)] = true
+	}
+	// Upserting a subscription in the trash would drop its deletion time, consent and tags.
+	existing, err = repo.FindAll(ctx, core.InTrash(selector))
+	if err != nil {
+		return err
+	}
+	trashed := map[string]bool{}
+	for _, s := range existing {
+		trashed[core.NormalizeEmail(s.Email)] = true
+	}
+	erased := map[string]bool{}
+	if opts.Tombstones != nil {
+		if erased, err = opts.Tombstones.ErasedEmails(ctx, emails); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range batch {
+		if subscribed[r.subscription.Email] {
+			report.Duplicates++
+			continue
+		}
+		if trashed[r.subscription.Email] {
+			report.Errors = append(report.Errors, RowError{Line: r.line, Email: r.subscription.Email, Reason: "in the trash, restore it instead"})
+			continue
+		}
+		if erased[r.subscription.Email] {
+			report.Errors = append(report.Errors, RowError{Line: r.line, Email: r.subscription.Email, Reason: "erased at the request of its owner"})
+			continue
+		}
+		if !report.DryRun {
+			r.subscription.Token = core.NewToken()
+			r.subscription.SubscribedAt = time.Now()
+			if opts.ConsentSource != "" {
+				r.subscription.Consent = &core.Consent{
+					Email:   r.subscription.Email,
+					List:    r.subscription.List,
+					Form:    "import",
+					GivenAt: r.subscription.SubscribedAt,
+					Text:    opts.ConsentSource,
+				}
+				if err := opts.Consents.RecordConsent(ctx, *r.subscription.Consent); err != nil {
+					return err
+				}
+			}
+			if err := repo.Upsert(ctx, r.subscription); err != nil {
+				if core.KindOf(err) == core.Internal {
+					return err
+				}
+				report.Errors = append(report.Errors, RowError{Line: r.line, Email: r.subscription.Email, Reason: err.Error()})
+				continue
+			}
+		}
+		report.Imported++
+		report.Emails = append(report.Emails, r.subscription.Email)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the given addresses with the mail checker and stores the results,
+// stopping at the first failure.
+func Validate(ctx context.Context, repo core.Repository, checker core.MailChecker, emails []string) error {
+	for _, email := range emails {
+		resp, err := checker.Validate(ctx, email)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.SetVerification(ctx, map[string]interface{}{"email": email}, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// fakeRepository stores the subscriptions in memory. Like MongoDB, it compares the emails
+// case-sensitively, and finds the subscriptions in the trash only when asked. The other methods
+// are not used by the importer.
+type fakeRepository struct {
+	core.Repository
+	subscriptions []core.Subscription
+	lookups       int
+}
+
+func (f *fakeRepository) FindAll(ctx context.Context, selector map[string]interface{}) ([]core.Subscription, error) {
+	_, inTrash := selector["deletedAt"]
+	if !inTrash {
+		f.lookups++
+	}
+	in := selector["email"].(map[string]interface{})["$in"].([]string)
+	var found []core.Subscription
+	for _, s := range f.subscriptions {
+		for _, email := range in {
+			if s.List == selector["list"] && s.Email == email && !s.DeletedAt.IsZero() == inTrash {
+				found = append(found, s)
+			}
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeRepository) Upsert(ctx context.Context, subscription core.Subscription) error {
+	f.subscriptions = append(f.subscriptions, subscription)
+	return nil
+}
+
+type fakeTombstones map[string]bool
+
+func (f fakeTombstones) AddTombstone(ctx context.Context, tombstone core.Tombstone) error {
+	return nil
+}
+
+func (f fakeTombstones) ErasedEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	erased := map[string]bool{}
+	for _, email := range emails {
+		if f[email] {
+			erased[email] = true
+		}
+	}
+	return erased, nil
+}
+
+type fakeConsents struct {
+	core.ConsentRepository
+	consents []core.Consent
+}
+
+func (f *fakeConsents) RecordConsent(ctx context.Context, consent core.Consent) error {
+	f.consents = append(f.consents, consent)
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	repo := &fakeRepository{subscriptions: []core.Subscription{
+		{List: "news", Email: "ada@example.com"},
+		{List: "news", Email: "Legacy@Example.com"},
+		{List: "other", Email: "bob@example.com"},
+		{List: "news", Email: "trashed@example.com", Tags: []string{"vip"}, DeletedAt: time.Now()},
+	}}
+	file := "\ufeffE-mail,Full Name,Company,Notes\n" +
+		"bob@example.com,Bob,Acme,\n" +
+		"ADA@example.com,Ada,,\n" +
+		" Bob@Example.com ,Bob again,,\n" +
+		"Legacy@Example.com,Legacy,,\n" +
+		"not-an-email,Nobody,,\n" +
+		",Nobody,,\n" +
+		"erased@example.com,Erased,,\n" +
+		"trashed@example.com,Trashed,,\n" +
+		"\"carol@example.com,Carol\n"
+
+	report, err := Import(context.Background(), repo, strings.NewReader(file), Options{
+		List:       core.List{Slug: "news"},
+		Tombstones: fakeTombstones{"erased@example.com": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total != 9 || report.Imported != 1 || report.Duplicates != 3 || len(report.Errors) != 5 {
+		t.Errorf("got %+v", report)
+	}
+	if len(report.Emails) != 1 || report.Emails[0] != "bob@example.com" {
+		t.Errorf("imported %v", report.Emails)
+	}
+	// The rows are checked against the database once the batch is read.
+	for i, want := range []RowError{
+		{Line: 6, Email: "not-an-email", Reason: "invalid e-mail"},
+		{Line: 7, Reason: "missing e-mail"},
+		{},
+		{Line: 8, Email: "erased@example.com", Reason: "erased at the request of its owner"},
+		{Line: 9, Email: "trashed@example.com", Reason: "in the trash, restore it instead"},
+	} {
+		if i != 2 && report.Errors[i] != want {
+			t.Errorf("error %d: got %+v, want %+v", i, report.Errors[i], want)
+		}
+	}
+	if report.Errors[2].Line != 10 || !strings.Contains(report.Errors[2].Reason, "quote") {
+		t.Errorf("got %+v, want the parse error of line 10", report.Errors[2])
+	}
+
+	added := repo.subscriptions[4:]
+	if len(added) != 1 {
+		t.Fatalf("upserted %+v", added)
+	}
+	bob := added[0]
+	if bob.List != "news" || bob.Name != "Bob" || bob.Fields["company"] != nil || bob.Fields["Company"] != "Acme" || bob.Status != core.StatusConfirmed || bob.Token == "" {
+		t.Errorf("got %+v", bob)
+	}
+	if _, ok := bob.Fields["Notes"]; ok {
+		t.Error("an empty custom field was stored")
+	}
+	if bob.Consent != nil {
+		t.Errorf("got the consent %+v without a consent source", bob.Consent)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteErrors(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "line,email,reason\n6,not-an-email,invalid e-mail\n7,,missing e-mail\n") {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func TestImportConsentSource(t *testing.T) {
+	news := core.List{Slug: "news", DoubleOptIn: true}
+	file := "email\nada@example.com\n"
+
+	repo := &fakeRepository{}
+	_, err := Import(context.Background(), repo, strings.NewReader(file), Options{List: news, ConsentSource: "  "})
+	if core.KindOf(err) != core.InvalidInput || !strings.Contains(err.Error(), "requires double opt-in") {
+		t.Errorf("got %v, want the consent source to be required", err)
+	}
+	if len(repo.subscriptions) != 0 {
+		t.Errorf("stored %+v", repo.subscriptions)
+	}
+
+	consents := &fakeConsents{}
+	report, err := Import(context.Background(), repo, strings.NewReader(file), Options{
+		List:          news,
+		ConsentSource: " Signup form of the old tool ",
+		Consents:      consents,
+	})
+	if err != nil || report.Imported != 1 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	want := core.Consent{Email: "ada@example.com", List: "news", Form: "import", Text: "Signup form of the old tool"}
+	if len(consents.consents) != 1 {
+		t.Fatalf("recorded %+v", consents.consents)
+	}
+	got := consents.consents[0]
+	if got.GivenAt.IsZero() {
+		t.Error("the consent time is missing")
+	}
+	got.GivenAt = time.Time{}
+	if got != want {
+		t.Errorf("recorded %+v, want %+v", got, want)
+	}
+	ada := repo.subscriptions[0]
+	if ada.Status != core.StatusConfirmed || ada.Consent == nil || ada.Consent.Text != want.Text {
+		t.Errorf("got %+v", ada)
+	}
+}
+
+func TestImportFields(t *testing.T) {
+	news := core.List{Slug: "news", Fields: []core.Field{
+		{Name: "company", Label: "Company", Type: core.FieldText, Required: true},
+		{Name: "employees", Type: core.FieldNumber},
+		{Name: "customer", Type: core.FieldBoolean},
+		{Name: "plan", Type: core.FieldSelect, Options: []string{"free", "pro"}},
+		{Name: "topics", Type: core.FieldMultiSelect, Options: []string{"go", "web"}},
+	}}
+	file := "email,COMPANY,employees,customer,plan,topics,notes\n" +
+		"ada@example.com,Acme,12,true,pro,\"go, web\",Met at a conference\n" +
+		"bob@example.com,,,,,,\n" +
+		"carol@example.com,Acme,a dozen,,,,\n" +
+		"dan@example.com,Acme,,maybe,,,\n" +
+		"eve@example.com,Acme,,,gold,,\n" +
+		"fred@example.com,Acme,,,,\"go,rust\",\n" +
+		"gina@example.com,Acme,,,,,\n"
+
+	repo := &fakeRepository{}
+	report, err := Import(context.Background(), repo, strings.NewReader(file), Options{List: news})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 || len(report.Errors) != 5 {
+		t.Fatalf("got %+v", report)
+	}
+	for i, want := range []RowError{
+		{Line: 3, Email: "bob@example.com", Reason: "Company is required"},
+		{Line: 4, Email: "carol@example.com", Reason: "employees must be a number"},
+		{Line: 5, Email: "dan@example.com", Reason: "customer must be true or false"},
+		{Line: 6, Email: "eve@example.com", Reason: `"gold" is not a valid choice for plan`},
+		{Line: 7, Email: "fred@example.com", Reason: `"rust" is not a valid choice for topics`},
+	} {
+		if report.Errors[i] != want {
+			t.Errorf("error %d: got %+v, want %+v", i, report.Errors[i], want)
+		}
+	}
+
+	ada := repo.subscriptions[0].Fields
+	if ada["company"] != "Acme" || ada["employees"] != 12.0 || ada["customer"] != true || ada["plan"] != "pro" || ada["notes"] != "Met at a conference" {
+		t.Errorf("got %#v", ada)
+	}
+	if topics, ok := ada["topics"].([]string); !ok || len(topics) != 2 || topics[0] != "go" || topics[1] != "web" {
+		t.Errorf("got topics %#v", ada["topics"])
+	}
+	if gina := repo.subscriptions[1].Fields; len(gina) != 2 || gina["customer"] != false {
+		t.Errorf("got %#v, want the company and the blank boolean", gina)
+	}
+
+	_, err = Import(context.Background(), repo, strings.NewReader("email,employees\nada@example.com,12\n"), Options{List: news})
+	if core.KindOf(err) != core.InvalidInput || !strings.Contains(err.Error(), `no column is mapped to the required field "company"`) {
+		t.Errorf("got %v, want the company column to be required", err)
+	}
+}
+
+func TestImportDryRunWithMapping(t *testing.T) {
+	repo := &fakeRepository{}
+	mapping, err := ParseMapping("Address=email, Who = name ,Plan=plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := "Address\tWho\tPlan\tIgnored\nada@example.com\tAda\tpro\tx\n"
+	report, err := Import(context.Background(), repo, strings.NewReader(file), Options{List: core.List{Slug: "news"}, Mapping: mapping, Comma: CommaFor("export.TSV"), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Imported != 1 || len(repo.subscriptions) != 0 {
+		t.Errorf("got %+v, stored %+v", report, repo.subscriptions)
+	}
+}
+
+func TestImportBatches(t *testing.T) {
+	repo := &fakeRepository{}
+	var file strings.Builder
+	file.WriteString("email\n")
+	for i := 0; i < batchSize+1; i++ {
+		file.WriteString(strings.Repeat("a", i+1) + "@example.com\n")
+	}
+	report, err := Import(context.Background(), repo, strings.NewReader(file.String()), Options{List: core.List{Slug: "news"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != batchSize+1 || repo.lookups != 2 {
+		t.Errorf("imported %d in %d lookups", report.Imported, repo.lookups)
+	}
+}
+
+func TestImportRejectsInvalidFiles(t *testing.T) {
+	for _, c := range []struct {
+		file    string
+		mapping Mapping
+		want    string
+	}{
+		{"", nil, "the file is empty"},
+		{"name,company\nAda,Acme\n", nil, "no column is mapped to the email"},
+		{"email,company\nada@example.com,Acme\n", Mapping{"company": "company"}, "no column is mapped to the email"},
+		{"email,profile.company\nada@example.com,Acme\n", nil, `invalid field name "profile.company"`},
+		{"email,$where\nada@example.com,1\n", nil, `invalid field name "$where"`},
+		{"email,company\nada@example.com,Acme\n", Mapping{"email": "email", "company": "a.b"}, `invalid field name "a.b"`},
+	} {
+		repo := &fakeRepository{}
+		_, err := Import(context.Background(), repo, strings.NewReader(c.file), Options{List: core.List{Slug: "news"}, Mapping: c.mapping})
+		if err == nil || core.KindOf(err) != core.InvalidInput || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: got %v, want an invalid input error containing %q", c.file, err, c.want)
+		}
+		if len(repo.subscriptions) != 0 {
+			t.Errorf("%q: stored %+v", c.file, repo.subscriptions)
+		}
+	}
+
+	// Ignored columns may have any header.
+	_, err := Import(context.Background(), &fakeRepository{}, strings.NewReader("email,a.b\nada@example.com,1\n"), Options{List: core.List{Slug: "news"}, Mapping: Mapping{"email": "email", "a.b": TargetIgnore}})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	for _, spec := range []string{"email", "=email", "Column=", "a=b,c"} {
+		if _, err := ParseMapping(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+	m, err := ParseMapping(" E-mail = email ,, Full Name=name")
+	if err != nil || len(m) != 2 || m["E-mail"] != TargetEmail || m["Full Name"] != TargetName {
+		t.Errorf("got %v, %v", m, err)
+	}
+}
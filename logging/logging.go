@@ -0,0 +1,240 @@
+// Package logging is the application structured logger. It writes one JSON or logfmt record
+// per line, carries request scoped fields through context.Context and redacts email addresses.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Level is the severity of a record.
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name such as "info".
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Supported output formats.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// output is shared by a logger and all the loggers derived from it.
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  int32
+}
+
+// Logger writes structured records. Loggers derived with With share the output and the level.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New returns a logger writing records of the given format (FormatJSON or FormatLogfmt)
+// at or above level to w.
+func New(w io.Writer, format string, level Level) *Logger {
+	return &Logger{out: &output{w: w, format: format, level: int32(level)}}
+}
+
+// Default is the logger used before the configuration is loaded and when a context carries none.
+var Default = New(os.Stderr, FormatJSON, InfoLevel)
+
+// SetLevel changes the minimum level of the logger and of all the loggers sharing its output.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.out.level, int32(level))
+}
+
+// Level returns the minimum level written.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.out.level))
+}
+
+// With returns a logger adding the given key/value pairs to every record.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(DebugLevel, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(InfoLevel, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(WarnLevel, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(ErrorLevel, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.Level() {
+		return
+	}
+
+	keys := []string{"time", "level", "msg"}
+	values := map[string]string{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   RedactEmails(msg),
+	}
+	all := append(append([]interface{}{}, l.fields...), keyvals...)
+	for i := 0; i < len(all); i += 2 {
+		key := fmt.Sprint(all[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(all) {
+			value = all[i+1]
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = RedactEmails(stringify(value))
+	}
+
+	var buf bytes.Buffer
+	if l.out.format == FormatLogfmt {
+		writeLogfmt(&buf, keys, values)
+	} else {
+		writeJSON(&buf, keys, values)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func stringify(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func writeJSON(buf *bytes.Buffer, keys []string, values map[string]string) {
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, _ := json.Marshal(values[k])
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteString("}\n")
+}
+
+func writeLogfmt(buf *bytes.Buffer, keys []string, values map[string]string) {
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(k))
+		buf.WriteByte('=')
+		v := values[k]
+		if v == "" || strings.IndexFunc(v, needsQuote) >= 0 || !utf8.ValidString(v) {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+	buf.WriteByte('\n')
+}
+
+// needsQuote reports whether a logfmt value containing r must be quoted.
+func needsQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == 0x7f || r == utf8.RuneError
+}
+
+// logfmtKey replaces the characters which cannot appear in a logfmt key by underscores.
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if needsQuote(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// Writer returns an io.Writer logging every line written to it at the given level,
+// to plug the logger into APIs expecting a *log.Logger.
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		l.log(level, strings.TrimSpace(string(p)), nil)
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or Default.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default
+}
+
+var emailRE = regexp.MustCompile(`([a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+)@([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)`)
+
+// RedactEmails masks the local part of every email address found in s,
+// keeping its first character: "jake@nypd.gov" becomes "j***@nypd.gov".
+func RedactEmails(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+	return emailRE.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+}
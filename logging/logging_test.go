@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatJSON, InfoLevel).With("request_id", "r1", "user", "ada@example.com")
+	l.Info("subscribed bob@example.com", "list", "news", "err", errors.New(`bad "quote"`), "took", 1500*time.Millisecond, "request_id", "r2", "odd")
+
+	var record map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%s: %s", buf.String(), err)
+	}
+	for key, want := range map[string]string{
+		"level":      "info",
+		"msg":        "subscribed b***@example.com",
+		"request_id": "r2",
+		"user":       "a***@example.com",
+		"list":       "news",
+		"err":        `bad "quote"`,
+		"took":       "1.5s",
+		"odd":        "(MISSING)",
+	} {
+		if record[key] != want {
+			t.Errorf("%s: got %q, want %q", key, record[key], want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, record["time"]); err != nil {
+		t.Error(err)
+	}
+	// The fields keep their order, the overridden ones their first place.
+	if want := `{"time":`; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("got %s, want it to start with %s", buf.String(), want)
+	}
+	if i, j := strings.Index(buf.String(), `"request_id"`), strings.Index(buf.String(), `"list"`); i > j {
+		t.Errorf("got %s, want request_id before list", buf.String())
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	for _, c := range []struct {
+		key   string
+		value interface{}
+		want  string
+	}{
+		{"list", "news", "list=news"},
+		{"empty", "", `empty=""`},
+		{"space", "a b", `space="a b"`},
+		{"equal", "a=b", `equal="a=b"`},
+		{"quote", `say "hi"`, `quote="say \"hi\""`},
+		{"newline", "a\nlevel=error", `newline="a\nlevel=error"`},
+		{"cr", "a\rb", `cr="a\rb"`},
+		{"tab", "a\tb", `tab="a\tb"`},
+		{"control", "a\x00b\x7f", `control="a\x00b\x7f"`},
+		{"invalid", "a\xffb", `invalid="a\xffb"`},
+		{"unicode", "café", "unicode=café"},
+		{"number", 42, "number=42"},
+		{"bad key=x y", "v", "bad_key_x_y=v"},
+		{"", "v", "_=v"},
+	} {
+		var buf bytes.Buffer
+		New(&buf, FormatLogfmt, DebugLevel).Info("m", c.key, c.value)
+		line := strings.TrimSuffix(buf.String(), "\n")
+		if strings.Contains(line, "\n") {
+			t.Errorf("%q: the record spans several lines: %q", c.key, buf.String())
+		}
+		if !strings.HasSuffix(line, " msg=m "+c.want) {
+			t.Errorf("%q: got %q, want it to end with %q", c.key, line, c.want)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatLogfmt, WarnLevel)
+	derived := l.With("component", "mongo")
+	derived.Info("skipped")
+	derived.Warn("written")
+	if strings.Contains(buf.String(), "skipped") || !strings.Contains(buf.String(), "level=warn msg=written component=mongo") {
+		t.Errorf("got %q", buf.String())
+	}
+
+	buf.Reset()
+	l.SetLevel(DebugLevel)
+	derived.Debug("shared")
+	if !strings.Contains(buf.String(), "level=debug msg=shared") {
+		t.Errorf("the level is not shared with the derived loggers: %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel} {
+		if got, err := ParseLevel(s); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) did not fail")
+	}
+	if got := Level(7).String(); got != "level(7)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestWriterAndContext(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatLogfmt, InfoLevel)
+	FromContext(NewContext(context.Background(), l)).Writer(ErrorLevel).Write([]byte("echo: failed\n"))
+	if !strings.Contains(buf.String(), `level=error msg="echo: failed"`) {
+		t.Errorf("got %q", buf.String())
+	}
+	if FromContext(context.Background()) != Default {
+		t.Error("a context without logger does not return Default")
+	}
+}
+
+func TestRedactEmails(t *testing.T) {
+	for s, want := range map[string]string{
+		"no email here":                    "no email here",
+		"to jake@nypd.gov and a@b.io":      "to j***@nypd.gov and a***@b.io",
+		"first.last+tag@mail.example.com!": "f***@mail.example.com!",
+	} {
+		if got := RedactEmails(s); got != want {
+			t.Errorf("RedactEmails(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
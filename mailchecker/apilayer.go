@@ -1,45 +1,126 @@
 package apilayer
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/klebervirgilio/go-echo-basics/config"
 	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
 )
 
-func New(c *config.Config) core.MailChecker {
-	endpoint := fmt.Sprintf(c.MustGetString("mailChecker.url"), c.MustGetString("mailChecker.accessKey"))
-	return APILayer{endpoint}
+func New(c *config.Config) *APILayer {
+	a := &APILayer{}
+	a.Reload(c)
+	return a
 }
 
+// APILayer validates the emails with the APILayer API. Its endpoint, access key and timeout
+// can be changed while it is used, see Reload.
 type APILayer struct {
+	target atomic.Value // target
+}
+
+type target struct {
 	endpoint string
+	client   *http.Client
+}
+
+// Reload applies the `mailChecker.url`, `mailChecker.accessKey` and `mailChecker.timeout` settings
+// to the next validations.
+func (a *APILayer) Reload(c *config.Config) {
+	a.target.Store(target{
+		endpoint: fmt.Sprintf(c.MailChecker.URL, c.MailChecker.AccessKey),
+		client:   &http.Client{Timeout: c.MailChecker.Timeout},
+	})
+}
+
+// apiError is the error payload APILayer sends along a 200 status when a request is rejected.
+type apiError struct {
+	Code int    `json:"code"`
+	Type string `json:"type"`
+	Info string `json:"info"`
+}
+
+// apiErrorKinds maps the documented APILayer error codes to the core error kinds.
+var apiErrorKinds = map[int]core.Kind{
+	104: core.QuotaExceeded,
+	106: core.QuotaExceeded,
+	210: core.InvalidInput,
+	211: core.InvalidInput,
 }
 
-func (a APILayer) Validate(email string) (core.EmailVerificationResponse, error) {
-	url := fmt.Sprintf("%s%s", a.endpoint, email)
-	var resp core.EmailVerificationResponse
+func (a *APILayer) Validate(ctx context.Context, email string) (resp core.EmailVerificationResponse, err error) {
+	ctx, span := tracing.Start(ctx, "apilayer.validate", tracing.KindClient)
+	logger := logging.FromContext(ctx).With("component", "apilayer", "email", email)
+	defer func(start time.Time) {
+		span.RecordError(err)
+		span.SetAttribute("mailchecker.score", resp.Score)
+		span.End()
+
+		if err != nil {
+			logger.Warn("email validation failed", "duration", time.Since(start), "err", err)
+			return
+		}
+		logger.Debug("email validated", "duration", time.Since(start), "score", resp.Score)
+	}(time.Now())
+
+	t := a.target.Load().(target)
+	url := fmt.Sprintf("%s%s", t.endpoint, email)
 
-	request, err := http.NewRequest(http.MethodGet, url, nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return resp, err
 	}
 
-	res, err := http.DefaultClient.Do(request)
+	tracing.Inject(ctx, request.Header)
+	res, err := t.client.Do(request)
 	if err != nil {
-		return resp, err
+		if ctx.Err() != nil {
+			return resp, ctx.Err()
+		}
+		return resp, core.WrapError(core.ProviderUnavailable, err, "Request to email verifier failed")
 	}
+	defer res.Body.Close()
+	span.SetAttribute("http.status_code", res.StatusCode)
+
 	if res.StatusCode != 200 {
-		return resp, errors.New("Request to email verifier failed")
+		return resp, core.Errorf(core.ProviderUnavailable, "Request to email verifier failed with status %d", res.StatusCode)
 	}
 
-	b, _ := ioutil.ReadAll(res.Body)
-	json.NewDecoder(bytes.NewReader(b)).Decode(&resp)
+	var body struct {
+		core.EmailVerificationResponse
+		Error *apiError `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return resp, core.WrapError(core.ProviderUnavailable, err, "Email verifier sent an invalid response")
+	}
+	if body.Error != nil {
+		kind, ok := apiErrorKinds[body.Error.Code]
+		if !ok {
+			kind = core.ProviderUnavailable
+		}
+		return resp, core.Errorf(kind, "Email verifier rejected the request: %s", body.Error.Type)
+	}
 
-	return resp, nil
+	return body.EmailVerificationResponse, nil
+}
+
+// Ping checks APILayer is reachable and accepts the access key by sending a request without email,
+// which the provider answers with an invalid input error without charging the quota.
+func (a *APILayer) Ping(ctx context.Context) error {
+	_, err := a.Validate(ctx, "")
+	if core.KindOf(err) == core.InvalidInput {
+		return nil
+	}
+	if err == nil {
+		return errors.New("Email verifier accepted an empty email")
+	}
+	return err
 }
@@ -0,0 +1,128 @@
+// Package mailer delivers the e-mails sent to the subscribers, through an SMTP server or,
+// during the development, to a local file.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+// New returns the mailer of the `mail.transport` setting: "smtp" sends the messages to the
+// `mail.smtp.addr` server, "file" appends them to `mail.file`.
+func New(cfg *config.Config) (core.Mailer, error) {
+	from, err := mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mail.from address %q", cfg.Mail.From)
+	}
+	switch transport := cfg.Mail.Transport; transport {
+	case "smtp":
+		return &SMTP{
+			Addr:     cfg.Mail.SMTP.Addr,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			From:     from,
+			Timeout:  cfg.Mail.Timeout,
+		}, nil
+	case "file":
+		return Open(cfg.Mail.File, from)
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", transport)
+	}
+}
+
+// File appends the messages to a file, for the development. The file holds the links sent to
+// the subscribers, which grant access to their subscriptions.
+type File struct {
+	mu   sync.Mutex
+	from *mail.Address
+	f    *os.File
+}
+
+// Open opens the file at path, creating it when missing.
+func Open(path string, from *mail.Address) (*File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{from: from, f: f}, nil
+}
+
+// Send appends the message to the file, followed by a blank line.
+func (m *File) Send(ctx context.Context, msg core.Message) error {
+	b, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.f.Write(append(b, "\r\n"...))
+	return err
+}
+
+// Close closes the file.
+func (m *File) Close() error {
+	return m.f.Close()
+}
+
+// format returns msg as a MIME message with a quoted-printable UTF-8 body.
+func format(from *mail.Address, msg core.Message, date time.Time) ([]byte, error) {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, core.Errorf(core.InvalidInput, "invalid recipient %q", msg.To)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	headers := map[string]string{
+		"From":                      from.String(),
+		"To":                        to.String(),
+		"Subject":                   mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":                      date.Format(time.RFC1123Z),
+		"Message-ID":                "<" + hex.EncodeToString(id) + "@" + domain + ">",
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		v := headers[k]
+		if strings.ContainsAny(k, "\r\n:") || strings.ContainsAny(v, "\r\n") {
+			return nil, core.Errorf(core.InvalidInput, "invalid mail header %q", k)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	// The line breaks of the body are written as CRLF.
+	w.Write([]byte(msg.Body))
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,244 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+var from = &mail.Address{Name: "Mailist", Address: "mailist@example.com"}
+
+func TestFormat(t *testing.T) {
+	b, err := format(from, core.Message{
+		To:      "ada@example.com",
+		Subject: "Confirm your subscription to Café",
+		Body:    "Hello Ada,\n\nhttp://localhost:4000/confirm/abc?x=1\n",
+		Headers: map[string]string{"List-Unsubscribe": "<http://localhost:4000/u>"},
+	}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Confirm your subscription to Café" {
+		t.Errorf("got subject %q, %v", subject, err)
+	}
+	for k, want := range map[string]string{
+		"From":             `"Mailist" <mailist@example.com>`,
+		"To":               "<ada@example.com>",
+		"Date":             "Thu, 02 Jan 2020 03:04:05 +0000",
+		"Content-Type":     "text/plain; charset=utf-8",
+		"List-Unsubscribe": "<http://localhost:4000/u>",
+	} {
+		if got := msg.Header.Get(k); got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("got Message-ID %q", id)
+	}
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello Ada,\r\n\r\nhttp://localhost:4000/confirm/abc?x=1\r\n"; string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+	if strings.Contains(string(b), "\n") && strings.Count(string(b), "\n") != strings.Count(string(b), "\r\n") {
+		t.Errorf("the message has bare line feeds: %q", b)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	for _, msg := range []core.Message{
+		{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Hi"},
+		{To: "not an address", Subject: "Hi"},
+		{To: "ada@example.com", Headers: map[string]string{"X-Test": "a\r\nBcc: eve@example.com"}},
+		{To: "ada@example.com", Headers: map[string]string{"Bcc: eve@example.com\r\nX": "a"}},
+	} {
+		if _, err := format(from, msg, time.Now()); core.KindOf(err) != core.InvalidInput {
+			t.Errorf("%+v: got %v, want an invalid input error", msg, err)
+		}
+	}
+	// The subject is encoded rather than rejected.
+	b, err := format(from, core.Message{To: "ada@example.com", Subject: "Hi\r\nBcc: eve@example.com"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "\r\nBcc:") {
+		t.Errorf("the subject injected a header: %q", b)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.Defaults()
+	cfg.Mail.Transport = "file"
+	cfg.Mail.File = filepath.Join(dir, "mail.log")
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"ada@example.com", "bob@example.com"} {
+		if err := m.Send(context.Background(), core.Message{To: to, Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.(*File).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(cfg.Mail.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "To: <ada@example.com>\r\n") != 1 || strings.Count(string(b), "To: <bob@example.com>\r\n") != 1 {
+		t.Errorf("got %q", b)
+	}
+	if info, err := os.Stat(cfg.Mail.File); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got %v, %v, want the file readable by its owner only", info.Mode(), err)
+	}
+}
+
+func TestNewRejectsUnknownTransport(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Mail.Transport = "carrier-pigeon"
+	if _, err := New(cfg); err == nil {
+		t.Error("an unknown transport was accepted")
+	}
+}
+
+// fakeSMTPServer accepts one SMTP session on a local port and sends what it received on the channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var lines []string
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				break
+			}
+			lines = append(lines, line)
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO":
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				tp.PrintfLine("235 accepted")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotLines()
+				lines = append(lines, data...)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				received <- lines
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+		received <- lines
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+	m := &SMTP{
+		// PlainAuth only sends the password in clear text to localhost.
+		Addr:     net.JoinHostPort("localhost", port),
+		Username: "mailist",
+		Password: "secret",
+		From:     from,
+		Timeout:  5 * time.Second,
+	}
+	if err := m.Send(context.Background(), core.Message{To: "Ada <ada@example.com>", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+	session := strings.Join(lines, "\n")
+	for _, want := range []string{"AUTH PLAIN", "MAIL FROM:<mailist@example.com>", "RCPT TO:<ada@example.com>", "Subject: Hi", "Hello", "QUIT"} {
+		if !strings.Contains(session, want) {
+			t.Errorf("got session\n%s\nwant it to contain %q", session, want)
+		}
+	}
+}
+
+func TestSMTPUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	m := &SMTP{Addr: addr, From: from, Timeout: time.Second}
+	err = m.Send(context.Background(), core.Message{To: "ada@example.com", Subject: "Hi", Body: "Hello"})
+	if core.KindOf(err) != core.ProviderUnavailable {
+		t.Errorf("got %v, want a provider unavailable error", err)
+	}
+}
+
+func TestSMTPHonoursContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// The server accepts the connection but never greets.
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			bufio.NewReader(conn).ReadByte()
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	m := &SMTP{Addr: l.Addr().String(), From: from}
+	if err := m.Send(ctx, core.Message{To: "ada@example.com", Subject: "Hi", Body: "Hello"}); err == nil {
+		t.Error("the send did not fail")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the send took %s", d)
+	}
+}
@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
+)
+
+// SMTP sends the messages to an SMTP server, one connection per message. The connection is
+// upgraded with STARTTLS when the server supports it, and authenticated when Username is set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     *mail.Address
+	// Timeout bounds the delivery of a message, besides the deadline of its context.
+	Timeout time.Duration
+}
+
+// Send delivers the message to the server.
+func (m *SMTP) Send(ctx context.Context, msg core.Message) (err error) {
+	ctx, span := tracing.Start(ctx, "smtp.send", tracing.KindClient)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	b, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	if err := m.send(ctx, msg.To, b); err != nil {
+		return core.WrapError(core.ProviderUnavailable, err, "could not send the e-mail")
+	}
+	return nil
+}
+
+func (m *SMTP) send(ctx context.Context, to string, b []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(m.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection but to localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(m.From.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -1,9 +1,198 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/auditlog"
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
 	"github.com/klebervirgilio/go-echo-basics/http"
+	"github.com/klebervirgilio/go-echo-basics/retention"
+	mongorepository "github.com/klebervirgilio/go-echo-basics/storage"
+
+	"github.com/spf13/pflag"
 )
 
+// command is a mailist subcommand, run with the arguments following its name.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":       {"serve", serveCommand},
+	"subscribers": {"subscribers import|export|list|delete [flags]", subscribersCommand},
+	"import":      {"import [flags] file.csv, same as subscribers import", importCommand},
+	"validate":    {"validate [--stale[=30d]] [--email email]... [--list slug]", validateCommand},
+	"users":       {"users create [--reset] name", usersCommand},
+	"migrate":     {"migrate", migrateCommand},
+	"config":      {"config check [--ping] | config print", configCommand},
+	"gdpr":        {"gdpr export|erase email", gdprCommand},
+	"retention":   {"retention [--dry-run]", retentionCommand},
+}
+
+// main runs the command named by the first argument, serving the app when there is none.
 func main() {
-	http.NewServer().Serve()
+	name, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout, commands)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage(os.Stderr, commands)
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the usage line of the commands, sorted by name.
+func usage(w io.Writer, commands map[string]command) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s %s\n", filepath.Base(os.Args[0]), commands[name].usage)
+	}
+}
+
+// subcommand runs the subcommand of a command group, such as "export" in `mailist subscribers export`.
+func subcommand(group string, subcommands map[string]command, args []string) error {
+	if len(args) == 0 {
+		usage(os.Stderr, prefixed(group, subcommands))
+		return fmt.Errorf("%s: missing subcommand", group)
+	}
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		usage(os.Stderr, prefixed(group, subcommands))
+		return fmt.Errorf("%s: unknown subcommand %q", group, args[0])
+	}
+	return cmd.run(args[1:])
+}
+
+func prefixed(group string, subcommands map[string]command) map[string]command {
+	commands := map[string]command{}
+	for name, cmd := range subcommands {
+		commands[name] = command{usage: group + " " + cmd.usage, run: cmd.run}
+	}
+	return commands
+}
+
+// newFlagSet returns the flags of a command, printing usage on -h and on errors.
+func newFlagSet(usage string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(usage, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", filepath.Base(os.Args[0]), usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// openRepository loads the configuration and connects to Mongo.
+func openRepository() (*config.Config, mongorepository.MongoRepo, error) {
+	cfg, err := config.New()
+	if err != nil {
+		return nil, mongorepository.MongoRepo{}, err
+	}
+	repo, err := mongorepository.NewMongoRepo(cfg)
+	return cfg, repo, err
+}
+
+// serveCommand starts the web app, until SIGINT or SIGTERM:
+//
+//	mailist serve
+func serveCommand(args []string) error {
+	flags := newFlagSet("serve")
+	flags.Parse(args)
+
+	server, err := http.Open()
+	if err != nil {
+		return fmt.Errorf("failed to start: %v", err)
+	}
+	if err := server.Serve(); err != nil {
+		return fmt.Errorf("server stopped: %v", err)
+	}
+	return nil
+}
+
+// gdprCommand answers the data subject requests:
+//
+//	mailist gdpr export email   writes everything stored about the email as JSON to the standard output
+//	mailist gdpr erase email    erases the email, leaving a tombstone
+func gdprCommand(args []string) error {
+	if len(args) != 2 || (args[0] != "export" && args[0] != "erase") {
+		return fmt.Errorf("usage: %s gdpr export|erase email", os.Args[0])
+	}
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	audit, err := auditlog.New(cfg, repo)
+	if err != nil {
+		return err
+	}
+	service := gdpr.Service{Subscriptions: repo, Verifications: repo, Audit: audit, Tombstones: repo, Consents: repo, Hasher: core.NewEmailHasher(cfg.GDPR.HashKey)}
+
+	ctx := context.Background()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if args[0] == "export" {
+		bundle, err := service.Export(ctx, args[1], core.ActorSystem, "")
+		if err != nil {
+			return err
+		}
+		return enc.Encode(bundle)
+	}
+	erasure, err := service.Erase(ctx, args[1], core.ActorSystem, "")
+	if err != nil {
+		return err
+	}
+	return enc.Encode(erasure)
+}
+
+// retentionCommand runs a sweep of the retention policy once and prints its report as JSON:
+//
+//	mailist retention [--dry-run]
+func retentionCommand(args []string) error {
+	flags := newFlagSet("retention [--dry-run]")
+	dryRun := flags.Bool("dry-run", false, "report what would be purged without purging")
+	flags.Parse(args)
+
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	audit, err := auditlog.New(cfg, repo)
+	if err != nil {
+		return err
+	}
+	sweeper := retention.Sweeper{Subscriptions: repo, Verifications: repo, Consents: repo, Audit: audit, Policy: retention.NewPolicy(cfg), Hasher: core.NewEmailHasher(cfg.GDPR.HashKey)}
+
+	report, err := sweeper.Sweep(context.Background(), time.Now(), *dryRun)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(report); encErr != nil && err == nil {
+		err = encErr
+	}
+	return err
 }
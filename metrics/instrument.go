@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+var (
+	repositoryOperations = NewCounterVec("mailist_repository_operations_total",
+		"Repository operations by operation and outcome.", "operation", "outcome")
+	repositoryLatency = NewHistogramVec("mailist_repository_operation_duration_seconds",
+		"Repository operations latency.", DefaultBuckets, "operation")
+	mailCheckerCalls = NewCounterVec("mailist_mail_checker_calls_total",
+		"Mail checker calls by outcome.", "outcome")
+	mailCheckerLatency = NewHistogramVec("mailist_mail_checker_call_duration_seconds",
+		"Mail checker calls latency.", DefaultBuckets)
+	mailCheckerScores = NewHistogramVec("mailist_mail_checker_score",
+		"Scores returned by the mail checker.", []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1})
+)
+
+// Outcome is the label value describing how a call ended: "ok" or the kind of the error.
+func Outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return core.KindOf(err).String()
+}
+
+func observe(operation string, start time.Time, err error) {
+	repositoryOperations.With(operation, Outcome(err)).Inc()
+	repositoryLatency.With(operation).Observe(time.Since(start).Seconds())
+}
+
+// InstrumentRepository decorates a repository with operation counts and latencies.
+func InstrumentRepository(next core.Repository) core.Repository {
+	return repository{next}
+}
+
+type repository struct {
+	next core.Repository
+}
+
+func (r repository) FindAll(ctx context.Context, selector map[string]interface{}) (subscriptions []core.Subscription, err error) {
+	defer func(start time.Time) { observe("find_all", start, err) }(time.Now())
+	return r.next.FindAll(ctx, selector)
+}
+
+func (r repository) Each(ctx context.Context, selector map[string]interface{}, fn func(core.Subscription) error) (err error) {
+	defer func(start time.Time) { observe("each", start, err) }(time.Now())
+	return r.next.Each(ctx, selector, fn)
+}
+
+func (r repository) Count(ctx context.Context, selector map[string]interface{}) (n int, err error) {
+	defer func(start time.Time) { observe("count", start, err) }(time.Now())
+	return r.next.Count(ctx, selector)
+}
+
+func (r repository) Tag(ctx context.Context, selector map[string]interface{}, tags ...string) (n int, err error) {
+	defer func(start time.Time) { observe("tag", start, err) }(time.Now())
+	return r.next.Tag(ctx, selector, tags...)
+}
+
+func (r repository) Untag(ctx context.Context, selector map[string]interface{}, tags ...string) (n int, err error) {
+	defer func(start time.Time) { observe("untag", start, err) }(time.Now())
+	return r.next.Untag(ctx, selector, tags...)
+}
+
+func (r repository) Trash(ctx context.Context, selector map[string]interface{}) (n int, err error) {
+	defer func(start time.Time) { observe("trash", start, err) }(time.Now())
+	return r.next.Trash(ctx, selector)
+}
+
+func (r repository) Restore(ctx context.Context, selector map[string]interface{}) (n int, err error) {
+	defer func(start time.Time) { observe("restore", start, err) }(time.Now())
+	return r.next.Restore(ctx, selector)
+}
+
+func (r repository) SetVerification(ctx context.Context, selector map[string]interface{}, resp core.EmailVerificationResponse) (n int, err error) {
+	defer func(start time.Time) { observe("set_verification", start, err) }(time.Now())
+	return r.next.SetVerification(ctx, selector, resp)
+}
+
+func (r repository) Remove(ctx context.Context, selector map[string]interface{}) (err error) {
+	defer func(start time.Time) { observe("remove", start, err) }(time.Now())
+	return r.next.Remove(ctx, selector)
+}
+
+func (r repository) Upsert(ctx context.Context, subscription core.Subscription) (err error) {
+	defer func(start time.Time) { observe("upsert", start, err) }(time.Now())
+	return r.next.Upsert(ctx, subscription)
+}
+
+func (r repository) Ping(ctx context.Context) error {
+	return ping(ctx, r.next)
+}
+
+func (r repository) Close() error {
+	return closeDep(r.next)
+}
+
+// InstrumentMailChecker decorates a mail checker with call outcomes, latencies and scores.
+func InstrumentMailChecker(next core.MailChecker) core.MailChecker {
+	return mailChecker{next}
+}
+
+type mailChecker struct {
+	next core.MailChecker
+}
+
+func (m mailChecker) Validate(ctx context.Context, email string) (core.EmailVerificationResponse, error) {
+	start := time.Now()
+	resp, err := m.next.Validate(ctx, email)
+	mailCheckerCalls.With(Outcome(err)).Inc()
+	mailCheckerLatency.With().Observe(time.Since(start).Seconds())
+	if err == nil {
+		mailCheckerScores.With().Observe(resp.Score)
+	}
+	return resp, err
+}
+
+func (m mailChecker) Ping(ctx context.Context) error {
+	return ping(ctx, m.next)
+}
+
+func (m mailChecker) Close() error {
+	return closeDep(m.next)
+}
+
+// ping and closeDep forward the optional interfaces of the decorated dependencies.
+func ping(ctx context.Context, dep interface{}) error {
+	if p, ok := dep.(core.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func closeDep(dep interface{}) error {
+	if c, ok := dep.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
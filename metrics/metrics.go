@@ -0,0 +1,330 @@
+// Package metrics is a minimal Prometheus instrumentation library: counters, gauges and
+// histograms partitioned by labels, exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used for latencies, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry the package level constructors register to.
+var Default = NewRegistry()
+
+// collector is implemented by every metric family.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds the metric families exposed together.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every registered family in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// family holds the series of a metric, one per combination of label values.
+type family struct {
+	mu     sync.Mutex
+	fqName string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*series
+	newVal func() value
+}
+
+type series struct {
+	labelValues []string
+	value       value
+}
+
+type value interface {
+	write(w io.Writer, name, labels string)
+}
+
+func newFamily(r *Registry, name, help, kind string, labels []string, newVal func() value) *family {
+	f := &family{
+		fqName: name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+		newVal: newVal,
+	}
+	if len(labels) == 0 {
+		// A family without labels has a single series, exposed right away.
+		f.with(nil)
+	}
+	r.register(f)
+	return f
+}
+
+func (f *family) name() string {
+	return f.fqName
+}
+
+func (f *family) with(labelValues []string) value {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.fqName, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...), value: f.newVal()}
+		f.series[key] = s
+	}
+	return s.value
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.fqName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.fqName, f.kind)
+	for _, s := range all {
+		s.value.write(w, f.fqName, formatLabels(f.labels, s.labelValues))
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels == "" {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+}
+
+// CounterVec is a family of monotonically increasing values.
+type CounterVec struct {
+	f *family
+}
+
+// NewCounterVec registers a counter family to the Default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec registers a counter family.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newFamily(r, name, help, "counter", labels, func() value { return &Counter{} })}
+}
+
+// With returns the counter for the given label values.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.f.with(labelValues).(*Counter)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta, which must not be negative, to the counter.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.mu.Lock()
+	c.v += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeSample(w, name, labels, c.v)
+}
+
+// GaugeVec is a family of values that can go up and down.
+type GaugeVec struct {
+	f *family
+}
+
+// NewGaugeVec registers a gauge family to the Default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec registers a gauge family.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newFamily(r, name, help, "gauge", labels, func() value { return &Gauge{} })}
+}
+
+// With returns the gauge for the given label values.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.f.with(labelValues).(*Gauge)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+// Add adds delta, which may be negative, to the gauge.
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.v += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeSample(w, name, labels, g.v)
+}
+
+// HistogramVec is a family of observation distributions.
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogramVec registers a histogram family to the Default registry.
+// The buckets are the inclusive upper bounds, in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec registers a histogram family.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append(append([]float64(nil), buckets...), math.Inf(1))
+	return &HistogramVec{newFamily(r, name, help, "histogram", labels, func() value {
+		return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+	})}
+}
+
+// With returns the histogram for the given label values.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.f.with(labelValues).(*Histogram)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		writeSample(w, name+"_bucket", fmt.Sprintf(`%s%sle="%s"`, labels, sep, formatFloat(bound)), float64(cumulative))
+	}
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(cumulative))
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.\nBy route.", "route", "code")
+	requests.With("subscribe", "302").Inc()
+	requests.With("root", "200").Add(2)
+	r.NewGaugeVec("test_pending", "Pending jobs.").With().Set(-1.5)
+	latency := r.NewHistogramVec("test_latency_seconds", `Latency in \seconds.`, []float64{.1, 1}, "route")
+	latency.With("root").Observe(.1)
+	latency.With("root").Observe(.5)
+	latency.With("root").Observe(3)
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	want := `# HELP test_latency_seconds Latency in \\seconds.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="root",le="0.1"} 1
+test_latency_seconds_bucket{route="root",le="1"} 2
+test_latency_seconds_bucket{route="root",le="+Inf"} 3
+test_latency_seconds_sum{route="root"} 3.6
+test_latency_seconds_count{route="root"} 3
+# HELP test_pending Pending jobs.
+# TYPE test_pending gauge
+test_pending -1.5
+# HELP test_requests_total Requests.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="root",code="200"} 2
+test_requests_total{route="subscribe",code="302"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.", "list").With("a\"b\\c\nd").Inc()
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	if want := `test_total{list="a\"b\\c\nd"} 1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got\n%s\nwant it to contain %s", buf.String(), want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for v, want := range map[float64]string{
+		0:             "0",
+		1e21:          "1e+21",
+		0.25:          "0.25",
+		math.Inf(1):   "+Inf",
+		math.Inf(-1):  "-Inf",
+		-3:            "-3",
+		1234567890123: "1.234567890123e+12",
+	} {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("test_gauge", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	r.NewCounterVec("test_gauge", "Test.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	v := NewRegistry().NewCounterVec("test_total", "Test.", "route")
+	defer func() {
+		if recover() == nil {
+			t.Error("a wrong number of label values did not panic")
+		}
+	}()
+	v.With("a", "b")
+}
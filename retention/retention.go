@@ -0,0 +1,326 @@
+// Package retention purges the personal data kept longer than the configured retention periods.
+package retention
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/config"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/gdpr"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/metrics"
+)
+
+// Rules, as reported and counted by the metrics.
+const (
+	RuleUnconfirmed   = "unconfirmed"
+	RuleUnsubscribed  = "unsubscribed"
+	RuleVerifications = "verifications"
+	RuleTrash         = "trash"
+)
+
+// sampleSize caps the emails listed by a result.
+const sampleSize = 20
+
+// pageSize is the number of audit entries read at once by the unsubscribed rule.
+const pageSize = 500
+
+// leftActions are the audit actions recording that an email left a list: unsubscribed, or its
+// subscription deleted from the trash by an admin or by the sweeper.
+var leftActions = []string{"unsubscribe", "topic.unsubscribe", "subscription.purge", "retention.purge"}
+
+var (
+	purged = metrics.NewCounterVec("mailist_retention_purged_total",
+		"Records purged or anonymised by the retention sweeper, by rule.", "rule")
+	pending = metrics.NewGaugeVec("mailist_retention_pending",
+		"Records matched by the last retention sweep and not purged, by rule.", "rule")
+	sweeps = metrics.NewCounterVec("mailist_retention_sweeps_total",
+		"Retention sweeps by outcome.", "outcome")
+	lastSweep = metrics.NewGaugeVec("mailist_retention_last_sweep_timestamp_seconds",
+		"Time of the last successful retention sweep.")
+)
+
+// Policy is the retention period of each rule. A zero period disables the rule.
+type Policy struct {
+	// Unconfirmed removes the subscriptions still pending after this period.
+	Unconfirmed time.Duration
+	// Unsubscribed anonymises the consents and audit entries of the emails which left all the
+	// lists and have had no activity for this period.
+	Unsubscribed time.Duration
+	// Verifications drops the mail checker results older than this period.
+	Verifications time.Duration
+	// Trash permanently deletes the subscriptions in the trash for this period.
+	Trash time.Duration
+}
+
+// VerificationStore is the verification history, which the sweeper trims.
+type VerificationStore interface {
+	CountVerificationsBefore(ctx context.Context, t time.Time) (int, error)
+	RemoveVerificationsBefore(ctx context.Context, t time.Time) (int, error)
+}
+
+// Result reports what a rule matched, and purged unless the sweep was a dry run.
+type Result struct {
+	Rule    string        `json:"rule"`
+	Period  time.Duration `json:"period"`
+	Cutoff  time.Time     `json:"cutoff"`
+	Matched int           `json:"matched"`
+	Purged  int           `json:"purged"`
+	// Emails are some of the emails matched, when the rule applies to emails.
+	Emails []string `json:"emails,omitempty"`
+}
+
+// Report summarizes a sweep.
+type Report struct {
+	Time    time.Time `json:"time"`
+	DryRun  bool      `json:"dry_run"`
+	Results []Result  `json:"results"`
+}
+
+// Sweeper enforces a retention policy.
+type Sweeper struct {
+	Subscriptions core.Repository
+	Verifications VerificationStore
+	Consents      core.ConsentRepository
+	Audit         gdpr.AuditStore
+	Policy        Policy
+	// Hasher hashes the anonymised emails for their pseudonyms.
+	Hasher core.EmailHasher
+}
+
+// Sweep applies the enabled rules of the policy at now. A dry run only reports what would be
+// purged, and leaves the metrics untouched: the retention page runs one on every view.
+func (s Sweeper) Sweep(ctx context.Context, now time.Time, dryRun bool) (report Report, err error) {
+	defer func() {
+		if dryRun {
+			return
+		}
+		sweeps.With(metrics.Outcome(err)).Inc()
+		if err == nil {
+			lastSweep.With().Set(float64(now.Unix()))
+		}
+	}()
+
+	report = Report{Time: now, DryRun: dryRun}
+	rules := []struct {
+		name   string
+		period time.Duration
+		apply  func(ctx context.Context, r *Result, dryRun bool) error
+	}{
+		{RuleUnconfirmed, s.Policy.Unconfirmed, s.purgeUnconfirmed},
+		{RuleUnsubscribed, s.Policy.Unsubscribed, s.anonymizeUnsubscribed},
+		{RuleVerifications, s.Policy.Verifications, s.dropVerifications},
+		{RuleTrash, s.Policy.Trash, s.emptyTrash},
+	}
+	for _, rule := range rules {
+		if rule.period <= 0 {
+			continue
+		}
+		result := Result{Rule: rule.name, Period: rule.period, Cutoff: now.Add(-rule.period)}
+		err := rule.apply(ctx, &result, dryRun)
+		if !dryRun {
+			purged.With(rule.name).Add(float64(result.Purged))
+			pending.With(rule.name).Set(float64(result.Matched - result.Purged))
+		}
+		report.Results = append(report.Results, result)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Run sweeps every interval until ctx is done, logging the reports with the logger of ctx.
+func (s Sweeper) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := s.Sweep(ctx, time.Now(), dryRun)
+		for _, r := range report.Results {
+			logger.Info("retention sweep", "rule", r.Rule, "cutoff", r.Cutoff, "matched", r.Matched, "purged", r.Purged, "dry_run", dryRun)
+		}
+		if err != nil && ctx.Err() == nil {
+			logger.Error("retention sweep failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Result) sample(email string) {
+	if len(r.Emails) < sampleSize {
+		r.Emails = append(r.Emails, email)
+	}
+}
+
+// purgeUnconfirmed removes the subscriptions pending since before the cutoff.
+func (s Sweeper) purgeUnconfirmed(ctx context.Context, r *Result, dryRun bool) error {
+	return s.purge(ctx, r, map[string]interface{}{
+		"status":       core.StatusPending,
+		"subscribedAt": map[string]interface{}{"$lt": r.Cutoff},
+	}, dryRun)
+}
+
+// emptyTrash removes the subscriptions moved to the trash before the cutoff.
+func (s Sweeper) emptyTrash(ctx context.Context, r *Result, dryRun bool) error {
+	return s.purge(ctx, r, map[string]interface{}{
+		"deletedAt": map[string]interface{}{"$lt": r.Cutoff},
+	}, dryRun)
+}
+
+// purge removes the subscriptions matching selector, recording each removal in the audit trail.
+func (s Sweeper) purge(ctx context.Context, r *Result, selector map[string]interface{}, dryRun bool) error {
+	subscriptions, err := s.Subscriptions.FindAll(ctx, selector)
+	if err != nil {
+		return err
+	}
+	r.Matched = len(subscriptions)
+	for _, sub := range subscriptions {
+		r.sample(sub.Email)
+		if dryRun {
+			continue
+		}
+		if err := s.Subscriptions.Remove(ctx, map[string]interface{}{"list": sub.List, "email": sub.Email}); err != nil {
+			return err
+		}
+		before := map[string]interface{}{"status": sub.Status, "subscribedAt": sub.SubscribedAt}
+		if !sub.DeletedAt.IsZero() {
+			before["deletedAt"] = sub.DeletedAt
+		}
+		err := s.Audit.RecordAudit(ctx, core.AuditEntry{
+			Time:   time.Now(),
+			Actor:  core.ActorSystem,
+			Action: "retention.purge",
+			Target: sub.Email,
+			List:   sub.List,
+			Before: before,
+		})
+		if err != nil {
+			return err
+		}
+		r.Purged++
+	}
+	return nil
+}
+
+// anonymizeUnsubscribed anonymises the consents and audit entries of the emails which left a list
+// before the cutoff, are not subscribed to any list anymore and have had no activity since.
+// The audit trail is read by pages of the entries older than the cutoff, and the emails of each
+// page are checked together. An entry sharing its time with the last one of a page may be
+// skipped, and is left to a later sweep.
+func (s Sweeper) anonymizeUnsubscribed(ctx context.Context, r *Result, dryRun bool) error {
+	seen := map[string]bool{}
+	for _, action := range leftActions {
+		until := r.Cutoff
+		for {
+			entries, err := s.Audit.FindAudit(ctx, core.AuditFilter{Action: action, Until: until, Limit: pageSize})
+			if err != nil {
+				return err
+			}
+			var emails []string
+			for _, entry := range entries {
+				email := core.NormalizeEmail(entry.Target)
+				if !seen[email] && core.ValidEmail(email) {
+					seen[email] = true
+					emails = append(emails, email)
+				}
+			}
+			if err := s.anonymizeInactive(ctx, r, emails, dryRun); err != nil {
+				return err
+			}
+			if len(entries) < pageSize {
+				break
+			}
+			until = entries[len(entries)-1].Time
+		}
+	}
+	return nil
+}
+
+// anonymizeInactive anonymises the emails which are not subscribed to any list and have had no
+// activity since the cutoff.
+func (s Sweeper) anonymizeInactive(ctx context.Context, r *Result, emails []string, dryRun bool) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	active := map[string]bool{}
+	quoted := make([]string, len(emails))
+	for i, email := range emails {
+		quoted[i] = regexp.QuoteMeta(email)
+	}
+	subscribed, err := s.Subscriptions.FindAll(ctx, map[string]interface{}{
+		"email": map[string]interface{}{"$regex": "^(?:" + strings.Join(quoted, "|") + ")$", "$options": "i"},
+	})
+	if err != nil {
+		return err
+	}
+	for _, sub := range subscribed {
+		active[core.NormalizeEmail(sub.Email)] = true
+	}
+	recent, err := s.Audit.FindAudit(ctx, core.AuditFilter{Targets: emails, Since: r.Cutoff})
+	if err != nil {
+		return err
+	}
+	for _, entry := range recent {
+		active[core.NormalizeEmail(entry.Target)] = true
+	}
+
+	for _, email := range emails {
+		if active[email] {
+			continue
+		}
+		r.Matched++
+		r.sample(email)
+		if dryRun {
+			continue
+		}
+		pseudonym := "anonymized:" + s.Hasher.Hash(email)
+		if _, err := s.Consents.AnonymizeConsents(ctx, email, pseudonym); err != nil {
+			return err
+		}
+		if _, err := s.Audit.AnonymizeAudit(ctx, email, pseudonym); err != nil {
+			return err
+		}
+		err = s.Audit.RecordAudit(ctx, core.AuditEntry{
+			Time:   time.Now(),
+			Actor:  core.ActorSystem,
+			Action: "retention.anonymize",
+			Target: pseudonym,
+		})
+		if err != nil {
+			return err
+		}
+		r.Purged++
+	}
+	return nil
+}
+
+// dropVerifications removes the verifications made before the cutoff.
+func (s Sweeper) dropVerifications(ctx context.Context, r *Result, dryRun bool) (err error) {
+	if dryRun {
+		r.Matched, err = s.Verifications.CountVerificationsBefore(ctx, r.Cutoff)
+		return err
+	}
+	r.Purged, err = s.Verifications.RemoveVerificationsBefore(ctx, r.Cutoff)
+	r.Matched = r.Purged
+	return err
+}
+
+// NewPolicy reads the policy from the `retention.unconfirmed`, `retention.unsubscribed`,
+// `retention.verifications` and `retention.trash` settings, durations such as "7d" or "720h".
+// Empty settings disable the rule.
+func NewPolicy(cfg *config.Config) Policy {
+	return Policy{
+		Unconfirmed:   cfg.Retention.Unconfirmed,
+		Unsubscribed:  cfg.Retention.Unsubscribed,
+		Verifications: cfg.Retention.Verifications,
+		Trash:         cfg.Retention.Trash,
+	}
+}
@@ -0,0 +1,227 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/metrics"
+)
+
+var now = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// fakeSubscriptions stores the subscriptions in memory. Only the email regular expressions of
+// the unsubscribed rule are supported.
+type fakeSubscriptions struct {
+	core.Repository
+	subscriptions []core.Subscription
+	finds         int
+}
+
+func (f *fakeSubscriptions) FindAll(ctx context.Context, selector map[string]interface{}) ([]core.Subscription, error) {
+	f.finds++
+	cond := selector["email"].(map[string]interface{})
+	re := regexp.MustCompile("(?i)" + cond["$regex"].(string))
+	var found []core.Subscription
+	for _, s := range f.subscriptions {
+		if re.MatchString(s.Email) {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+type fakeConsents struct {
+	core.ConsentRepository
+	anonymized []string
+}
+
+func (f *fakeConsents) AnonymizeConsents(ctx context.Context, email, pseudonym string) (int, error) {
+	f.anonymized = append(f.anonymized, email)
+	return 1, nil
+}
+
+// fakeAudit stores the audit trail in memory and counts the queries.
+type fakeAudit struct {
+	entries []core.AuditEntry
+	finds   int
+}
+
+func (f *fakeAudit) RecordAudit(ctx context.Context, entry core.AuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAudit) FindAudit(ctx context.Context, filter core.AuditFilter) ([]core.AuditEntry, error) {
+	f.finds++
+	targets := map[string]bool{}
+	for _, t := range filter.Targets {
+		targets[strings.ToLower(t)] = true
+	}
+	var found []core.AuditEntry
+	for _, e := range f.entries {
+		switch {
+		case filter.Action != "" && e.Action != filter.Action:
+		case len(targets) > 0 && !targets[strings.ToLower(e.Target)]:
+		case !filter.Since.IsZero() && e.Time.Before(filter.Since):
+		case !filter.Until.IsZero() && !e.Time.Before(filter.Until):
+		default:
+			found = append(found, e)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Time.After(found[j].Time) })
+	if filter.Limit > 0 && len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, nil
+}
+
+func (f *fakeAudit) AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error) {
+	n := 0
+	for i, e := range f.entries {
+		if strings.EqualFold(e.Target, email) {
+			f.entries[i].Target = pseudonym
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newSweeper(subscriptions *fakeSubscriptions, consents *fakeConsents, audit *fakeAudit) Sweeper {
+	return Sweeper{
+		Subscriptions: subscriptions,
+		Consents:      consents,
+		Audit:         audit,
+		Policy:        Policy{Unsubscribed: 30 * 24 * time.Hour},
+		Hasher:        core.NewEmailHasher("0123456789abcdef0123456789abcdef"),
+	}
+}
+
+func TestAnonymizeUnsubscribed(t *testing.T) {
+	old := now.Add(-60 * 24 * time.Hour)
+	audit := &fakeAudit{}
+	// More unsubscriptions than fit a page, one second apart.
+	const n = 2*pageSize + 10
+	for i := 0; i < n; i++ {
+		audit.entries = append(audit.entries, core.AuditEntry{
+			Time: old.Add(time.Duration(i) * time.Second), Action: "unsubscribe", Target: fmt.Sprintf("User%d@example.com", i),
+		})
+	}
+	audit.entries = append(audit.entries,
+		// Left after the cutoff.
+		core.AuditEntry{Time: now.Add(-time.Hour), Action: "unsubscribe", Target: "recent@example.com"},
+		// Active after the cutoff.
+		core.AuditEntry{Time: now.Add(-time.Hour), Action: "preferences.save", Target: "user1@example.com"},
+	)
+	subscriptions := &fakeSubscriptions{subscriptions: []core.Subscription{{List: "news", Email: "user2@example.com"}}}
+	consents := &fakeConsents{}
+	sweeper := newSweeper(subscriptions, consents, audit)
+
+	report, err := sweeper.Sweep(context.Background(), now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report.Results[0]
+	if r.Rule != RuleUnsubscribed || r.Matched != n-2 || r.Purged != n-2 || len(r.Emails) != sampleSize {
+		t.Errorf("got %+v, want %d emails anonymised", r, n-2)
+	}
+	if len(consents.anonymized) != n-2 {
+		t.Errorf("got %d anonymised consents, want %d", len(consents.anonymized), n-2)
+	}
+	// Three pages of unsubscriptions, each checked with one query for the subscriptions and one
+	// for the recent activity, and one empty page for each other left action.
+	if subscriptions.finds != 3 || audit.finds != 3*2+3 {
+		t.Errorf("got %d subscription and %d audit queries, want 3 and 9", subscriptions.finds, audit.finds)
+	}
+
+	pseudonym := "anonymized:" + sweeper.Hasher.Hash("user0@example.com")
+	for _, e := range audit.entries {
+		switch strings.ToLower(e.Target) {
+		case "user0@example.com":
+			t.Errorf("got entry %+v, want it anonymised as %s", e, pseudonym)
+		case "user1@example.com", "user2@example.com", "recent@example.com", pseudonym:
+		default:
+			if !strings.HasPrefix(e.Target, "anonymized:") {
+				t.Errorf("got entry %+v, want it anonymised", e)
+			}
+		}
+	}
+}
+
+func TestAnonymizeLeftActions(t *testing.T) {
+	for _, action := range []string{"unsubscribe", "topic.unsubscribe", "subscription.purge", "retention.purge"} {
+		audit := &fakeAudit{entries: []core.AuditEntry{
+			{Time: now.Add(-60 * 24 * time.Hour), Action: action, Target: "ada@example.com"},
+		}}
+		consents := &fakeConsents{}
+		sweeper := newSweeper(&fakeSubscriptions{}, consents, audit)
+		report, err := sweeper.Sweep(context.Background(), now, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := report.Results[0]; r.Matched != 1 || r.Purged != 1 || len(consents.anonymized) != 1 {
+			t.Errorf("%s: got %+v, want ada anonymised", action, r)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	audit := &fakeAudit{entries: []core.AuditEntry{
+		{Time: now.Add(-60 * 24 * time.Hour), Action: "unsubscribe", Target: "ada@example.com"},
+	}}
+	consents := &fakeConsents{}
+	sweeper := newSweeper(&fakeSubscriptions{}, consents, audit)
+
+	report, err := sweeper.Sweep(context.Background(), now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := report.Results[0]; !report.DryRun || r.Matched != 1 || r.Purged != 0 || len(consents.anonymized) != 0 {
+		t.Errorf("got %+v, %v, want ada matched but not anonymised", report, consents.anonymized)
+	}
+	if audit.entries[0].Target != "ada@example.com" || len(audit.entries) != 1 {
+		t.Errorf("got entries %+v, want them untouched", audit.entries)
+	}
+}
+
+func TestDryRunLeavesMetrics(t *testing.T) {
+	audit := &fakeAudit{entries: []core.AuditEntry{
+		{Time: now.Add(-60 * 24 * time.Hour), Action: "unsubscribe", Target: "ada@example.com"},
+	}}
+	sweeper := newSweeper(&fakeSubscriptions{}, &fakeConsents{}, audit)
+	exposed := func() string {
+		var b bytes.Buffer
+		metrics.Default.Write(&b)
+		return b.String()
+	}
+
+	before := exposed()
+	if _, err := sweeper.Sweep(context.Background(), now, true); err != nil {
+		t.Fatal(err)
+	}
+	if after := exposed(); after != before {
+		t.Errorf("the dry run changed the metrics from\n%s\nto\n%s", before, after)
+	}
+
+	if _, err := sweeper.Sweep(context.Background(), now.Add(time.Second), false); err != nil {
+		t.Fatal(err)
+	}
+	after := exposed()
+	for _, series := range []string{
+		`mailist_retention_last_sweep_timestamp_seconds 1.592222401e+09`,
+		`mailist_retention_pending{rule="unsubscribed"} 0`,
+	} {
+		if !strings.Contains(after, series) {
+			t.Errorf("got metrics\n%s\nwant %s", after, series)
+		}
+	}
+	if after == before {
+		t.Error("the sweep did not change the metrics")
+	}
+}
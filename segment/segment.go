@@ -0,0 +1,381 @@
+// Package segment implements the filter expressions segments are defined with, such as
+//
+//	valid = true and score > 0.8 and tag = "beta-tester" and subscribed within 30d
+//
+// Expressions combine comparisons with `and`, `or`, `not` and parentheses. A comparison is a
+// field, an operator and a value:
+//
+//	email, name, list, status, suggestion   = != ~ (contains, case insensitive)
+//	tag                                      = !=
+//	valid                                    = !=
+//	score                                    = != > >= < <=
+//	subscribed                               > >= < <= against a date (2006-01-02), within a duration (30d, 12h)
+//	fields.<name>                            = != ~ > >= < <=
+//
+// Values are double quoted strings, numbers, true or false, dates and durations. The tags and
+// emails are stored lowercased, and so are the values they are compared with.
+// Expressions compile to repository selectors.
+package segment
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		r := rune(input[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == '"':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(input) && input[j] != '"'; j++ {
+				if input[j] == '\\' && j+1 < len(input) {
+					j++
+				}
+				b.WriteByte(input[j])
+			}
+			if j >= len(input) {
+				return nil, syntaxError(i, "unterminated string")
+			}
+			tokens = append(tokens, token{tokString, b.String(), i})
+			i = j + 1
+		case strings.ContainsRune("=!<>~", r):
+			j := i + 1
+			if j < len(input) && input[j] == '=' {
+				j++
+			}
+			op := input[i:j]
+			if op == "!" {
+				return nil, syntaxError(i, "unexpected !")
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i = j
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(input) && (unicode.IsLetter(rune(input[j])) || unicode.IsDigit(rune(input[j])) || strings.ContainsRune(".-:", rune(input[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, input[i:j], i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(input) && (unicode.IsLetter(rune(input[j])) || unicode.IsDigit(rune(input[j])) || strings.ContainsRune("_.-", rune(input[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, input[i:j], i})
+			i = j
+		default:
+			return nil, syntaxError(i, fmt.Sprintf("unexpected %q", r))
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(input)}), nil
+}
+
+func syntaxError(pos int, msg string) error {
+	return core.Errorf(core.InvalidInput, "Invalid segment expression at %d: %s", pos+1, msg)
+}
+
+// Node is a parsed expression.
+type Node interface {
+	compile(now time.Time) (map[string]interface{}, error)
+}
+
+type logical struct {
+	op    string // "$and" or "$or"
+	nodes []Node
+}
+
+type negation struct {
+	node Node
+}
+
+type comparison struct {
+	field string
+	op    string
+	value token
+	pos   int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Parse parses an expression.
+func Parse(expression string) (Node, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, syntaxError(0, "empty expression")
+	}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, syntaxError(t.pos, fmt.Sprintf("unexpected %q", t.text))
+	}
+	return node, nil
+}
+
+func (p *parser) or() (Node, error) {
+	return p.logical("or", "$or", p.and)
+}
+
+func (p *parser) and() (Node, error) {
+	return p.logical("and", "$and", p.not)
+}
+
+func (p *parser) logical(word, op string, operand func() (Node, error)) (Node, error) {
+	node, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{node}
+	for p.keyword(word) {
+		node, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return logical{op, nodes}, nil
+}
+
+func (p *parser) not() (Node, error) {
+	if p.keyword("not") {
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return negation{node}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	if t.kind == tokLParen {
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, syntaxError(closing.pos, "missing )")
+		}
+		return node, nil
+	}
+	if t.kind != tokIdent {
+		return nil, syntaxError(t.pos, "expected a field")
+	}
+
+	op := p.next()
+	if op.kind == tokIdent && strings.EqualFold(op.text, "within") {
+		op = token{tokOp, "within", op.pos}
+	}
+	if op.kind != tokOp {
+		return nil, syntaxError(op.pos, "expected an operator after "+t.text)
+	}
+	value := p.next()
+	if value.kind != tokString && value.kind != tokNumber && value.kind != tokIdent {
+		return nil, syntaxError(value.pos, "expected a value after "+op.text)
+	}
+	return comparison{field: t.text, op: op.text, value: value, pos: t.pos}, nil
+}
+
+func (l logical) compile(now time.Time) (map[string]interface{}, error) {
+	clauses := make([]interface{}, len(l.nodes))
+	for i, n := range l.nodes {
+		c, err := n.compile(now)
+		if err != nil {
+			return nil, err
+		}
+		clauses[i] = c
+	}
+	return map[string]interface{}{l.op: clauses}, nil
+}
+
+func (n negation) compile(now time.Time) (map[string]interface{}, error) {
+	c, err := n.node.compile(now)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"$nor": []interface{}{c}}, nil
+}
+
+// fieldKinds maps the expression fields to their document path and value type.
+var fieldKinds = map[string]struct{ path, kind string }{
+	"email":      {"email", "email"},
+	"name":       {"fullName", "string"},
+	"list":       {"list", "string"},
+	"status":     {"status", "string"},
+	"suggestion": {"emailVerificationResponse.suggestion", "string"},
+	"tag":        {"tags", "tag"},
+	"valid":      {"emailVerificationResponse.valid", "bool"},
+	"score":      {"emailVerificationResponse.score", "number"},
+	"subscribed": {"subscribedAt", "date"},
+}
+
+var mongoOps = map[string]string{"=": "$eq", "!=": "$ne", ">": "$gt", ">=": "$gte", "<": "$lt", "<=": "$lte"}
+
+var allowedOps = map[string]string{
+	"string": "= != ~",
+	"email":  "= != ~",
+	"tag":    "= !=",
+	"bool":   "= !=",
+	"number": "= != > >= < <=",
+	"date":   "> >= < <= within",
+	"any":    "= != ~ > >= < <=",
+}
+
+func (c comparison) compile(now time.Time) (map[string]interface{}, error) {
+	path, kind := "", ""
+	if f, ok := fieldKinds[strings.ToLower(c.field)]; ok {
+		path, kind = f.path, f.kind
+	} else if strings.HasPrefix(c.field, "fields.") && len(c.field) > len("fields.") {
+		path, kind = c.field, "any"
+	} else {
+		return nil, syntaxError(c.pos, "unknown field "+c.field)
+	}
+	if !strings.Contains(" "+allowedOps[kind]+" ", " "+c.op+" ") {
+		return nil, syntaxError(c.pos, fmt.Sprintf("%s cannot be compared with %s", c.field, c.op))
+	}
+
+	value, err := c.parseValue(kind, now)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.op {
+	case "~":
+		return map[string]interface{}{path: map[string]interface{}{"$regex": regexp.QuoteMeta(fmt.Sprint(value)), "$options": "i"}}, nil
+	case "within":
+		return map[string]interface{}{path: map[string]interface{}{"$gte": value}}, nil
+	}
+	return map[string]interface{}{path: map[string]interface{}{mongoOps[c.op]: value}}, nil
+}
+
+func (c comparison) parseValue(kind string, now time.Time) (interface{}, error) {
+	text := c.value.text
+	switch kind {
+	case "email", "tag":
+		return strings.ToLower(text), nil
+	case "bool":
+		b, err := strconv.ParseBool(text)
+		if err != nil || c.value.kind == tokString {
+			return nil, syntaxError(c.value.pos, "expected true or false")
+		}
+		return b, nil
+	case "number":
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil || c.value.kind == tokString {
+			return nil, syntaxError(c.value.pos, "expected a number")
+		}
+		return n, nil
+	case "date":
+		if c.op == "within" {
+			d, err := ParseDuration(text)
+			if err != nil {
+				return nil, syntaxError(c.value.pos, err.Error())
+			}
+			return now.Add(-d), nil
+		}
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, syntaxError(c.value.pos, "expected a date such as 2006-01-02")
+		}
+		return t, nil
+	case "any":
+		if c.value.kind == tokNumber {
+			if n, err := strconv.ParseFloat(text, 64); err == nil {
+				return n, nil
+			}
+		}
+	}
+	return text, nil
+}
+
+// ParseDuration is like time.ParseDuration but also accepts days, such as "30d".
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// Compile parses an expression and returns the matching repository selector.
+// Relative dates are computed from now.
+func Compile(expression string, now time.Time) (map[string]interface{}, error) {
+	node, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	return node.compile(now)
+}
@@ -0,0 +1,141 @@
+package segment
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+)
+
+var now = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// selector returns the JSON form of a selector, whose maps are sorted by key.
+func selector(t *testing.T, s map[string]interface{}) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompile(t *testing.T) {
+	for _, c := range []struct {
+		expression string
+		want       string
+	}{
+		// Fields and operators.
+		{`email = "Ada@Example.com"`, `{"email":{"$eq":"ada@example.com"}}`},
+		{`email ~ "example"`, `{"email":{"$options":"i","$regex":"example"}}`},
+		{`name ~ "a.b(c)"`, `{"fullName":{"$options":"i","$regex":"a\\.b\\(c\\)"}}`},
+		{`list != news`, `{"list":{"$ne":"news"}}`},
+		{`status = "pending"`, `{"status":{"$eq":"pending"}}`},
+		{`suggestion = ""`, `{"emailVerificationResponse.suggestion":{"$eq":""}}`},
+		{`tag = "Beta-Tester"`, `{"tags":{"$eq":"beta-tester"}}`},
+		{`valid = true`, `{"emailVerificationResponse.valid":{"$eq":true}}`},
+		{`valid != FALSE`, `{"emailVerificationResponse.valid":{"$ne":false}}`},
+		{`score >= 0.8`, `{"emailVerificationResponse.score":{"$gte":0.8}}`},
+		{`score<-1`, `{"emailVerificationResponse.score":{"$lt":-1}}`},
+		{`subscribed < 2020-01-02`, `{"subscribedAt":{"$lt":"2020-01-02T00:00:00Z"}}`},
+		{`subscribed within 30d`, `{"subscribedAt":{"$gte":"2020-05-16T12:00:00Z"}}`},
+		{`subscribed WITHIN 12h`, `{"subscribedAt":{"$gte":"2020-06-15T00:00:00Z"}}`},
+		{`fields.company = "Acme"`, `{"fields.company":{"$eq":"Acme"}}`},
+		{`fields.seats > 10`, `{"fields.seats":{"$gt":10}}`},
+		{`fields.zip = "01234"`, `{"fields.zip":{"$eq":"01234"}}`},
+		{`fields.plan ~ pro`, `{"fields.plan":{"$options":"i","$regex":"pro"}}`},
+		{`NAME = "Ada"`, `{"fullName":{"$eq":"Ada"}}`},
+
+		// Quoting.
+		{`name = "say \"hi\""`, `{"fullName":{"$eq":"say \"hi\""}}`},
+		{`name = "back\\slash"`, `{"fullName":{"$eq":"back\\slash"}}`},
+		{`name = "and or not ( )"`, `{"fullName":{"$eq":"and or not ( )"}}`},
+
+		// Precedence: not binds tighter than and, which binds tighter than or.
+		{`valid = true and score > 0.5 or tag = vip`,
+			`{"$or":[{"$and":[{"emailVerificationResponse.valid":{"$eq":true}},{"emailVerificationResponse.score":{"$gt":0.5}}]},{"tags":{"$eq":"vip"}}]}`},
+		{`tag = vip or valid = true and score > 0.5`,
+			`{"$or":[{"tags":{"$eq":"vip"}},{"$and":[{"emailVerificationResponse.valid":{"$eq":true}},{"emailVerificationResponse.score":{"$gt":0.5}}]}]}`},
+		{`(tag = vip or tag = beta) and valid = true`,
+			`{"$and":[{"$or":[{"tags":{"$eq":"vip"}},{"tags":{"$eq":"beta"}}]},{"emailVerificationResponse.valid":{"$eq":true}}]}`},
+		{`not tag = vip and valid = true`,
+			`{"$and":[{"$nor":[{"tags":{"$eq":"vip"}}]},{"emailVerificationResponse.valid":{"$eq":true}}]}`},
+		{`not (tag = vip and valid = true)`,
+			`{"$nor":[{"$and":[{"tags":{"$eq":"vip"}},{"emailVerificationResponse.valid":{"$eq":true}}]}]}`},
+		{`not not tag = vip`, `{"$nor":[{"$nor":[{"tags":{"$eq":"vip"}}]}]}`},
+		{`tag = a AND tag = b And tag = c`, `{"$and":[{"tags":{"$eq":"a"}},{"tags":{"$eq":"b"}},{"tags":{"$eq":"c"}}]}`},
+		{`((tag = a))`, `{"tags":{"$eq":"a"}}`},
+	} {
+		got, err := Compile(c.expression, now)
+		if err != nil {
+			t.Errorf("%s: %s", c.expression, err)
+			continue
+		}
+		if s := selector(t, got); s != c.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", c.expression, s, c.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, c := range []struct {
+		expression string
+		want       string
+	}{
+		{``, "at 1: empty expression"},
+		{`   `, "at 1: empty expression"},
+		{`name = "ada`, "at 8: unterminated string"},
+		{`name ! "ada"`, "at 6: unexpected !"},
+		{`name = 'ada'`, `at 8: unexpected '\''`},
+		{`tag = vip and`, "at 14: expected a field"},
+		{`tag = vip vip`, `at 11: unexpected "vip"`},
+		{`(tag = vip`, "at 11: missing )"},
+		{`tag = vip)`, `at 10: unexpected ")"`},
+		{`tag vip`, "at 5: expected an operator after tag"},
+		{`tag =`, "at 6: expected a value after ="},
+		{`tag = (`, "at 7: expected a value after ="},
+		{`= vip`, "at 1: expected a field"},
+		{`nope = 1`, "at 1: unknown field nope"},
+		{`fields. = 1`, "at 1: unknown field fields."},
+		{`valid and tag = vip`, "at 7: expected an operator after valid"},
+		{`tag ~ vip`, "at 1: tag cannot be compared with ~"},
+		{`score ~ 1`, "at 1: score cannot be compared with ~"},
+		{`subscribed = 2020-01-02`, "at 1: subscribed cannot be compared with ="},
+		{`email within 1d`, "at 1: email cannot be compared with within"},
+		{`valid = yes`, "at 9: expected true or false"},
+		{`valid = "true"`, "at 9: expected true or false"},
+		{`score > high`, "at 9: expected a number"},
+		{`score > "1"`, "at 9: expected a number"},
+		{`subscribed > 2020-13-01`, "at 14: expected a date such as 2006-01-02"},
+		{`subscribed within 30`, `at 19: invalid duration "30"`},
+		{`subscribed within -1d`, `at 19: invalid duration "-1d"`},
+		{`tag = a and (nope = 1)`, "at 14: unknown field nope"},
+	} {
+		_, err := Compile(c.expression, now)
+		if err == nil {
+			t.Errorf("%q: compiled", c.expression)
+			continue
+		}
+		if core.KindOf(err) != core.InvalidInput || !strings.HasSuffix(err.Error(), c.want) {
+			t.Errorf("%q: got %v, want an invalid input error ending with %q", c.expression, err, c.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0d":    0,
+		"30d":   30 * 24 * time.Hour,
+		"12h":   12 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	} {
+		if got, err := ParseDuration(s); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "d", "1.5d", "-2d", "-1h", "1w", "thirty"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q) did not fail", s)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package mongorepository
+
+import (
+	"context"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+)
+
+func (m MongoRepo) RecordAudit(ctx context.Context, entry core.AuditEntry) error {
+	return translate(m.audit.Run(ctx, "record_audit", func(coll *mgo.Collection) error {
+		return coll.Insert(entry)
+	}), "audit entry")
+}
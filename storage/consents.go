@@ -0,0 +1,50 @@
+package mongorepository
+
+import (
+	"context"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func (m MongoRepo) RecordConsent(ctx context.Context, consent core.Consent) error {
+	return translate(m.consents.Run(ctx, "record_consent", func(coll *mgo.Collection) error {
+		return coll.Insert(consent)
+	}), "consent")
+}
+
+func (m MongoRepo) ConfirmConsent(ctx context.Context, list, email string, at time.Time) error {
+	return translate(m.consents.Run(ctx, "confirm_consent", func(coll *mgo.Collection) error {
+		_, err := coll.UpdateAll(
+			bson.M{"list": list, "email": email, "confirmedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"confirmedAt": at}},
+		)
+		return err
+	}), "consent")
+}
+
+func (m MongoRepo) FindConsents(ctx context.Context, email string) ([]core.Consent, error) {
+	var consents []core.Consent
+	err := m.consents.Run(ctx, "find_consents", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"email": emailSelector(email)}).Sort("-givenAt").All(&consents)
+	})
+	return consents, translate(err, "consent")
+}
+
+func (m MongoRepo) AnonymizeConsents(ctx context.Context, email, pseudonym string) (int, error) {
+	var info *mgo.ChangeInfo
+	err := m.consents.Run(ctx, "anonymize_consents", func(coll *mgo.Collection) (err error) {
+		info, err = coll.UpdateAll(
+			bson.M{"email": emailSelector(email)},
+			bson.M{"$set": bson.M{"email": pseudonym}, "$unset": bson.M{"ip": "", "userAgent": ""}},
+		)
+		return err
+	})
+	if err != nil {
+		return 0, translate(err, "consent")
+	}
+	return info.Updated, nil
+}
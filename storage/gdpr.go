@@ -0,0 +1,162 @@
+package mongorepository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// emailSelector matches an email case insensitively.
+func emailSelector(email string) bson.M {
+	return emailsSelector([]string{email})
+}
+
+// emailsSelector matches any of the emails case insensitively.
+func emailsSelector(emails []string) bson.M {
+	quoted := make([]string, len(emails))
+	for i, email := range emails {
+		quoted[i] = regexp.QuoteMeta(email)
+	}
+	return bson.M{"$regex": "^(?:" + strings.Join(quoted, "|") + ")$", "$options": "i"}
+}
+
+func (m MongoRepo) RecordVerification(ctx context.Context, v core.Verification) error {
+	return translate(m.verifications.Run(ctx, "record_verification", func(coll *mgo.Collection) error {
+		return coll.Insert(v)
+	}), "verification")
+}
+
+func (m MongoRepo) FindVerifications(ctx context.Context, email string) ([]core.Verification, error) {
+	var verifications []core.Verification
+	err := m.verifications.Run(ctx, "find_verifications", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"email": emailSelector(email)}).Sort("-time").All(&verifications)
+	})
+	return verifications, translate(err, "verification")
+}
+
+func (m MongoRepo) RemoveVerifications(ctx context.Context, email string) (int, error) {
+	var info *mgo.ChangeInfo
+	err := m.verifications.Run(ctx, "remove_verifications", func(coll *mgo.Collection) (err error) {
+		info, err = coll.RemoveAll(bson.M{"email": emailSelector(email)})
+		return err
+	})
+	if err != nil {
+		return 0, translate(err, "verification")
+	}
+	return info.Removed, nil
+}
+
+func (m MongoRepo) CountVerificationsBefore(ctx context.Context, t time.Time) (int, error) {
+	var n int
+	err := m.verifications.Run(ctx, "count_verifications", func(coll *mgo.Collection) (err error) {
+		n, err = coll.Find(bson.M{"time": bson.M{"$lt": t}}).Count()
+		return err
+	})
+	return n, translate(err, "verification")
+}
+
+func (m MongoRepo) RemoveVerificationsBefore(ctx context.Context, t time.Time) (int, error) {
+	var info *mgo.ChangeInfo
+	err := m.verifications.Run(ctx, "remove_verifications", func(coll *mgo.Collection) (err error) {
+		info, err = coll.RemoveAll(bson.M{"time": bson.M{"$lt": t}})
+		return err
+	})
+	if err != nil {
+		return 0, translate(err, "verification")
+	}
+	return info.Removed, nil
+}
+
+func (m MongoRepo) AddTombstone(ctx context.Context, tombstone core.Tombstone) error {
+	return translate(m.tombstones.Run(ctx, "add_tombstone", func(coll *mgo.Collection) error {
+		_, err := coll.Upsert(bson.M{"hash": tombstone.Hash}, tombstone)
+		return err
+	}), "tombstone")
+}
+
+func (m MongoRepo) ErasedEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	byHash := map[string]string{}
+	hashes := make([]string, 0, 2*len(emails))
+	for _, email := range emails {
+		for _, h := range []string{m.hasher.Hash(email), legacyHash(email)} {
+			byHash[h] = email
+			hashes = append(hashes, h)
+		}
+	}
+
+	var tombstones []core.Tombstone
+	err := m.tombstones.Run(ctx, "erased_emails", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"hash": bson.M{"$in": hashes}}).All(&tombstones)
+	})
+	if err != nil {
+		return nil, translate(err, "tombstone")
+	}
+	erased := map[string]bool{}
+	for _, t := range tombstones {
+		erased[byHash[t.Hash]] = true
+	}
+	return erased, nil
+}
+
+// legacyHash is the unkeyed hash of the tombstones left before the hashes were keyed by
+// gdpr.hashKey, which are still honoured.
+func legacyHash(email string) string {
+	sum := sha256.Sum256([]byte(core.NormalizeEmail(email)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m MongoRepo) FindAudit(ctx context.Context, filter core.AuditFilter) ([]core.AuditEntry, error) {
+	selector := bson.M{}
+	if filter.Actor != "" {
+		selector["actor"] = filter.Actor
+	}
+	if filter.Action != "" {
+		selector["action"] = filter.Action
+	}
+	if filter.Target != "" {
+		selector["target"] = emailSelector(filter.Target)
+	}
+	if len(filter.Targets) > 0 {
+		selector["$and"] = []bson.M{{"target": emailsSelector(filter.Targets)}}
+	}
+	period := bson.M{}
+	if !filter.Since.IsZero() {
+		period["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		period["$lt"] = filter.Until
+	}
+	if len(period) > 0 {
+		selector["time"] = period
+	}
+
+	var entries []core.AuditEntry
+	err := m.audit.Run(ctx, "find_audit", func(coll *mgo.Collection) error {
+		return coll.Find(selector).Sort("-time").Limit(filter.Limit).All(&entries)
+	})
+	return entries, translate(err, "audit entry")
+}
+
+// AnonymizeAudit replaces the email by pseudonym in the audit entries targeting it, and drops their states.
+func (m MongoRepo) AnonymizeAudit(ctx context.Context, email, pseudonym string) (int, error) {
+	var info *mgo.ChangeInfo
+	err := m.audit.Run(ctx, "anonymize_audit", func(coll *mgo.Collection) (err error) {
+		info, err = coll.UpdateAll(
+			bson.M{"target": emailSelector(email)},
+			bson.M{"$set": bson.M{"target": pseudonym}, "$unset": bson.M{"before": "", "after": ""}},
+		)
+		return err
+	})
+	if err != nil {
+		return 0, translate(err, "audit entry")
+	}
+	return info.Updated, nil
+}
@@ -0,0 +1,33 @@
+package mongorepository
+
+import (
+	"context"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func (m MongoRepo) FindLists(ctx context.Context) ([]core.List, error) {
+	var lists []core.List
+	err := m.lists.Run(ctx, "find_lists", func(coll *mgo.Collection) error {
+		return coll.Find(nil).Sort("name").All(&lists)
+	})
+	return lists, translate(err, "list")
+}
+
+func (m MongoRepo) FindList(ctx context.Context, slug string) (core.List, error) {
+	var list core.List
+	err := m.lists.Run(ctx, "find_list", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"slug": slug}).One(&list)
+	})
+	return list, translate(err, "list")
+}
+
+func (m MongoRepo) UpsertList(ctx context.Context, list core.List) error {
+	return translate(m.lists.Run(ctx, "upsert_list", func(coll *mgo.Collection) error {
+		_, err := coll.Upsert(bson.M{"slug": list.Slug}, list)
+		return err
+	}), "list")
+}
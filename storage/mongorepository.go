@@ -1,50 +1,229 @@
 package mongorepository
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"time"
 
 	"github.com/klebervirgilio/go-echo-basics/config"
 	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/logging"
+	"github.com/klebervirgilio/go-echo-basics/tracing"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 )
 
-func NewMongoRepo(config *config.Config) core.Repository {
-	client := newMongoClient(
-		config.GetString("mongo.uri"),
-		config.GetString("mongo.database.name"),
-		config.GetString("mongo.collection.name"),
+func NewMongoRepo(config *config.Config) (MongoRepo, error) {
+	client, err := newMongoClient(
+		config.Mongo.URI,
+		config.Mongo.Database.Name,
+		config.Mongo.Collection.Name,
+		config.Mongo.Timeout,
 	)
-	return MongoRepo{client}
+	if err != nil {
+		return MongoRepo{}, err
+	}
+	return MongoRepo{
+		client:   client,
+		lists:    client.collection(config.Mongo.Lists.Collection.Name),
+		segments: client.collection(config.Mongo.Segments.Collection.Name),
+		audit:    client.collection(config.Mongo.Audit.Collection.Name),
+
+		verifications: client.collection(config.Mongo.Verifications.Collection.Name),
+		tombstones:    client.collection(config.Mongo.Tombstones.Collection.Name),
+		consents:      client.collection(config.Mongo.Consents.Collection.Name),
+		users:         client.collection(config.Mongo.Users.Collection.Name),
+		hasher:        core.NewEmailHasher(config.GDPR.HashKey),
+	}, nil
 }
 
-// MongoRepo its a concrete implementation of SubscriptionRepo.
+// MongoRepo its a concrete implementation of all the core repositories.
 type MongoRepo struct {
-	client MongoClient
-}
+	client   MongoClient
+	lists    MongoClient
+	segments MongoClient
+	audit    MongoClient
 
-func (m MongoRepo) FindAll(selector map[string]interface{}) ([]core.Subscription, error) {
-	coll, cs := m.client.GetSession()
-	defer cs()
+	verifications MongoClient
+	tombstones    MongoClient
+	consents      MongoClient
+	users         MongoClient
 
+	hasher core.EmailHasher
+}
+
+func (m MongoRepo) FindAll(ctx context.Context, selector map[string]interface{}) ([]core.Subscription, error) {
 	var subscriptions []core.Subscription
-	err := coll.Find(selector).All(&subscriptions)
+	err := m.client.Run(ctx, "find_all", func(coll *mgo.Collection) error {
+		return coll.Find(live(selector)).All(&subscriptions)
+	})
+	return subscriptions, translate(err, "subscription")
+}
+
+func (m MongoRepo) Each(ctx context.Context, selector map[string]interface{}, fn func(core.Subscription) error) error {
+	return translate(m.client.Run(ctx, "each", func(coll *mgo.Collection) error {
+		iter := coll.Find(live(selector)).Iter()
+		var subscription core.Subscription
+		for iter.Next(&subscription) {
+			err := fn(subscription)
+			if err == nil {
+				// The next document may need another batch.
+				err = m.client.checkpoint(ctx, coll)
+			}
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			subscription = core.Subscription{}
+		}
+		return iter.Close()
+	}), "subscription")
+}
+
+func (m MongoRepo) Count(ctx context.Context, selector map[string]interface{}) (int, error) {
+	var n int
+	err := m.client.Run(ctx, "count", func(coll *mgo.Collection) (err error) {
+		n, err = coll.Find(live(selector)).Count()
+		return err
+	})
+	return n, translate(err, "subscription")
+}
+
+func (m MongoRepo) Tag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error) {
+	return m.updateAll(ctx, "tag", selector, bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}})
+}
+
+func (m MongoRepo) Untag(ctx context.Context, selector map[string]interface{}, tags ...string) (int, error) {
+	return m.updateAll(ctx, "untag", selector, bson.M{"$pullAll": bson.M{"tags": tags}})
+}
+
+func (m MongoRepo) Trash(ctx context.Context, selector map[string]interface{}) (int, error) {
+	return m.updateAll(ctx, "trash", selector, bson.M{"$set": bson.M{"deletedAt": time.Now()}})
+}
+
+func (m MongoRepo) Restore(ctx context.Context, selector map[string]interface{}) (int, error) {
+	return m.updateAll(ctx, "restore", core.InTrash(selector), bson.M{"$unset": bson.M{"deletedAt": ""}})
+}
+
+func (m MongoRepo) SetVerification(ctx context.Context, selector map[string]interface{}, resp core.EmailVerificationResponse) (int, error) {
+	return m.updateAll(ctx, "set_verification", selector, bson.M{"$set": bson.M{"emailVerificationResponse": resp}})
+}
+
+func (m MongoRepo) updateAll(ctx context.Context, op string, selector map[string]interface{}, update bson.M) (int, error) {
+	var info *mgo.ChangeInfo
+	err := m.client.Run(ctx, op, func(coll *mgo.Collection) (err error) {
+		info, err = coll.UpdateAll(live(selector), update)
+		return err
+	})
+	if err != nil {
+		return 0, translate(err, "subscription")
+	}
+	return info.Updated, nil
+}
+
+func (m MongoRepo) Remove(ctx context.Context, selector map[string]interface{}) error {
+	return translate(m.client.Run(ctx, "remove", func(coll *mgo.Collection) error {
+		return coll.Remove(selector)
+	}), "subscription")
+}
 
-	return subscriptions, err
+func (m MongoRepo) Upsert(ctx context.Context, subscription core.Subscription) error {
+	return translate(m.client.Run(ctx, "upsert", func(coll *mgo.Collection) error {
+		_, err := coll.Upsert(bson.M{"list": subscription.List, "email": subscription.Email}, subscription)
+		return err
+	}), "subscription")
 }
 
-func (m MongoRepo) Remove(selector map[string]interface{}) error {
-	coll, cs := m.client.GetSession()
-	defer cs()
+// Ping checks the Mongo server is reachable.
+func (m MongoRepo) Ping(ctx context.Context) error {
+	return m.client.Run(ctx, "ping", func(coll *mgo.Collection) error {
+		return coll.Database.Session.Ping()
+	})
+}
+
+// Close releases the Mongo session.
+func (m MongoRepo) Close() error {
+	m.client.session.Close()
+	return nil
+}
+
+// Migrate brings the collections up to date: the subscriptions created before the lists existed
+// are moved to defaultList, and the indexes are created.
+func (m MongoRepo) Migrate(ctx context.Context, defaultList string) error {
+	err := m.client.Run(ctx, "migrate", func(coll *mgo.Collection) error {
+		_, err := coll.UpdateAll(
+			bson.M{"list": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"list": defaultList, "status": core.StatusConfirmed}},
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		client  MongoClient
+		indexes []mgo.Index
+	}{
+		{m.client, []mgo.Index{
+			{Key: []string{"list", "email"}, Unique: true},
+			{Key: []string{"tags"}},
+			{Key: []string{"status", "subscribedAt"}},
+			{Key: []string{"deletedAt"}, Sparse: true},
+			{Key: []string{"token"}, Sparse: true},
+		}},
+		{m.lists, []mgo.Index{{Key: []string{"slug"}, Unique: true}}},
+		{m.segments, []mgo.Index{{Key: []string{"slug"}, Unique: true}}},
+		{m.audit, []mgo.Index{{Key: []string{"target", "-time"}}, {Key: []string{"action", "-time"}}, {Key: []string{"-time"}}}},
+		{m.verifications, []mgo.Index{{Key: []string{"email", "-time"}}, {Key: []string{"time"}}}},
+		{m.tombstones, []mgo.Index{{Key: []string{"hash"}, Unique: true}}},
+		{m.consents, []mgo.Index{{Key: []string{"email", "list", "-givenAt"}}}},
+		{m.users, []mgo.Index{{Key: []string{"name"}, Unique: true}}},
+	} {
+		if err := c.client.ensureIndexes(ctx, c.indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return coll.Remove(selector)
+// ensureIndexes creates the indexes of the collection, one at a time.
+func (m MongoClient) ensureIndexes(ctx context.Context, indexes []mgo.Index) error {
+	return m.Run(ctx, "migrate", func(coll *mgo.Collection) error {
+		for _, index := range indexes {
+			if err := m.checkpoint(ctx, coll); err != nil {
+				return err
+			}
+			if err := coll.EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (m MongoRepo) Upsert(subscription core.Subscription) error {
-	coll, cs := m.client.GetSession()
-	defer cs()
-	_, err := coll.Upsert(bson.M{"email": subscription.Email}, subscription)
+// live excludes the subscriptions in the trash from selector, unless it has a "deletedAt" condition.
+func live(selector map[string]interface{}) map[string]interface{} {
+	if _, ok := selector["deletedAt"]; ok {
+		return selector
+	}
+	scoped := map[string]interface{}{"deletedAt": bson.M{"$exists": false}}
+	for k, v := range selector {
+		scoped[k] = v
+	}
+	return scoped
+}
+
+// translate converts the mgo errors into the core domain errors.
+func translate(err error, resource string) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == mgo.ErrNotFound:
+		return core.WrapError(core.NotFound, err, "%s not found", resource)
+	case mgo.IsDup(err):
+		return core.WrapError(core.AlreadyExists, err, "%s already exists", resource)
+	}
 	return err
 }
 
@@ -52,22 +231,86 @@ func (m MongoRepo) Upsert(subscription core.Subscription) error {
 type MongoClient struct {
 	databaseName   string
 	collectionName string
+	timeout        time.Duration
 	session        *mgo.Session
 }
 
-func newMongoClient(uri, database, collection string) MongoClient {
-	mongo, err := mgo.Dial(uri)
+// collection returns a client sharing the session but bound to another collection.
+func (m MongoClient) collection(name string) MongoClient {
+	m.collectionName = name
+	return m
+}
+
+func newMongoClient(uri, database, collection string, timeout time.Duration) (MongoClient, error) {
+	mongo, err := mgo.DialWithTimeout(uri, timeout)
 	if err != nil {
-		log.Fatal(err)
+		return MongoClient{}, fmt.Errorf("Failed to connect to mongo: %s", err)
 	}
 	return MongoClient{
 		session:        mongo,
 		databaseName:   database,
 		collectionName: collection,
-	}
+		timeout:        timeout,
+	}, nil
 }
 
-func (m MongoClient) GetSession() (*mgo.Collection, func()) {
+// Run calls fn with the collection of a fresh session copy, closed once fn returns.
+// The session socket timeout is the smallest of the client timeout and the context deadline:
+// mgo operations can't be interrupted, so a cancelled request only stops between operations,
+// where the functions making several of them call checkpoint.
+// The operation is logged with the logger carried by ctx under the given name.
+func (m MongoClient) Run(ctx context.Context, op string, fn func(*mgo.Collection) error) (err error) {
+	ctx, span := tracing.Start(ctx, "mongo."+op, tracing.KindClient)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.name", m.databaseName)
+	span.SetAttribute("db.mongodb.collection", m.collectionName)
+	span.SetAttribute("db.operation", op)
+	logger := logging.FromContext(ctx).With("component", "mongo", "op", op, "collection", m.collectionName)
+	defer func(start time.Time) {
+		if err != mgo.ErrNotFound {
+			span.RecordError(err)
+		}
+		span.End()
+
+		if err != nil && err != mgo.ErrNotFound {
+			logger.Error("mongo operation failed", "duration", time.Since(start), "err", err)
+			return
+		}
+		logger.Debug("mongo operation", "duration", time.Since(start), "err", err)
+	}(time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s := m.session.Copy()
-	return s.DB(m.databaseName).C(m.collectionName), s.Close
+	defer s.Close()
+	s.SetSocketTimeout(socketTimeout(ctx, m.timeout))
+	return fn(s.DB(m.databaseName).C(m.collectionName))
+}
+
+// checkpoint is called by the Run functions between their operations: it returns ctx.Err()
+// once ctx is done, and otherwise bounds the next operation by the time left.
+func (m MongoClient) checkpoint(ctx context.Context, coll *mgo.Collection) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	coll.Database.Session.SetSocketTimeout(socketTimeout(ctx, m.timeout))
+	return nil
+}
+
+// minSocketTimeout keeps the socket timeout positive when the deadline is about to pass,
+// mgo reading a zero timeout as none.
+const minSocketTimeout = time.Millisecond
+
+// socketTimeout returns the smallest of timeout and the time left until the deadline of ctx.
+func socketTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d < timeout {
+			timeout = d
+		}
+	}
+	if timeout < minSocketTimeout {
+		return minSocketTimeout
+	}
+	return timeout
 }
@@ -0,0 +1,33 @@
+package mongorepository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSocketTimeout(t *testing.T) {
+	background := context.Background()
+	far, cancelFar := context.WithTimeout(background, time.Hour)
+	defer cancelFar()
+	near, cancelNear := context.WithTimeout(background, time.Second)
+	defer cancelNear()
+	past, cancelPast := context.WithDeadline(background, time.Now().Add(-time.Second))
+	defer cancelPast()
+
+	for _, c := range []struct {
+		name     string
+		ctx      context.Context
+		min, max time.Duration
+	}{
+		{"no deadline", background, 5 * time.Second, 5 * time.Second},
+		{"later deadline", far, 5 * time.Second, 5 * time.Second},
+		{"earlier deadline", near, 900 * time.Millisecond, time.Second},
+		// mgo reads a zero timeout as none.
+		{"passed deadline", past, minSocketTimeout, minSocketTimeout},
+	} {
+		if got := socketTimeout(c.ctx, 5*time.Second); got < c.min || got > c.max {
+			t.Errorf("%s: got %v, want between %v and %v", c.name, got, c.min, c.max)
+		}
+	}
+}
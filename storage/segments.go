@@ -0,0 +1,39 @@
+package mongorepository
+
+import (
+	"context"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func (m MongoRepo) FindSegments(ctx context.Context) ([]core.Segment, error) {
+	var segments []core.Segment
+	err := m.segments.Run(ctx, "find_segments", func(coll *mgo.Collection) error {
+		return coll.Find(nil).Sort("name").All(&segments)
+	})
+	return segments, translate(err, "segment")
+}
+
+func (m MongoRepo) FindSegment(ctx context.Context, slug string) (core.Segment, error) {
+	var segment core.Segment
+	err := m.segments.Run(ctx, "find_segment", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"slug": slug}).One(&segment)
+	})
+	return segment, translate(err, "segment")
+}
+
+func (m MongoRepo) UpsertSegment(ctx context.Context, segment core.Segment) error {
+	return translate(m.segments.Run(ctx, "upsert_segment", func(coll *mgo.Collection) error {
+		_, err := coll.Upsert(bson.M{"slug": segment.Slug}, segment)
+		return err
+	}), "segment")
+}
+
+func (m MongoRepo) RemoveSegment(ctx context.Context, slug string) error {
+	return translate(m.segments.Run(ctx, "remove_segment", func(coll *mgo.Collection) error {
+		return coll.Remove(bson.M{"slug": slug})
+	}), "segment")
+}
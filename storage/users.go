@@ -0,0 +1,34 @@
+package mongorepository
+
+import (
+	"context"
+
+	"github.com/klebervirgilio/go-echo-basics/core"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func (m MongoRepo) FindUser(ctx context.Context, name string) (core.User, error) {
+	var user core.User
+	err := m.users.Run(ctx, "find_user", func(coll *mgo.Collection) error {
+		return coll.Find(bson.M{"name": name}).One(&user)
+	})
+	return user, translate(err, "user")
+}
+
+func (m MongoRepo) UpsertUser(ctx context.Context, user core.User) error {
+	return translate(m.users.Run(ctx, "upsert_user", func(coll *mgo.Collection) error {
+		_, err := coll.Upsert(bson.M{"name": user.Name}, user)
+		return err
+	}), "user")
+}
+
+func (m MongoRepo) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := m.users.Run(ctx, "count_users", func(coll *mgo.Collection) (err error) {
+		n, err = coll.Count()
+		return err
+	})
+	return n, translate(err, "user")
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/klebervirgilio/go-echo-basics/auditlog"
+	"github.com/klebervirgilio/go-echo-basics/core"
+	"github.com/klebervirgilio/go-echo-basics/exporter"
+	"github.com/klebervirgilio/go-echo-basics/importer"
+	"github.com/klebervirgilio/go-echo-basics/mailchecker"
+	"github.com/klebervirgilio/go-echo-basics/segment"
+	mongorepository "github.com/klebervirgilio/go-echo-basics/storage"
+
+	"github.com/spf13/pflag"
+)
+
+// subscribersCommand manages the subscriptions:
+//
+//	mailist subscribers import [flags] file.csv
+//	mailist subscribers export [--list slug] [--status status] [--tag tag] [--format csv|jsonl|vcard] [--output file]
+//	mailist subscribers list [--list slug] [--status status] [--tag tag] [--limit n]
+//	mailist subscribers delete [--list slug] [--purge] email...
+func subscribersCommand(args []string) error {
+	return subcommand("subscribers", map[string]command{
+		"import": {"import [flags] file.csv", importCommand},
+		"export": {"export [--list slug] [--status status] [--tag tag] [--format csv|jsonl|vcard] [--output file]", exportCommand},
+		"list":   {"list [--list slug] [--status status] [--tag tag] [--limit n]", listCommand},
+		"delete": {"delete [--list slug] [--purge] email...", deleteCommand},
+	}, args)
+}
+
+// importCommand imports subscribers from a CSV or TSV file:
+//
+//	mailist subscribers import [--list slug] [--dry-run] [--map 'Column=target,...'] [--consent-source text] [--validate] [--errors report.csv] file.csv
+func importCommand(args []string) error {
+	flags := newFlagSet("subscribers import [flags] file.csv")
+	list := flags.String("list", "", "slug of the list to import to, the default list when empty")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without importing")
+	spec := flags.String("map", "", "column mapping, such as 'E-mail=email,Full Name=name,Company=company'")
+	consentSource := flags.String("consent-source", "", "where the subscribers gave their consent, recorded as their consent; required by the double opt-in lists")
+	validate := flags.Bool("validate", false, "validate the imported e-mails with the mail checker")
+	errorsFile := flags.String("errors", "", "write the rejected rows to this CSV file")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("subscribers import: expected one file, got %d", flags.NArg())
+	}
+
+	mapping, err := importer.ParseMapping(*spec)
+	if err != nil {
+		return err
+	}
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	if *list == "" {
+		*list = cfg.Lists.Default.Slug
+	}
+	target, err := repo.FindList(context.Background(), *list)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	report, err := importer.Import(ctx, repo, f, importer.Options{
+		List:          target,
+		Mapping:       mapping,
+		Comma:         importer.CommaFor(f.Name()),
+		DryRun:        *dryRun,
+		Tombstones:    repo,
+		ConsentSource: *consentSource,
+		Consents:      repo,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rows: %d, imported: %d, duplicates: %d, rejected: %d (dry run: %t)\n",
+		report.Total, report.Imported, report.Duplicates, len(report.Errors), report.DryRun)
+
+	if *errorsFile != "" && len(report.Errors) > 0 {
+		out, err := os.Create(*errorsFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := report.WriteErrors(out); err != nil {
+			return err
+		}
+	}
+
+	if *validate && !report.DryRun {
+		return importer.Validate(ctx, repo, core.RecordVerifications(apilayer.New(cfg), repo), report.Emails)
+	}
+	return nil
+}
+
+// selectorFlags adds the flags filtering the subscriptions to flags, and returns a function
+// building the selector once they are parsed.
+func selectorFlags(flags *pflag.FlagSet) func() map[string]interface{} {
+	list := flags.String("list", "", "only the subscriptions to this list")
+	status := flags.String("status", "", "only the subscriptions with this status")
+	tag := flags.String("tag", "", "only the subscriptions with this tag")
+	return func() map[string]interface{} {
+		selector := map[string]interface{}{}
+		if *list != "" {
+			selector["list"] = *list
+		}
+		if *status != "" {
+			selector["status"] = *status
+		}
+		if *tag != "" {
+			selector["tags"] = *tag
+		}
+		return selector
+	}
+}
+
+// exportCommand writes the subscriptions in the given format, to the standard output by default.
+func exportCommand(args []string) error {
+	flags := newFlagSet("subscribers export [flags]")
+	selector := selectorFlags(flags)
+	formatName := flags.String("format", exporter.CSV, "export format: csv, jsonl or vcard")
+	output := flags.StringP("output", "o", "", "write to this file instead of the standard output")
+	flags.Parse(args)
+
+	format, err := exporter.Lookup(*formatName)
+	if err != nil {
+		return err
+	}
+	_, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	fields, err := listFields(ctx, repo, selector())
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := format.NewWriter(out, fields...)
+	if err := repo.Each(ctx, selector(), w.Write); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// listFields returns the custom fields of the list in selector, or of all the lists.
+func listFields(ctx context.Context, lists core.ListRepository, selector map[string]interface{}) ([]string, error) {
+	if slug, ok := selector["list"].(string); ok {
+		list, err := lists.FindList(ctx, slug)
+		if err != nil {
+			return nil, err
+		}
+		return core.FieldNames(list), nil
+	}
+	all, err := lists.FindLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return core.FieldNames(all...), nil
+}
+
+// listCommand prints the subscriptions as a table.
+func listCommand(args []string) error {
+	flags := newFlagSet("subscribers list [flags]")
+	selector := selectorFlags(flags)
+	limit := flags.Int("limit", 0, "print at most this number of subscriptions, all when 0")
+	flags.Parse(args)
+
+	_, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tLIST\tSTATUS\tSUBSCRIBED\tVALID\tTAGS")
+	n := 0
+	err = repo.Each(context.Background(), selector(), func(s core.Subscription) error {
+		if *limit > 0 && n == *limit {
+			return io.EOF
+		}
+		n++
+		subscribed := ""
+		if !s.SubscribedAt.IsZero() {
+			subscribed = s.SubscribedAt.Format("2006-01-02")
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", s.Email, s.List, s.Status, subscribed, s.Valid, strings.Join(s.Tags, ","))
+		return err
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return w.Flush()
+}
+
+// deleteCommand moves the subscriptions of the given emails to the trash, or deletes them
+// permanently with --purge. Each deletion is recorded in the audit trail.
+func deleteCommand(args []string) error {
+	flags := newFlagSet("subscribers delete [--list slug] [--purge] email...")
+	list := flags.String("list", "", "only delete the subscriptions to this list, all of them when empty")
+	purge := flags.Bool("purge", false, "delete permanently instead of moving to the trash, including the subscriptions already in the trash")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("subscribers delete: no email given")
+	}
+
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	audit, err := auditlog.New(cfg, repo)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	n := 0
+	for _, email := range flags.Args() {
+		selector := map[string]interface{}{"email": email}
+		if *list != "" {
+			selector["list"] = *list
+		}
+		subscriptions, err := repo.FindAll(ctx, selector)
+		if err != nil {
+			return err
+		}
+		if *purge {
+			trashed, err := repo.FindAll(ctx, core.InTrash(selector))
+			if err != nil {
+				return err
+			}
+			subscriptions = append(subscriptions, trashed...)
+		}
+		for _, s := range subscriptions {
+			if err := deleteSubscription(ctx, repo, audit, s, *purge); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	if *purge {
+		fmt.Printf("%d subscriptions permanently deleted\n", n)
+	} else {
+		fmt.Printf("%d subscriptions moved to the trash\n", n)
+	}
+	return nil
+}
+
+func deleteSubscription(ctx context.Context, repo mongorepository.MongoRepo, audit core.AuditLog, s core.Subscription, purge bool) error {
+	selector := map[string]interface{}{"list": s.List, "email": s.Email}
+	action := "subscription.trash"
+	if purge {
+		action = "subscription.purge"
+		if err := repo.Remove(ctx, selector); err != nil {
+			return err
+		}
+	} else if _, err := repo.Trash(ctx, selector); err != nil {
+		return err
+	}
+	return audit.RecordAudit(ctx, core.AuditEntry{
+		Time:   time.Now(),
+		Actor:  core.ActorSystem,
+		Action: action,
+		Target: s.Email,
+		List:   s.List,
+		Before: map[string]interface{}{"status": s.Status, "tags": s.Tags},
+	})
+}
+
+// validateCommand validates the subscribed emails with the mail checker, and records the results:
+//
+//	mailist validate [--stale[=30d]] [--email email]... [--list slug]
+//
+// With --stale, only the emails never validated or last validated before the given period are.
+func validateCommand(args []string) error {
+	flags := newFlagSet("validate [--stale[=30d]] [--email email]... [--list slug]")
+	stale := flags.String("stale", "", "only validate the emails not validated for this period, such as 30d")
+	flags.Lookup("stale").NoOptDefVal = "30d"
+	emails := flags.StringSlice("email", nil, "only validate this email, can be repeated")
+	list := flags.String("list", "", "only validate the subscriptions to this list")
+	flags.Parse(args)
+
+	var period time.Duration
+	if *stale != "" {
+		var err error
+		if period, err = segment.ParseDuration(*stale); err != nil || period <= 0 {
+			return fmt.Errorf("validate: invalid --stale period %q", *stale)
+		}
+	}
+
+	cfg, repo, err := openRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	mailChecker := core.RecordVerifications(apilayer.New(cfg), repo)
+
+	ctx := context.Background()
+	candidates := *emails
+	if len(candidates) == 0 {
+		selector := map[string]interface{}{}
+		if *list != "" {
+			selector["list"] = *list
+		}
+		seen := map[string]bool{}
+		err := repo.Each(ctx, selector, func(s core.Subscription) error {
+			if !seen[s.Email] {
+				seen[s.Email] = true
+				candidates = append(candidates, s.Email)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cutoff := time.Now().Add(-period)
+	validated, skipped, failed := 0, 0, 0
+	for _, email := range candidates {
+		if period > 0 {
+			history, err := repo.FindVerifications(ctx, email)
+			if err != nil {
+				return err
+			}
+			if len(history) > 0 && history[0].Time.After(cutoff) {
+				skipped++
+				continue
+			}
+		}
+		if err := importer.Validate(ctx, repo, mailChecker, []string{email}); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", email, err)
+			failed++
+			if k := core.KindOf(err); k == core.QuotaExceeded || k == core.ProviderUnavailable {
+				break
+			}
+			continue
+		}
+		validated++
+	}
+	fmt.Printf("validated: %d, up to date: %d, failed: %d\n", validated, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("validate: %d emails failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes every span as a JSON line, for local use.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns an exporter writing to w, usually os.Stdout.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func (e *WriterExporter) Export(span SpanData) {
+	record := map[string]interface{}{
+		"traceId":    span.SpanContext.TraceID.String(),
+		"spanId":     span.SpanContext.SpanID.String(),
+		"name":       span.Name,
+		"kind":       span.Kind,
+		"start":      span.Start,
+		"durationMs": float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+		"attributes": span.Attributes,
+	}
+	if span.ParentSpanID.IsValid() {
+		record["parentSpanId"] = span.ParentSpanID.String()
+	}
+	if span.Err != nil {
+		record["error"] = span.Err.Error()
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// OTLPExporter sends the spans in batches to an OpenTelemetry collector with the OTLP/HTTP
+// JSON encoding. Spans are dropped, not blocked on, when the queue is full.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	batchSize   int
+	interval    time.Duration
+
+	queue   chan SpanData
+	stop    chan chan error
+	stopped chan struct{}
+	// OnError is called when a batch cannot be sent. It defaults to a no-op.
+	OnError func(error)
+}
+
+// NewOTLPExporter starts an exporter posting to endpoint + "/v1/traces".
+func NewOTLPExporter(endpoint, serviceName string, timeout time.Duration) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    strings.TrimRight(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: timeout},
+		batchSize:   512,
+		interval:    5 * time.Second,
+		queue:       make(chan SpanData, 2048),
+		stop:        make(chan chan error),
+		stopped:     make(chan struct{}),
+		OnError:     func(error) {},
+	}
+	go e.loop()
+	return e
+}
+
+func (e *OTLPExporter) Export(span SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// Shutdown sends the queued spans and stops the exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case e.stop <- done:
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) loop() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.send(batch)
+		batch = nil
+		if err != nil {
+			e.OnError(err)
+		}
+		return err
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-e.stop:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			done <- flush()
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector answered with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
+
+func (e *OTLPExporter) payload(batch []SpanData) map[string]interface{} {
+	spans := make([]map[string]interface{}, len(batch))
+	for i, s := range batch {
+		status := map[string]interface{}{"code": 1}
+		if s.Err != nil {
+			status = map[string]interface{}{"code": 2, "message": s.Err.Error()}
+		}
+		span := map[string]interface{}{
+			"traceId":           s.SpanContext.TraceID.String(),
+			"spanId":            s.SpanContext.SpanID.String(),
+			"name":              s.Name,
+			"kind":              s.Kind,
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes),
+			"status":            status,
+		}
+		if s.ParentSpanID.IsValid() {
+			span["parentSpanId"] = s.ParentSpanID.String()
+		}
+		spans[i] = span
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/klebervirgilio/go-echo-basics/tracing"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,265 @@
+// Package tracing is a minimal OpenTelemetry-style tracer: spans carried by context.Context,
+// W3C traceparent propagation and pluggable exporters (stdout and OTLP/HTTP).
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the ID is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// TraceparentHeader is the W3C Trace Context header.
+const TraceparentHeader = "traceparent"
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	for _, part := range parts[:4] {
+		if !isLowerHex(part) {
+			return sc, false
+		}
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// isLowerHex reports whether s only holds lowercase hexadecimal digits, as traceparent requires.
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Kind tells the role of a span, with the OTLP numbering.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Span is a timed operation.
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	name       string
+	kind       Kind
+	ctx        SpanContext
+	parent     SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+// SpanData is the read-only snapshot of an ended span handed to the exporters.
+type SpanData struct {
+	Name         string
+	Kind         Kind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Err          error
+}
+
+// SpanContext returns the span identifiers.
+func (s *Span) SpanContext() SpanContext {
+	return s.ctx
+}
+
+// SetAttribute records a key/value pair on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span and hands it to the exporter when sampled.
+// Calling End more than once has no effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	data := SpanData{
+		Name:         s.name,
+		Kind:         s.kind,
+		SpanContext:  s.ctx,
+		ParentSpanID: s.parent,
+		Start:        s.start,
+		End:          s.end,
+		Attributes:   s.attributes,
+		Err:          s.err,
+	}
+	s.mu.Unlock()
+
+	if s.ctx.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+// Exporter receives the ended spans.
+type Exporter interface {
+	Export(span SpanData)
+	// Shutdown flushes the pending spans.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts spans. A tracer without exporter still creates and propagates identifiers
+// but records nothing.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer handing its spans to exporter, which may be nil.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Default is the tracer used by the package level Start.
+var Default = NewTracer(nil)
+
+// Shutdown flushes the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start starts a span child of the span carried by ctx, if any, using the Default tracer.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	return Default.Start(ctx, name, kind)
+}
+
+// Start starts a span child of the span, or remote span context, carried by ctx.
+// A span without parent starts a new sampled trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent, ok := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !ok {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanID[:])
+
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		ctx:        sc,
+		parent:     parent.SpanID,
+		start:      time.Now(),
+		attributes: map[string]interface{}{},
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanContextFromContext returns the context of the span carried by ctx, or the remote span
+// context extracted from an incoming request.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return s.ctx, true
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+// Extract returns a copy of ctx carrying the remote span context found in the headers, if any.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := ParseTraceparent(h.Get(TraceparentHeader)); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header of an outgoing request from the span carried by ctx.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(span SpanData)               { e.spans = append(e.spans, span) }
+func (e *recordingExporter) Shutdown(ctx context.Context) error { return nil }
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		t.Fatalf("%s was rejected", traceparent)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("got %+v", sc)
+	}
+	if got := sc.Traceparent(); got != traceparent {
+		t.Errorf("got %s, want %s", got, traceparent)
+	}
+
+	sc, ok = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if !ok || sc.Sampled {
+		t.Errorf("an unsampled traceparent gave %+v, %v", sc, ok)
+	}
+	// Future versions may append fields.
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra"); !ok {
+		t.Error("a future version was rejected")
+	}
+}
+
+func TestParseMalformedTraceparent(t *testing.T) {
+	for _, h := range []string{
+		"",
+		"garbage",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"0-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if sc, ok := ParseTraceparent(h); ok {
+			t.Errorf("%q was accepted as %+v", h, sc)
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+
+	incoming := http.Header{}
+	incoming.Set(TraceparentHeader, traceparent)
+	ctx, server := tracer.Start(Extract(context.Background(), incoming), "GET /", KindServer)
+	ctx, client := tracer.Start(ctx, "POST apilayer", KindClient)
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	sc, ok := ParseTraceparent(outgoing.Get(TraceparentHeader))
+	if !ok {
+		t.Fatalf("injected an invalid traceparent %q", outgoing.Get(TraceparentHeader))
+	}
+	if sc != client.SpanContext() {
+		t.Errorf("injected %+v, want the client span %+v", sc, client.SpanContext())
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !sc.Sampled {
+		t.Errorf("the trace of the request is not continued: %+v", sc)
+	}
+
+	client.End()
+	server.End()
+	server.End()
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exporter.spans))
+	}
+	if got := exporter.spans[0].ParentSpanID; got != server.SpanContext().SpanID {
+		t.Errorf("client parent is %s, want %s", got, server.SpanContext().SpanID)
+	}
+	if got := exporter.spans[1].ParentSpanID.String(); got != "00f067aa0ba902b7" {
+		t.Errorf("server parent is %s, want the remote span", got)
+	}
+}
+
+func TestExtractIgnoresMalformedHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-nothex-00f067aa0ba902b7-01")
+	if sc, ok := SpanContextFromContext(Extract(context.Background(), h)); ok {
+		t.Errorf("a malformed header gave %+v", sc)
+	}
+
+	// A request without valid parent starts a new sampled trace.
+	_, span := NewTracer(nil).Start(Extract(context.Background(), h), "GET /", KindServer)
+	if !span.SpanContext().IsValid() || !span.SpanContext().Sampled {
+		t.Errorf("got %+v", span.SpanContext())
+	}
+}
+
+func TestUnsampledSpansAreNotExported(t *testing.T) {
+	exporter := &recordingExporter{}
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := NewTracer(exporter).Start(Extract(context.Background(), h), "GET /", KindServer)
+	span.End()
+	if len(exporter.spans) != 0 {
+		t.Errorf("exported %d unsampled spans", len(exporter.spans))
+	}
+
+	out := http.Header{}
+	Inject(context.Background(), out)
+	if out.Get(TraceparentHeader) != "" {
+		t.Error("injected a traceparent without span")
+	}
+}